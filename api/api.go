@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -15,16 +16,27 @@ type Request struct {
 	Command      string
 	Hashes       []string
 	Uris         []string
+	Temporary    bool
 	Addresses    []string
 	Bundles      []string
 	Tags         []string
 	Approvees    []string
 	Transactions []string
+	Tails        []string
 	Trytes       []string
+	Transaction  string
 	Reference    string
 	Depth        int
 	Timestamp    int
 	Filename     string
+	Verbose      bool
+	// for findTransactionsByTime
+	FromTimestamp int64
+	ToTimestamp   int64
+	Limit         int
+	Cursor        string
+	// for getBalancesAtMilestone, rollbackLedger and makeSnapshot
+	MilestoneIndex int
 	// for attachToTangle
 	TrunkTransaction   string
 	BranchTransaction  string
@@ -82,6 +94,7 @@ func Start(apiConfig *viper.Viper) {
 			apiCall, apiCallExists := apiCalls[caseInsensitiveCommand]
 			if apiCallExists {
 				apiCall(request, c, t)
+				observeDuration(caseInsensitiveCommand, t)
 			} else {
 				logs.Log.Error("Unknown command", request.Command)
 				ReplyError("No known command provided", c)
@@ -97,6 +110,14 @@ func Start(apiConfig *viper.Viper) {
 		enableSnapshotApi(api)
 	}
 
+	if config.GetBool("api.metrics.enabled") {
+		enableMetrics(api)
+	}
+
+	if config.GetBool("api.events.enabled") {
+		enableEvents(api)
+	}
+
 	useHttp := config.GetBool("api.http.useHttp")
 	useHttps := config.GetBool("api.https.useHttps")
 
@@ -184,6 +205,25 @@ func triesToAccessLimited(caseInsensitiveCommand string, c *gin.Context) bool {
 	return false
 }
 
+/*
+Returns whether the request comes from the local machine.
+*/
+func isLocalRequest(c *gin.Context) bool {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+/*
+Returns whether API authentication is configured, in which case all endpoints require it.
+*/
+func isAuthEnabled() bool {
+	return len(config.GetString("api.auth.username")) > 0 && len(config.GetString("api.auth.password")) > 0
+}
+
 func addAPICall(apiCall string, implementation func(request Request, c *gin.Context, t time.Time)) {
 	caseInsensitiveApiCall := strings.ToLower(apiCall)
 	apiCalls[caseInsensitiveApiCall] = implementation
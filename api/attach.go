@@ -20,6 +20,8 @@ import (
 const (
 	// not defined in giota library
 	MaxTimestampValue = 3812798742493 //int64(3^27 - 1) / 2
+	// not exported by the vendored giota library
+	TransactionTrinarySize = 8019
 )
 
 var mutex = &sync.Mutex{}
@@ -95,6 +97,21 @@ func getTimestampMilliseconds() int64 {
 // do everything with trytes and save time by not convertig to trits and back
 // all constants have to be divided by 3
 func attachToTangle(request Request, c *gin.Context, t time.Time) {
+	returnTrytes, err := doPoW(request.TrunkTransaction, request.BranchTransaction, request.MinWeightMagnitude, request.Trytes)
+	if err != nil {
+		ReplyError(err.Error(), c)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"trytes":   returnTrytes,
+		"duration": getDuration(t),
+	})
+}
+
+// doPoW attaches the transaction trytes to the given trunk and branch, like attachToTangle:
+// the first transaction approves both, the next ones the previous transaction and the trunk
+func doPoW(trunk string, branch string, minWeightMagnitude int, trytes []string) ([]string, error) {
 	// only one attatchToTangle allowed in parallel
 	mutex.Lock()
 	defer mutex.Unlock()
@@ -103,41 +120,32 @@ func attachToTangle(request Request, c *gin.Context, t time.Time) {
 
 	var returnTrytes []string
 
-	trunkTransaction, err := toRunesCheckTrytes(request.TrunkTransaction, giota.TrunkTransactionTrinarySize/3)
+	trunkTransaction, err := toRunesCheckTrytes(trunk, giota.TrunkTransactionTrinarySize/3)
 	if err != nil {
-		ReplyError("Invalid trunkTransaction-Trytes", c)
-		return
+		return nil, errors.New("Invalid trunkTransaction-Trytes")
 	}
 
-	branchTransaction, err := toRunesCheckTrytes(request.BranchTransaction, giota.BranchTransactionTrinarySize/3)
+	branchTransaction, err := toRunesCheckTrytes(branch, giota.BranchTransactionTrinarySize/3)
 	if err != nil {
-		ReplyError("Invalid branchTransaction-Trytes", c)
-		return
+		return nil, errors.New("Invalid branchTransaction-Trytes")
 	}
 
-	minWeightMagnitude := request.MinWeightMagnitude
-
 	// restrict minWeightMagnitude
 	if minWeightMagnitude > maxMinWeightMagnitude {
-		ReplyError("MinWeightMagnitude too high", c)
-		return
+		return nil, errors.New("MinWeightMagnitude too high")
 	}
 
-	trytes := request.Trytes
-
 	// limit number of transactions in a bundle
 	if len(trytes) > maxTransactions {
-		ReplyError("Too many transactions", c)
-		return
+		return nil, errors.New("Too many transactions")
 	}
 	returnTrytes = make([]string, len(trytes))
 	inputRunes := make([][]rune, len(trytes))
 
 	// validate input trytes before doing PoW
 	for idx, tryte := range trytes {
-		if runes, err := toRunesCheckTrytes(tryte, giota.TransactionTrinarySize/3); err != nil {
-			ReplyError("Error in Tryte input", c)
-			return
+		if runes, err := toRunesCheckTrytes(tryte, TransactionTrinarySize/3); err != nil {
+			return nil, errors.New("Error in Tryte input")
 		} else {
 			inputRunes[idx] = runes
 		}
@@ -149,8 +157,7 @@ func attachToTangle(request Request, c *gin.Context, t time.Time) {
 		if usePowSrv {
 			serverVersion, powType, powVersion, err = powClient.GetPowInfo()
 			if err != nil {
-				ReplyError(err.Error(), c)
-				return
+				return nil, err
 			}
 
 			powFunc = powClient.PowFunc
@@ -173,8 +180,7 @@ func attachToTangle(request Request, c *gin.Context, t time.Time) {
 	// do pow
 	for idx, runes := range inputRunes {
 		if interruptAttachToTangle {
-			ReplyError("attatchToTangle interrupted", c)
-			return
+			return nil, errors.New("attatchToTangle interrupted")
 		}
 		timestamp := getTimestampMilliseconds()
 		//branch and trunk
@@ -207,8 +213,7 @@ func attachToTangle(request Request, c *gin.Context, t time.Time) {
 		startTime := time.Now()
 		nonceTrytes, err := powFunc(giota.Trytes(runes), minWeightMagnitude)
 		if err != nil || len(nonceTrytes) != giota.NonceTrinarySize/3 {
-			ReplyError(fmt.Sprintf("PoW failed! %v", err.Error()), c)
-			return
+			return nil, fmt.Errorf("PoW failed! %v", err)
 		}
 		elapsedTime := time.Now().Sub(startTime)
 		logs.Log.Debug("[PoW] Needed", elapsedTime)
@@ -218,15 +223,13 @@ func attachToTangle(request Request, c *gin.Context, t time.Time) {
 
 		verifyTrytes, err := giota.ToTrytes(string(runes))
 		if err != nil {
-			ReplyError("Trytes got corrupted", c)
-			return
+			return nil, errors.New("Trytes got corrupted")
 		}
 
 		//validate PoW - throws exception if invalid
 		hash := verifyTrytes.Hash()
 		if !IsValidPoW(hash.Trits(), minWeightMagnitude) {
-			ReplyError("Nonce verify failed", c)
-			return
+			return nil, errors.New("Nonce verify failed")
 		}
 
 		logs.Log.Debug("[PoW] Verified!")
@@ -236,8 +239,5 @@ func attachToTangle(request Request, c *gin.Context, t time.Time) {
 		prevTransaction = toRunes(hash)
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"trytes":   returnTrytes,
-		"duration": getDuration(t),
-	})
+	return returnTrytes, nil
 }
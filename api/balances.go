@@ -10,7 +10,6 @@ import (
 	"../db"
 	"../logs"
 	"../tangle"
-	"github.com/dgraph-io/badger"
 	"github.com/gin-gonic/gin"
 )
 
@@ -50,39 +49,22 @@ func getBalances(request Request, c *gin.Context, t time.Time) {
 
 func listAllAccounts(request Request, c *gin.Context, t time.Time) {
 	var accounts = make(map[string]interface{})
-	db.DB.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = true
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := []byte{db.KEY_BALANCE}
-
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			item := it.Item()
-			key := item.Key()
-			v, err := item.Value()
-			if err == nil {
-				var value int64 = 0
-				buf := bytes.NewBuffer(v)
-				dec := gob.NewDecoder(buf)
-				err := dec.Decode(&value)
-				if err == nil {
-					// Do not save zero-value addresses
-					if value == 0 {
-						continue
-					}
-
-					accounts[convert.BytesToTrytes(key[1:])[:81]] = value
-				} else {
-					logs.Log.Error("Could not parse a snapshot value from database!", err)
-					return err
-				}
-			} else {
-				logs.Log.Error("Could not read a snapshot value from database!", err)
-				return err
+	db.DB.View(func(txn db.Txn) error {
+		return txn.ForPrefix([]byte{db.KEY_BALANCE}, true, func(key []byte, v []byte) (bool, error) {
+			var value int64 = 0
+			buf := bytes.NewBuffer(v)
+			dec := gob.NewDecoder(buf)
+			err := dec.Decode(&value)
+			if err != nil {
+				logs.Log.Error("Could not parse a snapshot value from database!", err)
+				return false, err
 			}
-		}
-		return nil
+			// Do not save zero-value addresses
+			if value != 0 {
+				accounts[convert.BytesToTrytes(key[1:])[:81]] = value
+			}
+			return true, nil
+		})
 	})
 	c.JSON(http.StatusOK, gin.H{
 		"accounts":       accounts,
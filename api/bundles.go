@@ -9,7 +9,6 @@ import (
 	"../logs"
 	"../tangle"
 	"../transaction"
-	"github.com/dgraph-io/badger"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
 )
@@ -28,8 +27,6 @@ func broadcastTransactions(request Request, c *gin.Context, t time.Time) {
 }
 
 func storeAndBroadcastTransactions(request Request, c *gin.Context, broadcast bool, t time.Time) {
-	var stored = 0
-	var broadcasted = 0
 	if request.Trytes == nil || len(request.Trytes) < 1 {
 		ReplyError("No trytes provided", c)
 		return
@@ -38,8 +35,27 @@ func storeAndBroadcastTransactions(request Request, c *gin.Context, broadcast bo
 		ReplyError("Invalid bundle", c)
 		return
 	}
-	for _, trytes := range request.Trytes {
-		err := db.DB.Update(func(txn *badger.Txn) (e error) {
+	stored, broadcasted, err := saveAndBroadcast(request.Trytes, broadcast)
+	if err != nil {
+		ReplyError("Error encountered while saving a transaction", c)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"stored":      stored,
+		"broadcasted": broadcasted,
+		"duration":    getDuration(t),
+	})
+}
+
+/*
+Saves the transactions of a validated bundle that are not known yet and broadcasts all of them if requested.
+Returns the numbers of stored and broadcasted transactions.
+*/
+func saveAndBroadcast(trytesList []string, broadcast bool) (int, int, error) {
+	var stored = 0
+	var broadcasted = 0
+	for _, trytes := range trytesList {
+		err := db.DB.Update(func(txn db.Txn) (e error) {
 			trits := convert.TrytesToTrits(trytes)
 			bits := convert.TrytesToBytes(trytes)[:1604]
 			tx := transaction.TritsToTX(&trits, bits)
@@ -75,13 +91,8 @@ func storeAndBroadcastTransactions(request Request, c *gin.Context, broadcast bo
 		})
 		if err != nil {
 			logs.Log.Warning("Error while saving transaction. ", err)
-			ReplyError("Error encountered while saving a transaction", c)
-			return
+			return stored, broadcasted, err
 		}
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"stored":      stored,
-		"broadcasted": broadcasted,
-		"duration":    getDuration(t),
-	})
+	return stored, broadcasted, nil
 }
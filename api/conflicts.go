@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"../convert"
+	"../tangle"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	addAPICall("getConflicts", getConflicts)
+}
+
+func getConflicts(request Request, c *gin.Context, t time.Time) {
+	var addresses [][]byte
+	for _, address := range request.Addresses {
+		if !convert.IsTrytes(address, 81) {
+			ReplyError("Wrong trytes", c)
+			return
+		}
+		addresses = append(addresses, convert.TrytesToBytes(address)[:49])
+	}
+
+	conflicts, err := tangle.GetConflicts(addresses)
+	if err != nil {
+		ReplyError("Could not get the conflicts: "+err.Error(), c)
+		return
+	}
+	response := []gin.H{}
+	for _, conflict := range conflicts {
+		var bundles []gin.H
+		for _, b := range conflict.Bundles {
+			bundles = append(bundles, gin.H{
+				"bundle":    convert.BytesToTrytes(b.Bundle)[:81],
+				"value":     b.Value,
+				"confirmed": b.Confirmed,
+			})
+		}
+		response = append(response, gin.H{
+			"address":  convert.BytesToTrytes(conflict.Address)[:81],
+			"bundles":  bundles,
+			"detected": conflict.Time,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"conflicts": response,
+		"duration":  getDuration(t),
+	})
+}
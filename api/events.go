@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"../events"
+	"../logs"
+	"github.com/gin-gonic/gin"
+)
+
+const eventsPingInterval = time.Duration(30) * time.Second
+
+type eventsCommand struct {
+	Subscribe   []string
+	Unsubscribe []string
+}
+
+/*
+Streams the node events over a WebSocket on /events. Every event is sent as one text message
+with the same layout as the IRI ZMQ feed (e.g. "lmi 512 513").
+
+Browsers can only connect from pages of the node's host or the allowed origins.
+
+Topics can be given on connect (/events?topics=tx,sn) and changed with JSON messages:
+{"subscribe": ["lmi", "<address>"]} or {"unsubscribe": ["tx"]}
+*/
+func enableEvents(api *gin.Engine) {
+	api.GET("/events", func(c *gin.Context) {
+		ws, err := upgradeWebSocket(c.Writer, c.Request, config.GetStringSlice("api.events.allowedOrigins"))
+		if err != nil {
+			ReplyError("WebSocket upgrade failed: "+err.Error(), c)
+			return
+		}
+		streamEvents(ws, c.Request)
+	})
+}
+
+func streamEvents(ws *wsConnection, r *http.Request) {
+	defer ws.close()
+
+	var topics []string
+	if query := r.URL.Query().Get("topics"); len(query) > 0 {
+		topics = strings.Split(query, ",")
+	}
+	subscriber := events.Subscribe(topics...)
+	defer subscriber.Close()
+	logs.Log.Debugf("Events subscriber connected from %v", r.RemoteAddr)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			message, err := ws.readMessage()
+			if err != nil {
+				return
+			}
+			var command eventsCommand
+			if json.Unmarshal(message, &command) != nil {
+				ws.writeText("error Invalid command")
+				continue
+			}
+			subscriber.Subscribe(command.Subscribe...)
+			subscriber.Unsubscribe(command.Unsubscribe...)
+		}
+	}()
+
+	ping := time.NewTicker(eventsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-done:
+			logs.Log.Debugf("Events subscriber %v disconnected", r.RemoteAddr)
+			return
+		case <-ping.C:
+			if ws.writeFrame(wsOpPing, nil) != nil {
+				return
+			}
+		case message := <-subscriber.Messages:
+			if ws.writeText(message) != nil {
+				return
+			}
+		}
+	}
+}
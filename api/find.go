@@ -6,7 +6,6 @@ import (
 
 	"../convert"
 	"../db"
-	"github.com/dgraph-io/badger"
 	"github.com/gin-gonic/gin"
 )
 
@@ -64,20 +63,15 @@ func findAddresses(trits []byte, single bool) []string {
 
 func find(trits []byte, prefix byte) []string {
 	var response = []string{}
-	_ = db.DB.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = false
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := db.GetByteKey(trits, prefix)
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			key := db.AsKey(it.Item().Key()[16:], db.KEY_HASH)
+	_ = db.DB.View(func(txn db.Txn) error {
+		return txn.ForPrefix(db.GetByteKey(trits, prefix), false, func(k []byte, _ []byte) (bool, error) {
+			key := db.AsKey(k[16:], db.KEY_HASH)
 			hash, err := db.GetBytes(key, txn)
 			if err == nil {
 				response = append(response, convert.BytesToTrytes(hash)[:81])
 			}
-		}
-		return nil
+			return true, nil
+		})
 	})
 	return response
 }
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"../convert"
+	"../db"
+	"../tangle"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+func init() {
+	addAPICall("findTransactionsByTime", findTransactionsByTime)
+}
+
+func findTransactionsByTime(request Request, c *gin.Context, t time.Time) {
+	if !tangle.IsPermanode() {
+		ReplyError("The time-ordered indexes are only available in the permanode mode", c)
+		return
+	}
+
+	var prefix []byte
+	count := len(request.Addresses) + len(request.Bundles) + len(request.Tags)
+	if count != 1 {
+		ReplyError("Exactly one address, bundle or tag has to be provided", c)
+		return
+	}
+	if len(request.Addresses) == 1 {
+		if !convert.IsTrytes(request.Addresses[0], 81) {
+			ReplyError("Wrong address trytes", c)
+			return
+		}
+		prefix = db.GetByteKey(convert.TrytesToBytes(request.Addresses[0])[:49], db.KEY_ADDRESS_TIME)
+	} else if len(request.Bundles) == 1 {
+		if !convert.IsTrytes(request.Bundles[0], 81) {
+			ReplyError("Wrong bundle trytes", c)
+			return
+		}
+		prefix = db.GetByteKey(convert.TrytesToBytes(request.Bundles[0])[:49], db.KEY_BUNDLE_TIME)
+	} else {
+		if !convert.IsTrytes(request.Tags[0], 27) {
+			ReplyError("Wrong tag trytes", c)
+			return
+		}
+		prefix = db.GetByteKey(convert.TrytesToBytes(request.Tags[0]), db.KEY_TAG_TIME)
+	}
+
+	to := request.ToTimestamp
+	if to == 0 {
+		to = time.Now().Unix()
+	}
+	if request.FromTimestamp < 0 || to < request.FromTimestamp {
+		ReplyError("Wrong timestamp range", c)
+		return
+	}
+	limit := request.Limit
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+	var start []byte
+	if len(request.Cursor) > 0 {
+		cursor, err := hex.DecodeString(request.Cursor)
+		if err != nil || len(cursor) != 24 {
+			ReplyError("Wrong cursor", c)
+			return
+		}
+		start = append(append([]byte{}, prefix...), cursor...)
+	}
+
+	entries, next, err := tangle.FindInTimeRange(prefix, request.FromTimestamp, to, start, limit)
+	if err != nil {
+		ReplyError("Could not read the time-ordered index", c)
+		return
+	}
+	var transactions = []gin.H{}
+	for _, entry := range entries {
+		transactions = append(transactions, gin.H{
+			"hash":      convert.BytesToTrytes(entry.Hash)[:81],
+			"timestamp": entry.Timestamp,
+		})
+	}
+	var cursor interface{}
+	if next != nil {
+		cursor = hex.EncodeToString(next[len(prefix):])
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"transactions":  transactions,
+		"cursor":        cursor,
+		"indexComplete": tangle.IsHistoryIndexReady(),
+		"duration":      getDuration(t),
+	})
+}
@@ -24,14 +24,8 @@ func getNodeInfo(request Request, c *gin.Context, t time.Time) {
 	defer server.NeighborsLock.RUnlock()
 
 	milestone := convert.BytesToTrytes(tangle.LatestMilestone.TX.Hash)[:81]
-	index := tangle.LatestMilestone.Index
-	solid := dummyHash
-	sindex := 500000
-	if snapshot.IsSynchronized() {
-		solid = milestone
-		sindex = index
-	}
-	c.JSON(http.StatusOK, gin.H{
+	solid := convert.BytesToTrytes(tangle.LatestSolidMilestone.TX.Hash)[:81]
+	info := gin.H{
 		"appName":                            "CarrIOTA Hercules Go",
 		"appVersion":                         "0.1.0",
 		"availableProcessors":                runtime.NumCPU(),
@@ -40,7 +34,7 @@ func getNodeInfo(request Request, c *gin.Context, t time.Time) {
 		"latestMilestone":                    milestone,
 		"latestMilestoneIndex":               tangle.LatestMilestone.Index,
 		"latestSolidSubtangleMilestone":      solid,
-		"latestSolidSubtangleMilestoneIndex": sindex,
+		"latestSolidSubtangleMilestoneIndex": tangle.LatestSolidMilestone.Index,
 		"neighbors":                          len(server.Neighbors),
 		"currentSnapshotTimestamp":           snapshot.CurrentTimestamp,
 		"currentSnapshotTimeHumanReadable":   utils.GetHumanReadableTime(snapshot.CurrentTimestamp),
@@ -48,5 +42,14 @@ func getNodeInfo(request Request, c *gin.Context, t time.Time) {
 		"tips":                               len(tangle.Tips),
 		"time":                               time.Now().Unix(),
 		"duration":                           getDuration(t),
-	})
+	}
+	if tangle.IsPermanode() {
+		info["permanode"] = true
+		oldest := tangle.GetOldestTransaction()
+		if oldest != nil {
+			info["oldestTransaction"] = convert.BytesToTrytes(oldest.Hash)[:81]
+			info["oldestTransactionTimestamp"] = oldest.Timestamp
+		}
+	}
+	c.JSON(http.StatusOK, info)
 }
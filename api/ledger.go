@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"../convert"
+	"../tangle"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	addAPICall("getBalancesAtMilestone", getBalancesAtMilestone)
+	addAPICall("rollbackLedger", rollbackLedger)
+}
+
+func getBalancesAtMilestone(request Request, c *gin.Context, t time.Time) {
+	var addresses [][]byte
+	for _, address := range request.Addresses {
+		if !convert.IsTrytes(address, 81) {
+			ReplyError("Wrong trytes", c)
+			return
+		}
+		addresses = append(addresses, convert.TrytesToBytes(address)[:49])
+	}
+
+	balances, err := tangle.GetBalancesAt(addresses, request.MilestoneIndex)
+	if err != nil {
+		ReplyError("Could not get the balances: "+err.Error(), c)
+		return
+	}
+	if balances == nil {
+		balances = []int64{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"balances":       balances,
+		"milestoneIndex": request.MilestoneIndex,
+		"duration":       getDuration(t),
+	})
+}
+
+func rollbackLedger(request Request, c *gin.Context, t time.Time) {
+	err := tangle.RollbackLedger(request.MilestoneIndex)
+	if err != nil {
+		ReplyError("Could not roll back the ledger: "+err.Error(), c)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"milestoneIndex": request.MilestoneIndex,
+		"duration":       getDuration(t),
+	})
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"../metrics"
+	"github.com/gin-gonic/gin"
+)
+
+var requestDuration = metrics.NewHistogram("hercules_api_request_duration_seconds",
+	"Duration of the API calls per command", "command", metrics.DurationBuckets)
+
+/*
+Serves all registered metrics in the Prometheus text format on /metrics. The metrics include the
+addresses of the neighbors, so without API authentication they are only served to local clients.
+*/
+func enableMetrics(api *gin.Engine) {
+	api.GET("/metrics", func(c *gin.Context) {
+		if !isAuthEnabled() && !isLocalRequest(c) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(http.StatusOK)
+		metrics.Write(c.Writer)
+	})
+}
+
+func observeDuration(caseInsensitiveCommand string, t time.Time) {
+	requestDuration.Observe(caseInsensitiveCommand, time.Now().Sub(t).Seconds())
+}
@@ -24,7 +24,7 @@ func addNeighbors(request Request, c *gin.Context, t time.Time) {
 			address = strings.TrimPrefix(address, " ")
 			address = strings.TrimSuffix(address, " ")
 			logs.Log.Infof("Adding neighbor: '%v'", address)
-			err := server.AddNeighbor(address)
+			err := server.AddNeighbor(address, !request.Temporary)
 			if err == nil {
 				added++
 			} else {
@@ -82,15 +82,26 @@ func getNeighbors(request Request, c *gin.Context, t time.Time) {
 			"numberOfAllTransactions":     neighbor.Incoming,
 			"numberOfInvalidTransactions": neighbor.Invalid,
 			"numberOfNewTransactions":     neighbor.New,
-			"connectionType":              neighbor.ConnectionType})
+			"connectionType":              neighbor.ConnectionType,
+			"permanent":                   neighbor.Permanent,
+			"health":                      neighbor.Health.Report()})
 	}
 
 	if neighbors == nil {
 		neighbors = make([]interface{}, 0)
 	}
 
+	var banned = []interface{}{}
+	for _, ban := range server.GetBans() {
+		banned = append(banned, gin.H{
+			"address": ban.Address,
+			"until":   ban.Until.Unix(),
+			"reason":  ban.Reason})
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"neighbors": neighbors,
+		"banned":    banned,
 		"duration":  getDuration(t),
 	})
 }
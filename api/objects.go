@@ -0,0 +1,206 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"../convert"
+	"../db"
+	"../tangle"
+	"../transaction"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	addAPICall("getTransactionObjects", getTransactionObjects)
+	addAPICall("getBundle", getBundle)
+	addAPICall("getTransactionMetadata", getTransactionMetadata)
+}
+
+func getTransactionObjects(request Request, c *gin.Context, t time.Time) {
+	var objects = []interface{}{}
+	for _, hash := range request.Hashes {
+		if !convert.IsTrytes(hash, 81) {
+			ReplyError("Wrong hash trytes", c)
+			return
+		}
+	}
+	_ = db.DB.View(func(txn db.Txn) error {
+		for _, hash := range request.Hashes {
+			key := db.GetByteKey(convert.TrytesToBytes(hash)[:49], db.KEY_HASH)
+			txBytes, err := db.GetBytes(db.AsKey(key, db.KEY_BYTES), txn)
+			if err != nil {
+				objects = append(objects, nil)
+				continue
+			}
+			objects = append(objects, transactionObject(key, txBytes, txn))
+		}
+		return nil
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"transactions": objects,
+		"duration":     getDuration(t),
+	})
+}
+
+/*
+Returns the bundle of the given tail transaction, ordered by index, following the trunk transactions.
+*/
+func getBundle(request Request, c *gin.Context, t time.Time) {
+	if !convert.IsTrytes(request.Transaction, 81) {
+		ReplyError("Wrong transaction trytes", c)
+		return
+	}
+
+	var objects []interface{}
+	var txs []*transaction.FastTX
+	var bundleError string
+	_ = db.DB.View(func(txn db.Txn) error {
+		hash := convert.TrytesToBytes(request.Transaction)[:49]
+		var bundle []byte
+		for index := 0; ; index++ {
+			key := db.GetByteKey(hash, db.KEY_HASH)
+			txBytes, err := db.GetBytes(db.AsKey(key, db.KEY_BYTES), txn)
+			if err != nil {
+				if index == 0 {
+					bundleError = "Transaction not found"
+				} else {
+					bundleError = fmt.Sprintf("Transaction at index %v is missing: %v", index, convert.BytesToTrytes(hash)[:81])
+				}
+				return nil
+			}
+			trits := convert.BytesToTrits(txBytes)[:8019]
+			tx := transaction.TritsToTX(&trits, txBytes)
+			object := transaction.TrytesToObject(convert.BytesToTrytes(txBytes)[:2673])
+			if object == nil {
+				bundleError = fmt.Sprintf("Transaction at index %v cannot be decoded", index)
+				return nil
+			}
+			if index == 0 {
+				if tx.CurrentIndex != 0 {
+					bundleError = "Transaction is not a tail transaction"
+					return nil
+				}
+				bundle = tx.Bundle
+			} else if !bytes.Equal(tx.Bundle, bundle) {
+				bundleError = fmt.Sprintf("Transaction at index %v belongs to another bundle", index)
+				return nil
+			} else if tx.CurrentIndex != index {
+				bundleError = fmt.Sprintf("Transaction at index %v has the index %v", index, tx.CurrentIndex)
+				return nil
+			}
+
+			txs = append(txs, tx)
+			objects = append(objects, transactionObject(key, txBytes, txn))
+			if object.LastIndex <= index {
+				return nil
+			}
+			hash = tx.TrunkTransaction
+		}
+	})
+	if len(bundleError) > 0 {
+		ReplyError(bundleError, c)
+		return
+	}
+
+	err := transaction.ValidateBundle(txs)
+	if err != nil {
+		ReplyError("Invalid bundle: "+err.Error(), c)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"transactions": objects,
+		"duration":     getDuration(t),
+	})
+}
+
+/*
+Returns the decoded transaction fields and the metadata known by the node.
+*/
+func transactionObject(key []byte, txBytes []byte, txn db.Txn) gin.H {
+	tx := transaction.TrytesToObject(convert.BytesToTrytes(txBytes)[:2673])
+	if tx == nil {
+		return nil
+	}
+	object := gin.H{
+		"hash":                          tx.Hash,
+		"signatureMessageFragment":      tx.SignatureMessageFragment,
+		"address":                       tx.Address,
+		"value":                         tx.Value,
+		"obsoleteTag":                   tx.ObsoleteTag,
+		"timestamp":                     tx.Timestamp,
+		"currentIndex":                  tx.CurrentIndex,
+		"lastIndex":                     tx.LastIndex,
+		"bundle":                        tx.Bundle,
+		"trunkTransaction":              tx.TrunkTransaction,
+		"branchTransaction":             tx.BranchTransaction,
+		"tag":                           tx.Tag,
+		"attachmentTimestamp":           tx.AttachmentTimestamp,
+		"attachmentTimestampLowerBound": tx.AttachmentTimestampLowerBound,
+		"attachmentTimestampUpperBound": tx.AttachmentTimestampUpperBound,
+		"nonce":                         tx.Nonce,
+	}
+	for field, value := range transactionMetadata(key, txn) {
+		object[field] = value
+	}
+	return object
+}
+
+/*
+Returns, per hash, what the node knows about the transaction besides its fields: arrival,
+confirming milestone and confirmation time (all unix times), and the confirmation latency in seconds.
+Unknown hashes are returned as null.
+*/
+func getTransactionMetadata(request Request, c *gin.Context, t time.Time) {
+	for _, hash := range request.Hashes {
+		if !convert.IsTrytes(hash, 81) {
+			ReplyError("Wrong hash trytes", c)
+			return
+		}
+	}
+	var objects = []interface{}{}
+	_ = db.DB.View(func(txn db.Txn) error {
+		for _, hash := range request.Hashes {
+			key := db.GetByteKey(convert.TrytesToBytes(hash)[:49], db.KEY_HASH)
+			if !db.Has(db.AsKey(key, db.KEY_BYTES), txn) {
+				objects = append(objects, nil)
+				continue
+			}
+			object := transactionMetadata(key, txn)
+			object["hash"] = hash
+			objects = append(objects, object)
+		}
+		return nil
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"transactions": objects,
+		"duration":     getDuration(t),
+	})
+}
+
+func transactionMetadata(key []byte, txn db.Txn) gin.H {
+	object := gin.H{
+		"confirmed":                db.Has(db.AsKey(key, db.KEY_CONFIRMED), txn),
+		"confirmingMilestoneIndex": nil,
+		"confirmationTime":         nil,
+		"arrivalTime":              nil,
+		"confirmationLatency":      nil,
+	}
+	arrivalTime, err := db.GetInt64(db.AsKey(key, db.KEY_ARRIVAL_TIME), txn)
+	if err == nil {
+		object["arrivalTime"] = arrivalTime
+	}
+	confirmation := tangle.GetConfirmation(key, txn)
+	if confirmation != nil {
+		if confirmation.MilestoneIndex > 0 {
+			object["confirmingMilestoneIndex"] = confirmation.MilestoneIndex
+		}
+		object["confirmationTime"] = confirmation.Time
+		if err == nil {
+			object["confirmationLatency"] = confirmation.Time - arrivalTime
+		}
+	}
+	return object
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"../snapshot"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	addAPICall("addPin", addPin)
+	addAPICall("removePin", removePin)
+	addAPICall("listPins", listPins)
+}
+
+func addPin(request Request, c *gin.Context, t time.Time) {
+	pins, ok := getRequestPins(request, c)
+	if !ok {
+		return
+	}
+	for _, pin := range pins {
+		err := snapshot.AddPin(pin)
+		if err != nil {
+			ReplyError("Could not add pin: "+err.Error(), c)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"addedPins": len(pins),
+		"duration":  getDuration(t),
+	})
+}
+
+func removePin(request Request, c *gin.Context, t time.Time) {
+	pins, ok := getRequestPins(request, c)
+	if !ok {
+		return
+	}
+	for _, pin := range pins {
+		err := snapshot.RemovePin(pin)
+		if err != nil {
+			ReplyError("Could not remove pin: "+err.Error(), c)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"removedPins": len(pins),
+		"duration":    getDuration(t),
+	})
+}
+
+func listPins(request Request, c *gin.Context, t time.Time) {
+	var result = map[string][]string{
+		snapshot.PIN_TRANSACTION: {},
+		snapshot.PIN_BUNDLE:      {},
+		snapshot.PIN_ADDRESS:     {},
+		snapshot.PIN_TAG:         {},
+	}
+	for _, pin := range snapshot.GetPins() {
+		result[pin.Type] = append(result[pin.Type], pin.Hash)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"transactions": result[snapshot.PIN_TRANSACTION],
+		"bundles":      result[snapshot.PIN_BUNDLE],
+		"addresses":    result[snapshot.PIN_ADDRESS],
+		"tags":         result[snapshot.PIN_TAG],
+		"duration":     getDuration(t),
+	})
+}
+
+func getRequestPins(request Request, c *gin.Context) ([]*snapshot.Pin, bool) {
+	var pins []*snapshot.Pin
+	for pinType, hashes := range map[string][]string{
+		snapshot.PIN_TRANSACTION: request.Transactions,
+		snapshot.PIN_BUNDLE:      request.Bundles,
+		snapshot.PIN_ADDRESS:     request.Addresses,
+		snapshot.PIN_TAG:         request.Tags,
+	} {
+		for _, hash := range hashes {
+			pin, err := snapshot.NewPin(pinType, hash)
+			if err != nil {
+				ReplyError("Wrong pin: "+err.Error(), c)
+				return nil, false
+			}
+			pins = append(pins, pin)
+		}
+	}
+	if len(pins) == 0 {
+		ReplyError("No transactions, bundles, addresses or tags provided", c)
+		return nil, false
+	}
+	return pins, true
+}
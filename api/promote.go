@@ -0,0 +1,139 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"../convert"
+	"../db"
+	"../tangle"
+	"../transaction"
+	"github.com/gin-gonic/gin"
+	"github.com/iotaledger/giota"
+)
+
+const promotionTag = "HERCULES9PROMOTE"
+
+func init() {
+	addAPICall("promoteTransaction", promoteTransaction)
+	addAPICall("replayBundle", replayBundle)
+}
+
+/*
+Attaches a zero-value transaction approving the given tail directly and through a tip selected from it.
+*/
+func promoteTransaction(request Request, c *gin.Context, t time.Time) {
+	hash, ok := checkReattachRequest(request, c)
+	if !ok {
+		return
+	}
+	if db.Has(db.GetByteKey(hash, db.KEY_CONFIRMED), nil) {
+		ReplyError("Transaction is already confirmed", c)
+		return
+	}
+	consistent, info, err := tangle.CheckConsistency([][]byte{hash})
+	if err != nil {
+		ReplyError(err.Error(), c)
+		return
+	}
+	if !consistent {
+		ReplyError("Transaction is not promotable: "+info, c)
+		return
+	}
+
+	tips := tangle.GetTXToApprove(hash, request.Depth)
+	if tips == nil {
+		ReplyError("Could not get transactions to approve", c)
+		return
+	}
+	var bundle giota.Bundle
+	bundle.Add(1, giota.Address(dummyHash), 0, time.Now(), promotionTag)
+	bundle.Finalize(nil)
+
+	tail, err := attachAndBroadcast(request.Transaction, convert.BytesToTrytes(tips[0])[:81],
+		request.MinWeightMagnitude, []string{string(bundle[0].Trytes())})
+	if err != nil {
+		ReplyError("Could not promote the transaction: "+err.Error(), c)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"hash":     tail,
+		"duration": getDuration(t),
+	})
+}
+
+/*
+Attaches the bundle of the given tail again, on top of newly selected tips.
+*/
+func replayBundle(request Request, c *gin.Context, t time.Time) {
+	hash, ok := checkReattachRequest(request, c)
+	if !ok {
+		return
+	}
+	txs, err := tangle.LoadBundle(hash)
+	if err != nil {
+		ReplyError("Could not load the bundle: "+err.Error(), c)
+		return
+	}
+
+	tips := tangle.GetTXToApprove(nil, request.Depth)
+	if tips == nil {
+		ReplyError("Could not get transactions to approve", c)
+		return
+	}
+	// The last transaction is attached first, so that each one approves the next
+	var trytes []string
+	for i := len(txs) - 1; i >= 0; i-- {
+		trytes = append(trytes, convert.BytesToTrytes(txs[i].Bytes)[:2673])
+	}
+
+	tail, err := attachAndBroadcast(convert.BytesToTrytes(tips[0])[:81], convert.BytesToTrytes(tips[1])[:81],
+		request.MinWeightMagnitude, trytes)
+	if err != nil {
+		ReplyError("Could not replay the bundle: "+err.Error(), c)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"hash":     tail,
+		"duration": getDuration(t),
+	})
+}
+
+func checkReattachRequest(request Request, c *gin.Context) ([]byte, bool) {
+	if !convert.IsTrytes(request.Transaction, 81) {
+		ReplyError("Wrong transaction trytes", c)
+		return nil, false
+	}
+	if (request.Depth < tangle.MinTipselDepth) || (request.Depth > tangle.MaxTipselDepth) {
+		ReplyError("Invalid depth input", c)
+		return nil, false
+	}
+	if request.MinWeightMagnitude < 1 {
+		ReplyError("Invalid minWeightMagnitude input", c)
+		return nil, false
+	}
+	return convert.TrytesToBytes(request.Transaction)[:49], true
+}
+
+/*
+Does the PoW for the transactions, ordered from the last index to the tail, stores and broadcasts
+them like broadcastTransactions and returns the hash of the new tail.
+*/
+func attachAndBroadcast(trunk string, branch string, minWeightMagnitude int, trytes []string) (string, error) {
+	attached, err := doPoW(trunk, branch, minWeightMagnitude, trytes)
+	if err != nil {
+		return "", err
+	}
+	if !transaction.IsValidBundleTrytes(attached) {
+		return "", errors.New("invalid bundle")
+	}
+	_, _, err = saveAndBroadcast(attached, true)
+	if err != nil {
+		return "", err
+	}
+	tailTrytes := attached[len(attached)-1]
+	trits := convert.TrytesToTrits(tailTrytes)
+	tail := transaction.TritsToTX(&trits, convert.TrytesToBytes(tailTrytes)[:1604])
+	return convert.BytesToTrytes(tail.Hash)[:81], nil
+}
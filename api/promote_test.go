@@ -0,0 +1,84 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"../convert"
+	"../db"
+	"../tangle"
+	"../transaction"
+	"github.com/gin-gonic/gin"
+	"github.com/iotaledger/giota"
+)
+
+const testMinWeightMagnitude = 1
+
+/*
+Attaches a zero-value transaction on top of the null hash, which is stored as confirmed, and returns its hash.
+*/
+func saveTestTail(t *testing.T) string {
+	db.DB = db.NewMemoryStore()
+	maxMinWeightMagnitude = 14
+	maxTransactions = 10
+	nullKey := db.GetByteKey(convert.TrytesToBytes(dummyHash)[:49], db.KEY_HASH)
+	if err := db.Put(db.AsKey(nullKey, db.KEY_CONFIRMED), 0, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(db.AsKey(nullKey, db.KEY_TIMESTAMP), int64(0), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var bundle giota.Bundle
+	bundle.Add(1, giota.Address(dummyHash), 0, time.Now(), "HERCULES9TEST")
+	bundle.Finalize(nil)
+	tail, err := attachAndBroadcast(dummyHash, dummyHash, testMinWeightMagnitude, []string{string(bundle[0].Trytes())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tail
+}
+
+func loadTestTX(t *testing.T, hash string) *transaction.FastTX {
+	key := db.GetByteKey(convert.TrytesToBytes(hash)[:49], db.KEY_BYTES)
+	txBytes, err := db.GetBytes(key, nil)
+	if err != nil {
+		t.Fatalf("Transaction %v was not stored: %v", hash, err)
+	}
+	trits := convert.BytesToTrits(txBytes)[:8019]
+	return transaction.TritsToFastTX(&trits, txBytes)
+}
+
+func TestPromoteTransaction(t *testing.T) {
+	tail := saveTestTail(t)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	promoteTransaction(Request{Transaction: tail, Depth: tangle.MinTipselDepth, MinWeightMagnitude: testMinWeightMagnitude}, c, time.Now())
+	if w.Code != http.StatusOK {
+		t.Fatalf("Promotion failed: %v", w.Body.String())
+	}
+	var response struct{ Hash string }
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	promotion := loadTestTX(t, response.Hash)
+	if !bytes.Equal(promotion.TrunkTransaction, convert.TrytesToBytes(tail)[:49]) {
+		t.Error("Promotion does not approve the promoted tail directly")
+	}
+	if promotion.Value != 0 || promotion.LastIndex != 0 {
+		t.Errorf("Promotion is not a single zero-value transaction: value %v, last index %v", promotion.Value, promotion.LastIndex)
+	}
+	if !strings.HasPrefix(convert.BytesToTrytes(promotion.Tag), promotionTag) {
+		t.Errorf("Promotion is tagged %v", convert.BytesToTrytes(promotion.Tag))
+	}
+	if err := transaction.ValidateBundle([]*transaction.FastTX{promotion}); err != nil {
+		t.Errorf("Promotion bundle is invalid: %v", err)
+	}
+}
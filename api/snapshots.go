@@ -15,7 +15,9 @@ import (
 	"time"
 
 	"../logs"
+	"../network"
 	"../snapshot"
+	"../tangle"
 	"../utils"
 	"github.com/gin-gonic/gin"
 )
@@ -33,8 +35,13 @@ func enableSnapshotApi(api *gin.Engine) {
 			}
 			if request.Command == "getSnapshotsInfo" {
 				getSnapshotsInfo(request, c, t)
+				observeDuration("getsnapshotsinfo", t)
 			} else if request.Command == "makeSnapshot" {
 				makeSnapshot(request, c, t)
+				observeDuration("makesnapshot", t)
+			} else if request.Command == "exportSnapshot" {
+				exportSnapshot(request, c, t)
+				observeDuration("exportsnapshot", t)
 			} else {
 				logs.Log.Error("Unknown command", request.Command)
 				ReplyError("No known command provided", c)
@@ -71,6 +78,7 @@ func getSnapshotsInfo(request Request, c *gin.Context, t time.Time) {
 							"TimeHumanReadable": utils.GetHumanReadableTime(int(timestamp)),
 							"path":              "/snapshots/" + name,
 							"checksum":          checksum,
+							"signed":            snapshot.IsSigned(path.Join(dir, name)),
 						})
 					}
 				}
@@ -82,10 +90,15 @@ func getSnapshotsInfo(request Request, c *gin.Context, t time.Time) {
 		timestamps = make([]map[string]interface{}, 0)
 	}
 	unfinishedSnapshotTimestamp := snapshot.GetSnapshotLock(nil)
+	currentSnapshotMilestoneIndex := 0
+	if milestone := snapshot.GetSnapshotMilestone(nil); milestone != nil {
+		currentSnapshotMilestoneIndex = milestone.Index
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"currentSnapshotTimestamp":            snapshot.CurrentTimestamp,
 		"currentSnapshotTimeHumanReadable":    utils.GetHumanReadableTime(snapshot.CurrentTimestamp),
+		"currentSnapshotMilestoneIndex":       currentSnapshotMilestoneIndex,
 		"isSynchronized":                      snapshot.IsSynchronized(),
 		"unfinishedSnapshotTimestamp":         unfinishedSnapshotTimestamp,
 		"unfinishedSnapshotTimeHumanReadable": utils.GetHumanReadableTime(unfinishedSnapshotTimestamp),
@@ -97,7 +110,11 @@ func getSnapshotsInfo(request Request, c *gin.Context, t time.Time) {
 }
 
 func makeSnapshot(request Request, c *gin.Context, t time.Time) {
-	if request.Timestamp < 1525017600 || request.Timestamp > int(time.Now().Unix()) {
+	if request.MilestoneIndex > 0 {
+		makeMilestoneSnapshot(request, c, t)
+		return
+	}
+	if int64(request.Timestamp) < network.Current.MinSnapshotTime || request.Timestamp > int(time.Now().Unix()) {
 		ReplyError("Wrong UNIX timestamp provided", c)
 		return
 	}
@@ -132,6 +149,53 @@ func makeSnapshot(request Request, c *gin.Context, t time.Time) {
 	})
 }
 
+func makeMilestoneSnapshot(request Request, c *gin.Context, t time.Time) {
+	if request.MilestoneIndex > tangle.LatestMilestone.Index {
+		ReplyError("Unknown milestone index", c)
+		return
+	}
+
+	if snapshot.InProgress || snapshot.GetSnapshotLock(nil) > 0 {
+		ReplyError("A snapshot is currently in progress or pending", c)
+		return
+	}
+
+	current := snapshot.GetSnapshotMilestone(nil)
+	if current != nil && current.Index >= request.MilestoneIndex {
+		ReplyError(fmt.Sprintf("The current snapshot is at a more recent milestone: %v", current.Index), c)
+		return
+	}
+
+	if !snapshot.IsSynchronized() {
+		ReplyError("The tangle not fully synchronized. Cannot snapshot in this state.", c)
+		return
+	}
+
+	if !snapshot.CanSnapshotMilestone(request.MilestoneIndex) {
+		ReplyError("Pending confirmations of the milestone. Cannot snapshot in this state.", c)
+		return
+	}
+
+	go snapshot.MakeMilestoneSnapshot(request.MilestoneIndex, request.Filename)
+	c.JSON(http.StatusOK, gin.H{
+		"time":     time.Now().Unix(),
+		"duration": getDuration(t),
+	})
+}
+
+func exportSnapshot(request Request, c *gin.Context, t time.Time) {
+	base, err := snapshot.ExportIRILocalSnapshot(request.Filename)
+	if err != nil {
+		ReplyError(fmt.Sprintf("Could not export the snapshot: %v", err), c)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"path":     base,
+		"time":     time.Now().Unix(),
+		"duration": getDuration(t),
+	})
+}
+
 func fileHash(filePath string) (string, error) {
 	//Initialize variable returnMD5String now in case an error has to be returned
 	var returnMD5String string
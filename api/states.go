@@ -6,7 +6,7 @@ import (
 
 	"../convert"
 	"../db"
-	"github.com/dgraph-io/badger"
+	"../tangle"
 	"github.com/gin-gonic/gin"
 )
 
@@ -15,27 +15,57 @@ func init() {
 	addAPICall("wereAddressesSpentFrom", wereAddressesSpentFrom)
 }
 
+/*
+With "verbose": true, the response also contains per transaction the index of the milestone
+that confirmed it and the local time of the confirmation, if known.
+*/
 func getInclusionStates(request Request, c *gin.Context, t time.Time) {
+	for _, hash := range request.Transactions {
+		if !convert.IsTrytes(hash, 81) {
+			ReplyError("Wrong hash trytes", c)
+			return
+		}
+	}
+
 	var states = []bool{}
-	_ = db.DB.View(func(txn *badger.Txn) error {
+	var confirmations = []interface{}{}
+	_ = db.DB.View(func(txn db.Txn) error {
 		for _, hash := range request.Transactions {
-			if !convert.IsTrytes(hash, 81) {
-				ReplyError("Wrong hash trytes", c)
-				return nil
+			key := db.GetByteKey(convert.TrytesToBytes(hash)[:49], db.KEY_HASH)
+			states = append(states, db.Has(db.AsKey(key, db.KEY_CONFIRMED), txn))
+			if request.Verbose {
+				confirmations = append(confirmations, confirmationObject(tangle.GetConfirmation(key, txn)))
 			}
-			states = append(states, db.Has(db.GetByteKey(convert.TrytesToBytes(hash)[:49], db.KEY_CONFIRMED), txn))
 		}
 		return nil
 	})
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"states":   states,
 		"duration": getDuration(t),
-	})
+	}
+	if request.Verbose {
+		response["confirmations"] = confirmations
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func confirmationObject(confirmation *tangle.Confirmation) interface{} {
+	if confirmation == nil {
+		return nil
+	}
+	object := gin.H{
+		"milestoneIndex":   nil,
+		"confirmationTime": confirmation.Time,
+	}
+	if confirmation.MilestoneIndex > 0 {
+		object["milestoneIndex"] = confirmation.MilestoneIndex
+	}
+	return object
 }
 
 func wereAddressesSpentFrom(request Request, c *gin.Context, t time.Time) {
 	var states = []bool{}
-	_ = db.DB.View(func(txn *badger.Txn) error {
+	_ = db.DB.View(func(txn db.Txn) error {
 		for _, hash := range request.Addresses {
 			if !convert.IsTrytes(hash, 81) {
 				ReplyError("Wrong hash trytes", c)
@@ -12,6 +12,7 @@ import (
 func init() {
 	addAPICall("getTips", getTips)
 	addAPICall("getTransactionsToApprove", getTransactionsToApprove)
+	addAPICall("checkConsistency", checkConsistency)
 }
 
 func getTips(request Request, c *gin.Context, t time.Time) {
@@ -55,4 +56,29 @@ func getTransactionsToApprove(request Request, c *gin.Context, t time.Time) {
 		"branchTransaction": convert.BytesToTrytes(tips[1])[:81],
 		"duration":          getDuration(t),
 	})
-}
\ No newline at end of file
+}
+func checkConsistency(request Request, c *gin.Context, t time.Time) {
+	if len(request.Tails) == 0 {
+		ReplyError("No tails provided", c)
+		return
+	}
+	var tails [][]byte
+	for _, tail := range request.Tails {
+		if !convert.IsTrytes(tail, 81) {
+			ReplyError("Wrong tail trytes", c)
+			return
+		}
+		tails = append(tails, convert.TrytesToBytes(tail)[:49])
+	}
+
+	state, info, err := tangle.CheckConsistency(tails)
+	if err != nil {
+		ReplyError(err.Error(), c)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"state":    state,
+		"info":     info,
+		"duration": getDuration(t),
+	})
+}
@@ -6,7 +6,6 @@ import (
 
 	"../convert"
 	"../db"
-	"github.com/dgraph-io/badger"
 	"github.com/gin-gonic/gin"
 )
 
@@ -16,7 +15,7 @@ func init() {
 
 func getTrytes(request Request, c *gin.Context, t time.Time) {
 	var trytes []interface{}
-	_ = db.DB.View(func(txn *badger.Txn) error {
+	_ = db.DB.View(func(txn db.Txn) error {
 		for _, hash := range request.Hashes {
 			if !convert.IsTrytes(hash, 81) {
 				ReplyError("Wrong hash trytes", c)
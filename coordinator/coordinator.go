@@ -0,0 +1,289 @@
+package coordinator
+
+import (
+	"errors"
+	"math/big"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"../convert"
+	"../crypt"
+	"../db"
+	"../logs"
+	"../network"
+	"../snapshot"
+	"../tangle"
+	"../transaction"
+	"github.com/iotaledger/giota"
+	"github.com/spf13/viper"
+)
+
+const (
+	maxTimestampValue = 3812798742493 // (3^27 - 1) / 2
+	reportKeysEvery   = 1000
+)
+
+var nullHashBytes = convert.TrytesToBytes(strings.Repeat("9", 81))[:49]
+var nullAddressTrits = convert.TrytesToTrits(strings.Repeat("9", 81))
+
+var config *viper.Viper
+var enabled = false
+var interval time.Duration
+var seed []int
+var merkleTree [][][]byte
+var powFunc giota.PowFunc
+
+// Last milestone issued by this coordinator. It might not yet be the latest milestone of the tangle.
+var lastIndex = 0
+var lastHash []byte
+
+/*
+Prepares the coordinator: generates the milestone keys and the Merkle tree.
+Has to be called after the network profile is loaded and before the tangle is started.
+*/
+func Load(cfg *viper.Viper) {
+	config = cfg
+	enabled = config.GetBool("coordinator.enabled")
+	if !enabled {
+		return
+	}
+
+	if network.Current.Name != network.CUSTOM {
+		logs.Log.Fatal("The coordinator can only be run with the custom network profile")
+	}
+	seedTrytes := config.GetString("coordinator.seed")
+	if !convert.IsTrytes(seedTrytes, 81) {
+		logs.Log.Fatal("The coordinator needs a valid seed (81 trytes)")
+	}
+	seed = convert.TrytesToTrits(seedTrytes)
+	interval = time.Duration(config.GetInt("coordinator.interval")) * time.Second
+	if interval <= 0 {
+		logs.Log.Fatal("The coordinator interval has to be at least one second")
+	}
+	if network.Current.MilestoneDepth > transaction.NUMBER_OF_FRAGMENT_CHUNKS {
+		logs.Log.Fatalf("The coordinator depth can be at most %v", transaction.NUMBER_OF_FRAGMENT_CHUNKS)
+	}
+
+	generateMerkleTree()
+	address := convert.BytesToTrytes(merkleTree[len(merkleTree)-1][0])[:81]
+	if len(network.Current.CoordinatorAddress) == 0 {
+		network.Current.CoordinatorAddress = address
+	} else if network.Current.CoordinatorAddress != address {
+		logs.Log.Fatalf("The coordinator seed does not match the configured coordinator address. "+
+			"The address for this seed and depth is: %v", address)
+	}
+	logs.Log.Infof("Coordinator address: %v", address)
+
+	_, powFunc = giota.GetBestPoW()
+}
+
+/*
+Starts issuing milestones, if the coordinator is enabled.
+*/
+func Start() {
+	if !enabled {
+		return
+	}
+	go run()
+}
+
+func run() {
+	logs.Log.Infof("Coordinator started. Issuing a milestone every %v", interval)
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		db.Locker.Lock()
+		db.Locker.Unlock()
+		if snapshot.InProgress {
+			continue
+		}
+		err := issueMilestone()
+		if err != nil {
+			logs.Log.Errorf("Could not issue milestone: %v", err)
+		}
+	}
+}
+
+func generateMerkleTree() {
+	count := 1 << uint(network.Current.MilestoneDepth)
+	logs.Log.Infof("Generating %v coordinator milestone keys. This might take a while...", count)
+
+	leaves := make([][]byte, count)
+	var done = 0
+	var doneLock = &sync.Mutex{}
+	var wg = &sync.WaitGroup{}
+	workers := runtime.NumCPU()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < count; i += workers {
+				leaves[i] = convert.TritsToBytes(transaction.Address(transaction.Digests(getKey(i), false)))[:49]
+				doneLock.Lock()
+				done++
+				if done%reportKeysEvery == 0 {
+					logs.Log.Infof("Generated %v/%v milestone keys", done, count)
+				}
+				doneLock.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	merkleTree = transaction.GetMerkleTree(leaves)
+}
+
+func getKey(index int) []int {
+	subseed := transaction.Subseed(seed, index, false)
+	return transaction.Key(subseed, network.Current.MilestoneSecurity, false)
+}
+
+/*
+Creates, signs, attaches and stores the next milestone bundle:
+one transaction per signature fragment, followed by the transaction holding the Merkle siblings.
+*/
+func issueMilestone() error {
+	tangle.MilestoneLocker.Lock()
+	index := tangle.LatestMilestone.Index
+	trunk := tangle.LatestMilestone.TX.Hash
+	tangle.MilestoneLocker.Unlock()
+	if lastIndex > index {
+		index = lastIndex
+		trunk = lastHash
+	}
+	if index == 0 {
+		trunk = nullHashBytes
+	}
+	index++
+	if index >= len(merkleTree[0]) {
+		return errors.New("all milestone keys have been used")
+	}
+
+	branch := trunk
+	if index > 1 {
+		tips := tangle.GetTXToApprove(nil, tangle.MinTipselDepth)
+		if tips != nil {
+			branch = tips[0]
+		}
+	}
+
+	security := network.Current.MilestoneSecurity
+	now := time.Now()
+	indexTrits := convert.IntToTrits(bigInt(index), 81)
+	cooAddressTrits := convert.TrytesToTrits(network.Current.CoordinatorAddress)
+
+	// Essence
+	bundle := make([][]int, security+1)
+	for i := range bundle {
+		trits := make([]int, 8019)
+		if i < security {
+			copy(trits[6561:6804], cooAddressTrits)
+		} else {
+			copy(trits[6561:6804], nullAddressTrits)
+		}
+		copy(trits[6885:6966], indexTrits)
+		copy(trits[6966:6993], convert.IntToTrits(bigInt(int(now.Unix())), 27))
+		copy(trits[6993:7020], convert.IntToTrits(bigInt(i), 27))
+		copy(trits[7020:7047], convert.IntToTrits(bigInt(security), 27))
+		copy(trits[7776:7857], indexTrits)
+		bundle[i] = trits
+	}
+
+	kerl := new(crypt.Kerl)
+	kerl.Initialize()
+	for _, trits := range bundle {
+		essence := transaction.GetEssenceTrits(&trits)
+		kerl.Absorb(essence, 0, len(essence))
+	}
+	bundleHash := make([]int, crypt.HASH_LENGTH)
+	kerl.Squeeze(bundleHash, 0, crypt.HASH_LENGTH)
+	for _, trits := range bundle {
+		copy(trits[7047:7290], bundleHash)
+	}
+
+	// Siblings transaction
+	copy(bundle[security][:6561], transaction.GetMerkleSiblings(merkleTree, index))
+	txs := make([]*transaction.FastTX, security+1)
+	siblingsTX, err := attach(bundle[security], trunk, branch, now)
+	if err != nil {
+		return err
+	}
+	txs[security] = siblingsTX
+
+	// Signature transactions, signing the siblings transaction hash
+	key := getKey(index)
+	normalized := transaction.NormalizedBundle(convert.BytesToTrits(siblingsTX.Hash)[:243])
+	for i := security - 1; i >= 0; i-- {
+		offset := i * transaction.NUMBER_OF_FRAGMENT_CHUNKS
+		signature := transaction.SignatureFragment(
+			normalized[offset:offset+transaction.NUMBER_OF_FRAGMENT_CHUNKS],
+			key[i*transaction.FRAGMENT_LENGTH:(i+1)*transaction.FRAGMENT_LENGTH],
+			false)
+		copy(bundle[i][:6561], signature)
+		tx, err := attach(bundle[i], txs[i+1].Hash, trunk, now)
+		if err != nil {
+			return err
+		}
+		txs[i] = tx
+	}
+	if tangle.GetMilestoneIndex(txs[:security], bundle[security]) != index {
+		return errors.New("the signed milestone does not verify against the coordinator address")
+	}
+
+	err = db.DB.Update(func(txn db.Txn) error {
+		for i := len(txs) - 1; i >= 0; i-- {
+			if db.Has(db.GetByteKey(txs[i].Hash, db.KEY_HASH), txn) {
+				continue
+			}
+			err := tangle.SaveTX(txs[i], &txs[i].Bytes, txn)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = tangle.AddPendingMilestone(txs[0].Hash)
+	if err != nil {
+		return err
+	}
+	for i := len(txs) - 1; i >= 0; i-- {
+		tangle.Broadcast(txs[i].Bytes, "")
+	}
+
+	lastIndex = index
+	lastHash = txs[0].Hash
+	logs.Log.Infof("Issued milestone %v: %v", index, convert.BytesToTrytes(txs[0].Hash)[:81])
+	return nil
+}
+
+/*
+Sets trunk, branch and the attachment timestamps and does the PoW.
+*/
+func attach(trits []int, trunk []byte, branch []byte, now time.Time) (*transaction.FastTX, error) {
+	copy(trits[7290:7533], convert.BytesToTrits(trunk)[:243])
+	copy(trits[7533:7776], convert.BytesToTrits(branch)[:243])
+	copy(trits[7857:7884], convert.IntToTrits(bigInt(int(now.UnixNano()/int64(time.Millisecond))), 27))
+	copy(trits[7884:7911], convert.IntToTrits(bigInt(0), 27))
+	copy(trits[7911:7938], convert.IntToTrits(bigInt(maxTimestampValue), 27))
+
+	nonce, err := powFunc(giota.Trytes(convert.TritsToTrytes(trits)), network.Current.MWM)
+	if err != nil {
+		return nil, err
+	}
+	copy(trits[7938:8019], convert.TrytesToTrits(string(nonce)))
+
+	raw := convert.TritsToBytes(trits)[:1604]
+	tx := transaction.TritsToTX(&trits, raw)
+	if !crypt.IsValidPoW(tx.Hash, network.Current.MWM) {
+		return nil, errors.New("PoW verification failed")
+	}
+	return tx, nil
+}
+
+func bigInt(value int) *big.Int {
+	return big.NewInt(int64(value))
+}
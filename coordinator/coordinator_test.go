@@ -0,0 +1,70 @@
+package coordinator
+
+import (
+	"strings"
+	"testing"
+
+	"../convert"
+	"../db"
+	"../network"
+	"../tangle"
+	"../transaction"
+	"github.com/spf13/viper"
+)
+
+const testDepth = 3
+
+/*
+Loads a coordinator with a small Merkle tree on a custom profile with the lowest MWM.
+*/
+func loadTestCoordinator(t *testing.T) {
+	current := network.Current
+	latest := tangle.LatestMilestone
+	t.Cleanup(func() {
+		network.Current = current
+		tangle.LatestMilestone = latest
+		lastIndex = 0
+		lastHash = nil
+	})
+	db.DB = db.NewMemoryStore()
+	network.Current = network.Mainnet
+	network.Current.Name = network.CUSTOM
+	network.Current.CoordinatorAddress = ""
+	network.Current.MilestoneDepth = testDepth
+	network.Current.MWM = 1
+	tangle.LatestMilestone = tangle.Milestone{TX: &transaction.FastTX{Hash: nullHashBytes}}
+
+	cfg := viper.New()
+	cfg.Set("coordinator.enabled", true)
+	cfg.Set("coordinator.seed", strings.Repeat("COORDINATOR9TEST", 6)[:81])
+	cfg.Set("coordinator.interval", 1)
+	Load(cfg)
+}
+
+func loadTestTX(t *testing.T, hash []byte) (*transaction.FastTX, []int) {
+	txBytes, err := db.GetBytes(db.GetByteKey(hash, db.KEY_BYTES), nil)
+	if err != nil {
+		t.Fatalf("Transaction %v was not stored: %v", convert.BytesToTrytes(hash)[:81], err)
+	}
+	trits := convert.BytesToTrits(txBytes)[:8019]
+	return transaction.TritsToFastTX(&trits, txBytes), trits
+}
+
+func TestIssueMilestone(t *testing.T) {
+	loadTestCoordinator(t)
+	if len(merkleTree) != testDepth+1 || len(merkleTree[0]) != 1<<testDepth || len(merkleTree[0][0]) != 49 {
+		t.Fatalf("Merkle tree has %v layers and %v leaves", len(merkleTree), len(merkleTree[0]))
+	}
+	if err := issueMilestone(); err != nil {
+		t.Fatal(err)
+	}
+
+	milestone, _ := loadTestTX(t, lastHash)
+	siblingsTX, siblingsTrits := loadTestTX(t, milestone.TrunkTransaction)
+	if convert.BytesToTrytes(milestone.Address)[:81] != network.Current.CoordinatorAddress || siblingsTX.CurrentIndex != 1 {
+		t.Fatal("Stored milestone bundle has a wrong structure")
+	}
+	if index := tangle.GetMilestoneIndex([]*transaction.FastTX{milestone}, siblingsTrits); index != 1 {
+		t.Errorf("Milestone index is %v, expected 1", index)
+	}
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+type badgerStore struct {
+	db *badger.DB
+}
+
+type badgerTxn struct {
+	txn *badger.Txn
+}
+
+func NewBadgerStore(opts badger.Options) (Store, error) {
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &badgerStore{db}, nil
+}
+
+func (store *badgerStore) View(fn func(txn Txn) error) error {
+	return translateError(store.db.View(func(txn *badger.Txn) error {
+		return fn(&badgerTxn{txn})
+	}))
+}
+
+func (store *badgerStore) Update(fn func(txn Txn) error) error {
+	return translateError(store.db.Update(func(txn *badger.Txn) error {
+		return fn(&badgerTxn{txn})
+	}))
+}
+
+func (store *badgerStore) NewTransaction(update bool) Txn {
+	return &badgerTxn{store.db.NewTransaction(update)}
+}
+
+func (store *badgerStore) Size() (int64, int64) {
+	return store.db.Size()
+}
+
+func (store *badgerStore) Cleanup() {
+	store.db.RunValueLogGC(0.5)
+}
+
+func (store *badgerStore) Close() error {
+	return store.db.Close()
+}
+
+func (txn *badgerTxn) Has(key []byte) bool {
+	_, err := txn.txn.Get(key)
+	return err == nil
+}
+
+func (txn *badgerTxn) Get(key []byte) ([]byte, error) {
+	item, err := txn.txn.Get(key)
+	if err != nil {
+		if err == badger.ErrRetry {
+			return txn.Get(key)
+		}
+		return nil, translateError(err)
+	}
+	return item.ValueCopy(nil)
+}
+
+func (txn *badgerTxn) Put(key []byte, value []byte, ttl *time.Duration) error {
+	var err error
+	if ttl != nil {
+		err = txn.txn.SetWithTTL(key, value, *ttl)
+	} else {
+		err = txn.txn.Set(key, value)
+	}
+	return translateError(err)
+}
+
+func (txn *badgerTxn) Remove(key []byte) error {
+	return translateError(txn.txn.Delete(key))
+}
+
+func (txn *badgerTxn) ForPrefix(prefix []byte, fetchValues bool, fn func(key []byte, value []byte) (bool, error)) error {
+	return txn.ForRange(prefix, prefix, fetchValues, fn)
+}
+
+func (txn *badgerTxn) ForRange(prefix []byte, start []byte, fetchValues bool, fn func(key []byte, value []byte) (bool, error)) error {
+	if bytes.Compare(start, prefix) < 0 {
+		start = prefix
+	}
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = fetchValues
+	it := txn.txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Seek(start); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		var value []byte
+		if fetchValues {
+			v, err := item.Value()
+			if err != nil {
+				return err
+			}
+			value = v
+		}
+		next, err := fn(item.KeyCopy(nil), value)
+		if err != nil || !next {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Commits in the background once the conflict check passed.
+*/
+func (txn *badgerTxn) Commit() error {
+	return translateError(txn.txn.Commit(func(e error) {}))
+}
+
+func (txn *badgerTxn) Discard() {
+	txn.txn.Discard()
+}
+
+func translateError(err error) error {
+	switch err {
+	case badger.ErrKeyNotFound:
+		return ErrKeyNotFound
+	case badger.ErrTxnTooBig:
+		return ErrTxnTooBig
+	}
+	return err
+}
@@ -3,12 +3,12 @@ package db
 import (
 	"bytes"
 	"crypto/md5"
+	"encoding/binary"
 	"encoding/gob"
 	"math"
 	"time"
 
 	"../utils"
-	"github.com/dgraph-io/badger"
 )
 
 // TODO: the descriptions are mostly wrong...
@@ -24,29 +24,48 @@ const (
 	KEY_TAG          = byte(6) // tag hash + hash -> empty
 	KEY_VALUE        = byte(7) // hash -> int64
 	KEY_ADDRESS_HASH = byte(8) // hash -> address
+	KEY_ARRIVAL_TIME = byte(9) // hash -> time (local unix time of arrival)
+
+	// TIME-ORDERED INDEXES (permanode)
+	KEY_TIME         = byte(10) // timestamp + hash -> empty
+	KEY_ADDRESS_TIME = byte(11) // address hash + timestamp + hash -> empty
+	KEY_BUNDLE_TIME  = byte(12) // bundle hash + timestamp + hash -> empty
+	KEY_TAG_TIME     = byte(13) // tag hash + timestamp + hash -> empty
 
 	// RELATIONS
 	KEY_RELATION = byte(15) // hash -> hash+hash
 	KEY_APPROVEE = byte(16) // hash + parent hash -> empty
 
+	// BUNDLE VALIDATION
+	KEY_VALID_BUNDLE = byte(18) // hash -> bool (whether a complete bundle of the tx is valid)
+
 	// MILESTONE/CONFIRMATION RELATED
-	KEY_MILESTONE       = byte(20) // hash -> index
-	KEY_SOLID_MILESTONE = byte(21) // hash -> index
-	KEY_CONFIRMED       = byte(25) // hash -> time
-	KEY_TIP             = byte(27) // hash -> time
+	KEY_MILESTONE        = byte(20) // hash -> index
+	KEY_SOLID_MILESTONE  = byte(21) // hash -> index
+	KEY_LEDGER_DIFF      = byte(22) // milestone index + address -> int64 (balance change)
+	KEY_LEDGER_CONFIRMED = byte(23) // milestone index + hash -> empty
+	KEY_LEDGER_SPENT     = byte(24) // milestone index + address -> empty (newly spent)
+	KEY_CONFIRMED        = byte(25) // hash -> time
+	KEY_CONFIRMATION     = byte(26) // hash -> [milestone index, local unix time]
+	KEY_TIP              = byte(27) // hash -> time
 
 	// PENDING + UNKNOWN CONFIRMED TRANSACTIONS
 	KEY_PENDING_TIMESTAMP = byte(30) // hash -> parent time
 	KEY_PENDING_HASH      = byte(31) // hash -> hash
-	KEY_PENDING_CONFIRMED = byte(35) // hash -> parent time
+	KEY_PENDING_CONFIRMED = byte(35) // hash -> milestone index
 	KEY_PENDING_BUNDLE    = byte(39) // hash -> timestamp
 
+	// DOUBLE SPENDS
+	KEY_PENDING_SPEND = byte(40) // address + bundle hash -> [bundle, value] (unconfirmed spend of a valid bundle)
+	KEY_CONFLICT      = byte(41) // address -> [address, bundles, time] (double spend)
+
 	// PERSISTENT EVENTS
 	KEY_EVENT_MILESTONE_PENDING           = byte(50) // trunk hash (999 address) -> tx hash
 	KEY_EVENT_MILESTONE_PAIR_PENDING      = byte(51) // trunk hash (999 address) -> tx hash
-	KEY_EVENT_CONFIRMATION_PENDING        = byte(56) // hash (coo address) -> index
+	KEY_EVENT_CONFIRMATION_PENDING        = byte(56) // hash -> [parent time, milestone index]
 	KEY_EVENT_BUNDLE_CONFIRMATION_PENDING = byte(57)
 	KEY_EVENT_TRIM_PENDING                = byte(58) // hash -> bool
+	KEY_EVENT_BUNDLE_PENDING              = byte(59) // hash -> empty (bundle to assemble and validate)
 
 	// OTHER
 	KEY_BALANCE          = byte(100) // address hash -> int64
@@ -58,6 +77,8 @@ const (
 	KEY_SNAPSHOT_FILE    = byte(128) // byte -> string
 	KEY_SNAPSHOT_DATE    = byte(129) // byte -> int (timestamp)
 	KEY_SNAPSHOTTED      = byte(130) // byte -> int (timestamp)
+	KEY_NEIGHBOR         = byte(140) // neighbor address hash -> neighbor uri (string)
+	KEY_PIN              = byte(145) // pin type + hash -> pin (type, hash trytes)
 	KEY_EDGE             = byte(150) // byte -> int (timestamp)
 	KEY_TEST             = byte(187) // hash -> bool
 	KEY_OTHER            = byte(255) // XXXX -> any bytes
@@ -91,173 +112,153 @@ func GetAddressKey(address []byte, key byte) []byte {
 	return b
 }
 
-func Has(key []byte, txn *badger.Txn) bool {
-	tx := txn
-	var err error = nil
-	if txn == nil {
-		tx = DB.NewTransaction(false)
-		defer func() error {
-			tx.Commit(func(e error) {})
-			return err
-		}()
+/*
+Returns a key of a time-ordered index: the prefix, the timestamp in big-endian order
+and the hash key. Negative timestamps are stored as zero.
+*/
+func GetTimeKey(prefix []byte, timestamp int64, hashKey []byte) []byte {
+	if timestamp < 0 {
+		timestamp = 0
 	}
-	_, err = tx.Get(key)
-	return err == nil
+	b := make([]byte, len(prefix)+8+len(hashKey))
+	copy(b, prefix)
+	binary.BigEndian.PutUint64(b[len(prefix):], uint64(timestamp))
+	copy(b[len(prefix)+8:], hashKey)
+	return b
 }
 
-func Put(key []byte, value interface{}, ttl *time.Duration, txn *badger.Txn) error {
-	tx := txn
-	var err error = nil
-	if txn == nil {
-		tx = DB.NewTransaction(true)
-		defer func() error {
-			if err != nil {
-				tx.Discard()
-				return err
-			}
-			return tx.Commit(func(e error) {})
-		}()
+/*
+Returns a key of a per-milestone index: the key type, the milestone index in big-endian order
+and the suffix (an address or hash key).
+*/
+func GetMilestoneKey(key byte, index int, suffix []byte) []byte {
+	b := make([]byte, 5+len(suffix))
+	b[0] = key
+	binary.BigEndian.PutUint32(b[1:], uint32(index))
+	copy(b[5:], suffix)
+	return b
+}
+
+/*
+Runs fn in the given transaction or, if it is nil, in a new one that is committed afterwards.
+*/
+func withTxn(txn Txn, update bool, fn func(txn Txn) error) error {
+	if txn != nil {
+		return fn(txn)
 	}
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err = enc.Encode(value)
-	if err != nil {
+	tx := DB.NewTransaction(update)
+	err := fn(tx)
+	if err != nil || !update {
+		tx.Discard()
 		return err
 	}
-	if ttl != nil {
-		return tx.SetWithTTL(key, buf.Bytes(), *ttl)
-	}
-	return tx.Set(key, buf.Bytes())
+	return tx.Commit()
 }
 
-func PutBytes(key []byte, value []byte, ttl *time.Duration, txn *badger.Txn) error {
-	tx := txn
-	var err error = nil
-	if txn == nil {
-		tx = DB.NewTransaction(true)
-		defer func() error {
-			if err != nil {
-				tx.Discard()
-				return err
-			}
-			return tx.Commit(func(e error) {})
-		}()
-	}
-	if ttl != nil {
-		err = tx.SetWithTTL(key, value, *ttl)
-	} else {
-		err = tx.Set(key, value)
-	}
-	return err
+func Has(key []byte, txn Txn) bool {
+	has := false
+	withTxn(txn, false, func(txn Txn) error {
+		has = txn.Has(key)
+		return nil
+	})
+	return has
 }
 
-func Get(key []byte, data interface{}, txn *badger.Txn) error {
-	tx := txn
-	var err error = nil
-	if txn == nil {
-		tx = DB.NewTransaction(false)
-		defer func() error {
-			if err != nil {
-				tx.Discard()
-				return err
-			}
-			return tx.Commit(func(e error) {})
-		}()
-	}
-	item, err := tx.Get(key)
+func Put(key []byte, value interface{}, ttl *time.Duration, txn Txn) error {
+	data, err := Encode(value)
 	if err != nil {
-		if err == badger.ErrRetry {
-			return Get(key, data, txn)
-		}
 		return err
 	}
-	val, err := item.Value()
+	return PutBytes(key, data, ttl, txn)
+}
+
+/*
+Encodes a value the same way as Put.
+*/
+func Encode(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	err := enc.Encode(value)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	buf := bytes.NewBuffer(val)
-	dec := gob.NewDecoder(buf)
-	err = dec.Decode(data)
+	return buf.Bytes(), nil
+}
+
+func PutBytes(key []byte, value []byte, ttl *time.Duration, txn Txn) error {
+	return withTxn(txn, true, func(txn Txn) error {
+		return txn.Put(key, value, ttl)
+	})
+}
+
+func Get(key []byte, data interface{}, txn Txn) error {
+	var val []byte
+	err := withTxn(txn, false, func(txn Txn) error {
+		v, err := txn.Get(key)
+		val = v
+		return err
+	})
 	if err != nil {
 		return err
 	}
-	return nil
+	buf := bytes.NewBuffer(val)
+	dec := gob.NewDecoder(buf)
+	return dec.Decode(data)
 }
 
-func GetBytes(key []byte, txn *badger.Txn) ([]byte, error) {
+func GetBytes(key []byte, txn Txn) ([]byte, error) {
 	var resp []byte = nil
 	err := Get(key, &resp, txn)
 	return resp, err
 }
 
-func GetBytesRaw(key []byte, txn *badger.Txn) ([]byte, error) {
-	item, err := txn.Get(key)
-	if err != nil {
-		if err == badger.ErrRetry {
-			return GetBytesRaw(key, txn)
-		}
-		return nil, err
-	}
-
-	value, err := item.Value()
-	if err != nil {
-		return nil, err
-	}
-
-	response := make([]byte, len(value))
-	copy(response, value)
-
-	return response, nil
+func GetBytesRaw(key []byte, txn Txn) ([]byte, error) {
+	var response []byte
+	err := withTxn(txn, false, func(txn Txn) error {
+		value, err := txn.Get(key)
+		response = value
+		return err
+	})
+	return response, err
 }
 
-func GetString(key []byte, txn *badger.Txn) (string, error) {
+func GetString(key []byte, txn Txn) (string, error) {
 	var resp = ""
 	err := Get(key, &resp, txn)
 	return resp, err
 }
 
-func GetInt(key []byte, txn *badger.Txn) (int, error) {
+func GetInt(key []byte, txn Txn) (int, error) {
 	var resp = 0
 	err := Get(key, &resp, txn)
 	return resp, err
 }
 
-func GetBool(key []byte, txn *badger.Txn) (bool, error) {
+func GetBool(key []byte, txn Txn) (bool, error) {
 	var resp = false
 	err := Get(key, &resp, txn)
 	return resp, err
 }
 
-func GetInt64(key []byte, txn *badger.Txn) (int64, error) {
+func GetInt64(key []byte, txn Txn) (int64, error) {
 	var resp int64 = 0
 	err := Get(key, &resp, txn)
 	return resp, err
 }
 
-func Remove(key []byte, txn *badger.Txn) error {
-	tx := txn
-	if txn == nil {
-		tx = DB.NewTransaction(true)
-		defer tx.Commit(func(e error) {})
-	}
-	return tx.Delete(key)
+func Remove(key []byte, txn Txn) error {
+	return withTxn(txn, true, func(txn Txn) error {
+		return txn.Remove(key)
+	})
 }
 
 func RemoveAll(key byte) error {
 	var keys [][]byte
-	err := DB.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = false
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := []byte{key}
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			k := it.Item().Key()
-			key := make([]byte, len(k))
-			copy(key, k)
-			keys = append(keys, key)
-		}
-		return nil
+	err := DB.View(func(txn Txn) error {
+		return txn.ForPrefix([]byte{key}, false, func(k []byte, _ []byte) (bool, error) {
+			keys = append(keys, k)
+			return true, nil
+		})
 	})
 	if err != nil {
 		return err
@@ -272,32 +273,16 @@ func RemoveAll(key byte) error {
 }
 
 func Count(key byte) int {
-	count := 0
-	_ = DB.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = false
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := []byte{key}
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			count++
-		}
-		return nil
-	})
-	return count
+	return CountByPrefix([]byte{key})
 }
 
 func CountByPrefix(prefix []byte) int {
 	count := 0
-	_ = DB.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = false
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+	_ = DB.View(func(txn Txn) error {
+		return txn.ForPrefix(prefix, false, func(_ []byte, _ []byte) (bool, error) {
 			count++
-		}
-		return nil
+			return true, nil
+		})
 	})
 	return count
 }
@@ -306,35 +291,27 @@ func CountByPrefix(prefix []byte) int {
 Returns latest key iterating over all items of certain type.
 The value is expected to be a unix timestamp
 */
-func GetLatestKey(key byte, oldest bool, txn *badger.Txn) ([]byte, int, error) {
-	tx := txn
-	if txn == nil {
-		tx = DB.NewTransaction(false)
-		defer tx.Commit(func(e error) {})
-	}
+func GetLatestKey(key byte, oldest bool, txn Txn) ([]byte, int, error) {
 	var latest []byte
 	var current = 0
-	it := tx.NewIterator(badger.DefaultIteratorOptions)
-	defer it.Close()
-
-	prefix := []byte{key}
-	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-		item := it.Item()
-		v, err := item.Value()
-		if err != nil {
-			return nil, 0, err
-		}
-		var data int
-		buf := bytes.NewBuffer(v)
-		dec := gob.NewDecoder(buf)
-		err = dec.Decode(data)
-		if err != nil {
-			return nil, 0, err
-		}
-		if (!oldest && data > current) || (oldest && data < current) {
-			current = data
-			latest = item.Key()
-		}
+	err := withTxn(txn, false, func(txn Txn) error {
+		return txn.ForPrefix([]byte{key}, true, func(k []byte, v []byte) (bool, error) {
+			var data int
+			buf := bytes.NewBuffer(v)
+			dec := gob.NewDecoder(buf)
+			err := dec.Decode(data)
+			if err != nil {
+				return false, err
+			}
+			if (!oldest && data > current) || (oldest && data < current) {
+				current = data
+				latest = k
+			}
+			return true, nil
+		})
+	})
+	if err != nil {
+		return nil, 0, err
 	}
 	return latest, current, nil
 }
@@ -343,36 +320,32 @@ func GetLatestKey(key byte, oldest bool, txn *badger.Txn) ([]byte, int, error) {
 Returns latest random key iterating over all items of certain type.
 The value is expected to be a unix timestamp. //When picked, the value is updated
 */
-func PickRandomKey(key byte, maxRandom int, txn *badger.Txn) []byte {
-	tx := txn
-	if txn == nil {
-		tx = DB.NewTransaction(false)
-		defer tx.Commit(func(e error) {})
-	}
-	opts := badger.DefaultIteratorOptions
-	opts.PrefetchValues = false
-	it := tx.NewIterator(opts)
+func PickRandomKey(key byte, maxRandom int, txn Txn) []byte {
 	max := int(math.Min(float64(maxRandom), float64(Count(key))))
 	if max == 0 {
 		return nil
 	}
 	target := utils.Random(0, max)
 	step := 0
-	defer it.Close()
 
 	var result []byte = nil
-
-	prefix := []byte{key}
-	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-		if step == target {
-			return it.Item().Key()
-		}
-		step++
-	}
+	withTxn(txn, false, func(txn Txn) error {
+		return txn.ForPrefix([]byte{key}, false, func(k []byte, _ []byte) (bool, error) {
+			if step == target {
+				result = k
+				return false, nil
+			}
+			step++
+			return true, nil
+		})
+	})
 	return result
 }
 
-func IncrBy(key []byte, value int64, deleteOnZero bool, txn *badger.Txn) (int64, error) {
+/*
+Adds value to the int64 stored at key.
+*/
+func IncrBy(key []byte, value int64, deleteOnZero bool, txn Txn) (int64, error) {
 	balance, err := GetInt64(key, txn)
 	balance += value
 	if balance == 0 && deleteOnZero && err != nil {
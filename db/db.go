@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"../logs"
+	"../metrics"
 	"github.com/dgraph-io/badger"
 	"github.com/dgraph-io/badger/options"
 	"github.com/spf13/viper"
@@ -17,7 +18,7 @@ const (
 
 // TODO: (OPT) write tests for the database?
 
-var DB *badger.DB
+var DB Store
 var config *viper.Viper
 var Locker = &sync.Mutex{}
 
@@ -29,6 +30,22 @@ func Load(cfg *viper.Viper) {
 
 	config = cfg
 
+	switch config.GetString("database.engine") {
+	case "", "badger":
+		DB = loadBadger()
+	case "memory":
+		logs.Log.Warning("Using the in-memory database. Nothing will be persisted!")
+		DB = NewMemoryStore()
+	default:
+		logs.Log.Fatalf("Unknown database engine: %v", config.GetString("database.engine"))
+	}
+	checkSchema()
+	cleanupDB()
+	registerMetrics()
+	logs.Log.Info("Database loaded")
+}
+
+func loadBadger() Store {
 	opts := badger.DefaultOptions
 	opts.Dir = config.GetString("database.path")
 	opts.ValueDir = opts.Dir
@@ -48,13 +65,11 @@ func Load(cfg *viper.Viper) {
 		opts.ValueLogMaxEntries = 250000
 	}
 
-	db, err := badger.Open(opts)
+	store, err := NewBadgerStore(opts)
 	if err != nil {
 		logs.Log.Fatal(err)
 	}
-	DB = db
-	cleanupDB()
-	logs.Log.Info("Database loaded")
+	return store
 }
 
 /*
@@ -82,13 +97,24 @@ func StartPeriodicDatabaseCleanup() {
 	}
 }
 
+func registerMetrics() {
+	metrics.AddGaugeVec("hercules_database_size_bytes", "Size of the database LSM tree and value log",
+		func() []metrics.Sample {
+			lsm, vlog := DB.Size()
+			return []metrics.Sample{
+				{Labels: map[string]string{"type": "lsm"}, Value: float64(lsm)},
+				{Labels: map[string]string{"type": "vlog"}, Value: float64(vlog)},
+			}
+		})
+}
+
 /*
 Garbage-collects debris from the memory.
 */
 func cleanupDB() {
 	logs.Log.Debug("Cleanup database started")
 	Locker.Lock()
-	DB.RunValueLogGC(0.5)
+	DB.Cleanup()
 	Locker.Unlock()
 	logs.Log.Debug("Cleanup database finished")
 }
@@ -0,0 +1,317 @@
+package db
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const memoryMaxLevel = 24
+
+/*
+Pure in-memory store for tests and ephemeral nodes. Nothing is persisted.
+Writes of a transaction become visible at once on commit. Unlike badger,
+concurrent transactions are not checked for conflicts: the last commit wins.
+*/
+type memoryStore struct {
+	lock *sync.RWMutex
+	data *skiplist
+	size int64
+}
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time // Zero if the entry does not expire
+}
+
+type memoryTxn struct {
+	store  *memoryStore
+	update bool
+	writes map[string]*memoryEntry // nil entry: removed
+}
+
+type memoryKeyValue struct {
+	key   string
+	value []byte
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{lock: &sync.RWMutex{}, data: newSkiplist()}
+}
+
+func (store *memoryStore) View(fn func(txn Txn) error) error {
+	txn := store.NewTransaction(false)
+	defer txn.Discard()
+	return fn(txn)
+}
+
+func (store *memoryStore) Update(fn func(txn Txn) error) error {
+	txn := store.NewTransaction(true)
+	defer txn.Discard()
+	if err := fn(txn); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+func (store *memoryStore) NewTransaction(update bool) Txn {
+	return &memoryTxn{store: store, update: update, writes: make(map[string]*memoryEntry)}
+}
+
+func (store *memoryStore) Size() (int64, int64) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return store.size, 0
+}
+
+/*
+Removes the expired entries.
+*/
+func (store *memoryStore) Cleanup() {
+	now := time.Now()
+	var expired []string
+	store.lock.RLock()
+	for n := store.data.seek(""); n != nil; n = n.next[0] {
+		if n.entry.isExpired(now) {
+			expired = append(expired, n.key)
+		}
+	}
+	store.lock.RUnlock()
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for _, key := range expired {
+		if entry := store.data.get(key); entry != nil && entry.isExpired(now) {
+			store.remove(key)
+		}
+	}
+}
+
+func (store *memoryStore) Close() error {
+	return nil
+}
+
+func (store *memoryStore) get(key string) *memoryEntry {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	entry := store.data.get(key)
+	if entry == nil || entry.isExpired(time.Now()) {
+		return nil
+	}
+	return entry
+}
+
+func (store *memoryStore) set(key string, entry *memoryEntry) {
+	if old := store.data.get(key); old != nil {
+		store.size -= int64(len(old.value))
+	} else {
+		store.size += int64(len(key))
+	}
+	store.size += int64(len(entry.value))
+	store.data.set(key, entry)
+}
+
+func (store *memoryStore) remove(key string) {
+	if old := store.data.remove(key); old != nil {
+		store.size -= int64(len(key) + len(old.value))
+	}
+}
+
+func (entry *memoryEntry) isExpired(now time.Time) bool {
+	return !entry.expires.IsZero() && now.After(entry.expires)
+}
+
+func (txn *memoryTxn) Has(key []byte) bool {
+	_, err := txn.Get(key)
+	return err == nil
+}
+
+func (txn *memoryTxn) Get(key []byte) ([]byte, error) {
+	entry, written := txn.writes[string(key)]
+	if !written {
+		entry = txn.store.get(string(key))
+	}
+	if entry == nil || entry.isExpired(time.Now()) {
+		return nil, ErrKeyNotFound
+	}
+	value := make([]byte, len(entry.value))
+	copy(value, entry.value)
+	return value, nil
+}
+
+func (txn *memoryTxn) Put(key []byte, value []byte, ttl *time.Duration) error {
+	if !txn.update {
+		return errors.New("Cannot write in a read-only transaction")
+	}
+	entry := &memoryEntry{value: make([]byte, len(value))}
+	copy(entry.value, value)
+	if ttl != nil {
+		entry.expires = time.Now().Add(*ttl)
+	}
+	txn.writes[string(key)] = entry
+	return nil
+}
+
+func (txn *memoryTxn) Remove(key []byte) error {
+	if !txn.update {
+		return errors.New("Cannot write in a read-only transaction")
+	}
+	txn.writes[string(key)] = nil
+	return nil
+}
+
+func (txn *memoryTxn) ForPrefix(prefix []byte, fetchValues bool, fn func(key []byte, value []byte) (bool, error)) error {
+	return txn.ForRange(prefix, prefix, fetchValues, fn)
+}
+
+func (txn *memoryTxn) ForRange(prefix []byte, start []byte, fetchValues bool, fn func(key []byte, value []byte) (bool, error)) error {
+	p := string(prefix)
+	from := string(start)
+	if from < p {
+		from = p
+	}
+	now := time.Now()
+
+	var items []memoryKeyValue
+	txn.store.lock.RLock()
+	for n := txn.store.data.seek(from); n != nil && strings.HasPrefix(n.key, p); n = n.next[0] {
+		if _, written := txn.writes[n.key]; !written && !n.entry.isExpired(now) {
+			items = append(items, memoryKeyValue{n.key, n.entry.value})
+		}
+	}
+	txn.store.lock.RUnlock()
+
+	// Own uncommitted writes
+	written := false
+	for key, entry := range txn.writes {
+		if entry != nil && strings.HasPrefix(key, p) && key >= from && !entry.isExpired(now) {
+			items = append(items, memoryKeyValue{key, entry.value})
+			written = true
+		}
+	}
+	if written {
+		sort.Slice(items, func(i, j int) bool { return items[i].key < items[j].key })
+	}
+
+	for _, item := range items {
+		var value []byte
+		if fetchValues {
+			value = item.value
+		}
+		next, err := fn([]byte(item.key), value)
+		if err != nil || !next {
+			return err
+		}
+	}
+	return nil
+}
+
+func (txn *memoryTxn) Commit() error {
+	if len(txn.writes) == 0 {
+		return nil
+	}
+	txn.store.lock.Lock()
+	defer txn.store.lock.Unlock()
+	for key, entry := range txn.writes {
+		if entry == nil {
+			txn.store.remove(key)
+		} else {
+			txn.store.set(key, entry)
+		}
+	}
+	txn.writes = make(map[string]*memoryEntry)
+	return nil
+}
+
+func (txn *memoryTxn) Discard() {
+	txn.writes = make(map[string]*memoryEntry)
+}
+
+/*
+Ordered map used by the memory store, allowing prefix iteration.
+*/
+type skiplist struct {
+	head  *skiplistNode
+	level int
+	rand  *rand.Rand
+}
+
+type skiplistNode struct {
+	key   string
+	entry *memoryEntry
+	next  []*skiplistNode
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		head:  &skiplistNode{next: make([]*skiplistNode, memoryMaxLevel)},
+		level: 1,
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+/*
+Returns the last node before key on every level.
+*/
+func (list *skiplist) predecessors(key string) []*skiplistNode {
+	previous := make([]*skiplistNode, memoryMaxLevel)
+	n := list.head
+	for level := list.level - 1; level >= 0; level-- {
+		for n.next[level] != nil && n.next[level].key < key {
+			n = n.next[level]
+		}
+		previous[level] = n
+	}
+	return previous
+}
+
+/*
+Returns the first node with a key greater or equal to key.
+*/
+func (list *skiplist) seek(key string) *skiplistNode {
+	return list.predecessors(key)[0].next[0]
+}
+
+func (list *skiplist) get(key string) *memoryEntry {
+	n := list.seek(key)
+	if n == nil || n.key != key {
+		return nil
+	}
+	return n.entry
+}
+
+func (list *skiplist) set(key string, entry *memoryEntry) {
+	previous := list.predecessors(key)
+	if n := previous[0].next[0]; n != nil && n.key == key {
+		n.entry = entry
+		return
+	}
+
+	level := 1
+	for level < memoryMaxLevel && list.rand.Intn(4) == 0 {
+		level++
+	}
+	for ; list.level < level; list.level++ {
+		previous[list.level] = list.head
+	}
+	n := &skiplistNode{key: key, entry: entry, next: make([]*skiplistNode, level)}
+	for i := 0; i < level; i++ {
+		n.next[i] = previous[i].next[i]
+		previous[i].next[i] = n
+	}
+}
+
+func (list *skiplist) remove(key string) *memoryEntry {
+	previous := list.predecessors(key)
+	n := previous[0].next[0]
+	if n == nil || n.key != key {
+		return nil
+	}
+	for i := range n.next {
+		previous[i].next[i] = n.next[i]
+	}
+	return n.entry
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreHelpers(t *testing.T) {
+	DB = NewMemoryStore()
+	key := GetByteKey([]byte("tx"), KEY_VALUE)
+
+	if Has(key, nil) {
+		t.Fatal("Empty store has a key")
+	}
+	if _, err := GetInt64(key, nil); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+	if err := Put(key, int64(42), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	balance, err := IncrBy(key, -2, false, nil)
+	if err != nil || balance != 40 {
+		t.Errorf("IncrBy returned %v, %v", balance, err)
+	}
+	value, err := GetInt64(key, nil)
+	if err != nil || value != 40 {
+		t.Errorf("Expected 40, got %v, %v", value, err)
+	}
+	if err := Remove(key, nil); err != nil || Has(key, nil) {
+		t.Errorf("Key not removed: %v", err)
+	}
+}
+
+func TestMemoryStoreTransactions(t *testing.T) {
+	DB = NewMemoryStore()
+	key := []byte{KEY_TEST, 1}
+
+	err := DB.Update(func(txn Txn) error {
+		if err := PutBytes(key, []byte{1}, nil, txn); err != nil {
+			return err
+		}
+		if !Has(key, txn) {
+			t.Error("Uncommitted write not visible in its transaction")
+		}
+		if Has(key, nil) {
+			t.Error("Uncommitted write visible outside of its transaction")
+		}
+		return nil
+	})
+	if err != nil || !Has(key, nil) {
+		t.Errorf("Write not committed: %v", err)
+	}
+
+	txn := DB.NewTransaction(true)
+	Remove(key, txn)
+	txn.Discard()
+	if !Has(key, nil) {
+		t.Error("Discarded remove was applied")
+	}
+
+	ttl := time.Duration(-1) * time.Second
+	PutBytes([]byte{KEY_TEST, 2}, []byte{2}, &ttl, nil)
+	if Has([]byte{KEY_TEST, 2}, nil) {
+		t.Error("Expired key still readable")
+	}
+}
+
+func TestMemoryStoreForPrefix(t *testing.T) {
+	DB = NewMemoryStore()
+	for _, k := range [][]byte{{KEY_TIP, 3}, {KEY_TEST, 2}, {KEY_TIP, 1}, {KEY_HASH, 1}, {KEY_TIP, 2}} {
+		PutBytes(k, k[1:], nil, nil)
+	}
+	if Count(KEY_TIP) != 3 || CountByPrefix([]byte{KEY_TIP, 2}) != 1 {
+		t.Errorf("Wrong counts: %v, %v", Count(KEY_TIP), CountByPrefix([]byte{KEY_TIP, 2}))
+	}
+
+	var keys [][]byte
+	DB.Update(func(txn Txn) error {
+		txn.Remove([]byte{KEY_TIP, 3})
+		txn.Put([]byte{KEY_TIP, 0}, []byte{0}, nil)
+		return txn.ForPrefix([]byte{KEY_TIP}, true, func(key []byte, value []byte) (bool, error) {
+			if value[0] != key[1] {
+				t.Errorf("Wrong value %v for key %v", value, key)
+			}
+			keys = append(keys, key)
+			return true, nil
+		})
+	})
+	expected := [][]byte{{KEY_TIP, 0}, {KEY_TIP, 1}, {KEY_TIP, 2}}
+	if len(keys) != len(expected) {
+		t.Fatalf("Expected keys %v, got %v", expected, keys)
+	}
+	for i := range expected {
+		if !bytes.Equal(keys[i], expected[i]) {
+			t.Errorf("Expected keys %v, got %v", expected, keys)
+		}
+	}
+
+	RemoveAll(KEY_TIP)
+	if Count(KEY_TIP) != 0 || Count(KEY_TEST) != 1 {
+		t.Error("RemoveAll removed the wrong keys")
+	}
+}
@@ -0,0 +1,261 @@
+package db
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"../logs"
+)
+
+/*
+Version of the key layout and value encoding. Bump it together with a new entry in migrations
+whenever the layout changes, so that existing databases are upgraded in place.
+*/
+const SchemaVersion = 2
+
+var migrationBatchSize = 10000
+
+var (
+	schemaVersionKey     = GetByteKey([]byte("schemaVersion"), KEY_OTHER)
+	migrationProgressKey = GetByteKey([]byte("migrationProgress"), KEY_OTHER)
+)
+
+/*
+A migration upgrades the database from version-1 to version. run is called again from the start
+if the node stopped during the migration; the steps of a Migrator it already completed are skipped.
+*/
+type migration struct {
+	version     int
+	description string
+	run         func(m *Migrator) error
+}
+
+/*
+Ordered list of all migrations.
+*/
+var migrations = []migration{
+	// Databases created before the schema version was stored have the same layout as version 1.
+	{version: 1, description: "Add schema version", run: func(m *Migrator) error { return nil }},
+	{version: 2, description: "Add the milestone index to pending confirmations", run: migratePendingConfirmations},
+}
+
+/*
+Pending confirmations saved before version 2 do not know their milestone; 0 marks it as unknown.
+*/
+func migratePendingConfirmations(m *Migrator) error {
+	err := m.Rewrite([]byte{KEY_EVENT_CONFIRMATION_PENDING}, func(key []byte, value []byte) ([]byte, []byte, error) {
+		var timestamp int
+		err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(&timestamp)
+		if err != nil {
+			return nil, nil, err
+		}
+		value, err = Encode([]int{timestamp, 0})
+		return key, value, err
+	})
+	if err != nil {
+		return err
+	}
+	return m.Rewrite([]byte{KEY_PENDING_CONFIRMED}, func(key []byte, _ []byte) ([]byte, []byte, error) {
+		value, err := Encode(0)
+		return key, value, err
+	})
+}
+
+/*
+Progress of the running migration, saved together with every batch.
+*/
+type migrationProgress struct {
+	Version   int
+	Step      int
+	LastKey   []byte
+	Processed int64
+}
+
+/*
+Runs the steps of a single migration and keeps track of its progress.
+*/
+type Migrator struct {
+	version  int
+	step     int
+	progress migrationProgress
+}
+
+/*
+Returns the schema version stored in the database. Empty databases get the current version.
+Databases created before the version was stored are version 0.
+*/
+func GetSchemaVersion() int {
+	version, err := GetInt(schemaVersionKey, nil)
+	if err == nil {
+		return version
+	}
+	if isEmpty() {
+		err = Put(schemaVersionKey, SchemaVersion, nil, nil)
+		if err != nil {
+			logs.Log.Fatal("Could not save the database schema version:", err)
+		}
+		return SchemaVersion
+	}
+	return 0
+}
+
+/*
+Checks the schema version of the database and upgrades it, if needed.
+*/
+func checkSchema() {
+	version := GetSchemaVersion()
+	if version > SchemaVersion {
+		logs.Log.Fatalf("The database schema version %v is newer than the supported version %v. Please upgrade Hercules.",
+			version, SchemaVersion)
+	}
+	if version == SchemaVersion {
+		logs.Log.Debug("Database schema version", version)
+		return
+	}
+
+	logs.Log.Noticef("Upgrading the database schema from version %v to %v. This might take a while...", version, SchemaVersion)
+	err := runMigrations(version)
+	if err != nil {
+		logs.Log.Fatal("Database migration failed. Restart Hercules to resume it.", err)
+	}
+	logs.Log.Notice("Database schema upgraded")
+}
+
+func runMigrations(from int) error {
+	for _, m := range migrations {
+		if m.version <= from {
+			continue
+		}
+		logs.Log.Infof("Running database migration %v: %v", m.version, m.description)
+
+		migrator := &Migrator{version: m.version}
+		progress, err := loadMigrationProgress()
+		if err != nil {
+			return err
+		}
+		if progress != nil && progress.Version == m.version {
+			logs.Log.Infof("Resuming migration %v at step %v", m.version, progress.Step)
+			migrator.progress = *progress
+		} else {
+			migrator.progress = migrationProgress{Version: m.version}
+		}
+
+		err = m.run(migrator)
+		if err != nil {
+			return err
+		}
+		err = DB.Update(func(txn Txn) error {
+			err := Put(schemaVersionKey, m.version, nil, txn)
+			if err != nil {
+				return err
+			}
+			return Remove(migrationProgressKey, txn)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadMigrationProgress() (*migrationProgress, error) {
+	var progress migrationProgress
+	err := Get(migrationProgressKey, &progress, nil)
+	if err == ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &progress, nil
+}
+
+/*
+Rewrites all entries with the given prefix in batches. fn returns the new key and value of each entry.
+If the key changes, the old entry is removed; a nil key only removes it. New keys must not sort after
+the current one within prefix, or they would be rewritten again.
+Each call is one step of the migration. The last rewritten key is saved with every batch,
+so an interrupted step continues after it.
+*/
+func (m *Migrator) Rewrite(prefix []byte, fn func(key []byte, value []byte) ([]byte, []byte, error)) error {
+	step := m.step
+	m.step++
+	if m.progress.Step > step {
+		return nil
+	}
+
+	start := prefix
+	if m.progress.LastKey != nil {
+		start = m.progress.LastKey
+	}
+	batchSize := migrationBatchSize
+
+	for {
+		var entries []KeyValue
+		err := DB.View(func(txn Txn) error {
+			return txn.ForRange(prefix, start, true, func(key []byte, value []byte) (bool, error) {
+				if m.progress.LastKey != nil && bytes.Equal(key, m.progress.LastKey) {
+					return true, nil
+				}
+				entries = append(entries, KeyValue{key, append([]byte{}, value...)})
+				return len(entries) < batchSize, nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			break
+		}
+
+		progress := m.progress
+		progress.LastKey = entries[len(entries)-1].Key
+		progress.Processed += int64(len(entries))
+		err = DB.Update(func(txn Txn) error {
+			for _, entry := range entries {
+				key, value, err := fn(entry.Key, entry.Value)
+				if err != nil {
+					return err
+				}
+				if key == nil || !bytes.Equal(key, entry.Key) {
+					err = txn.Remove(entry.Key)
+					if err != nil {
+						return err
+					}
+				}
+				if key != nil {
+					err = txn.Put(key, value, nil)
+					if err != nil {
+						return err
+					}
+				}
+			}
+			return Put(migrationProgressKey, progress, nil, txn)
+		})
+		if err == ErrTxnTooBig && batchSize > 1 {
+			batchSize /= 2
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		m.progress = progress
+		start = progress.LastKey
+		logs.Log.Infof("Migration %v, step %v: %v entries rewritten", m.version, step, progress.Processed)
+	}
+
+	m.progress = migrationProgress{Version: m.version, Step: step + 1}
+	return Put(migrationProgressKey, m.progress, nil, nil)
+}
+
+func isEmpty() bool {
+	empty := true
+	_ = DB.View(func(txn Txn) error {
+		return txn.ForPrefix([]byte{}, false, func(_ []byte, _ []byte) (bool, error) {
+			empty = false
+			return false, nil
+		})
+	})
+	return empty
+}
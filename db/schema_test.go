@@ -0,0 +1,109 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSchemaVersionOfEmptyDatabase(t *testing.T) {
+	DB = NewMemoryStore()
+	if version := GetSchemaVersion(); version != SchemaVersion {
+		t.Errorf("Expected version %v, got %v", SchemaVersion, version)
+	}
+
+	DB = NewMemoryStore()
+	PutBytes([]byte{KEY_TEST, 1}, []byte{1}, nil, nil)
+	if version := GetSchemaVersion(); version != 0 {
+		t.Errorf("Expected version 0 for an unversioned database, got %v", version)
+	}
+}
+
+func TestMigrationResumes(t *testing.T) {
+	DB = NewMemoryStore()
+	for i := 0; i < 25; i++ {
+		Put([]byte{KEY_TEST, byte(i)}, int64(i), nil, nil)
+	}
+	Put(schemaVersionKey, 0, nil, nil)
+
+	defer func(original []migration) { migrations = original }(migrations)
+	failAt := 12
+	calls := 0
+	migrations = []migration{{version: 1, description: "Test", run: func(m *Migrator) error {
+		// Move the values to KEY_VALUE, doubled
+		err := m.Rewrite([]byte{KEY_TEST}, func(key []byte, value []byte) ([]byte, []byte, error) {
+			calls++
+			if calls == failAt {
+				return nil, nil, errors.New("interrupted")
+			}
+			return append([]byte{KEY_VALUE}, key[1:]...), append(value, value...), nil
+		})
+		if err != nil {
+			return err
+		}
+		// Remove every second entry
+		return m.Rewrite([]byte{KEY_VALUE}, func(key []byte, value []byte) ([]byte, []byte, error) {
+			if key[1]%2 == 1 {
+				return nil, nil, nil
+			}
+			return key, value, nil
+		})
+	}}}
+
+	defer func(size int) { migrationBatchSize = size }(migrationBatchSize)
+	migrationBatchSize = 5
+
+	if err := runMigrations(0); err == nil {
+		t.Fatal("Expected the migration to be interrupted")
+	}
+	if version, _ := GetInt(schemaVersionKey, nil); version != 0 {
+		t.Errorf("Version changed by an interrupted migration: %v", version)
+	}
+	if moved := Count(KEY_VALUE); moved != 10 {
+		t.Errorf("Expected two committed batches, got %v entries", moved)
+	}
+
+	calls = 0
+	failAt = -1
+	if err := runMigrations(0); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 15 {
+		t.Errorf("Expected 15 rewritten entries after resuming, got %v", calls)
+	}
+	if version, _ := GetInt(schemaVersionKey, nil); version != 1 {
+		t.Errorf("Expected version 1, got %v", version)
+	}
+	if Count(KEY_TEST) != 0 || Count(KEY_VALUE) != 13 || Has(migrationProgressKey, nil) {
+		t.Errorf("Unexpected database state: %v, %v", Count(KEY_TEST), Count(KEY_VALUE))
+	}
+	for i := 0; i < 25; i += 2 {
+		var value []byte
+		value, _ = GetBytesRaw([]byte{KEY_VALUE, byte(i)}, nil)
+		if len(value) == 0 {
+			t.Errorf("Entry %v missing", i)
+		}
+	}
+}
+
+func TestPendingConfirmationsMigration(t *testing.T) {
+	DB = NewMemoryStore()
+	Put(schemaVersionKey, 1, nil, nil)
+	pendingKey := GetByteKey([]byte("tx"), KEY_EVENT_CONFIRMATION_PENDING)
+	Put(pendingKey, 1525017600, nil, nil)
+	pendingConfirmedKey := GetByteKey([]byte("tx"), KEY_PENDING_CONFIRMED)
+	Put(pendingConfirmedKey, 1525017601, nil, nil)
+
+	if err := runMigrations(1); err != nil {
+		t.Fatal(err)
+	}
+	var pending []int
+	if err := Get(pendingKey, &pending, nil); err != nil || len(pending) != 2 || pending[0] != 1525017600 || pending[1] != 0 {
+		t.Errorf("Unexpected pending confirmation: %v, %v", pending, err)
+	}
+	if index, err := GetInt(pendingConfirmedKey, nil); err != nil || index != 0 {
+		t.Errorf("Unexpected pending confirmed milestone index: %v, %v", index, err)
+	}
+	if version, _ := GetInt(schemaVersionKey, nil); version != 2 {
+		t.Errorf("Expected version 2, got %v", version)
+	}
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"errors"
+	"time"
+)
+
+/*
+Storage engine used by the node. All packages access the database through this interface,
+so the engine can be swapped, e.g. for the in-memory store in tests.
+*/
+type Store interface {
+	// Runs fn in a read-only transaction
+	View(fn func(txn Txn) error) error
+	// Runs fn in a read-write transaction, committed if fn returns no error
+	Update(fn func(txn Txn) error) error
+	// The transaction has to be committed or discarded by the caller
+	NewTransaction(update bool) Txn
+	// Size of the LSM tree and the value log in bytes (0 if not applicable)
+	Size() (lsm int64, vlog int64)
+	// Garbage-collects debris from the storage
+	Cleanup()
+	Close() error
+}
+
+/*
+Transaction of a Store. Not safe for concurrent use.
+Get, Has, Put, Remove and IncrBy of this package add value encoding on top of it.
+*/
+type Txn interface {
+	Has(key []byte) bool
+	// Returns ErrKeyNotFound if the key does not exist
+	Get(key []byte) ([]byte, error)
+	// Stores the value. A nil ttl keeps the value forever. Returns ErrTxnTooBig if the transaction is full.
+	Put(key []byte, value []byte, ttl *time.Duration) error
+	Remove(key []byte) error
+	// Calls fn for all keys starting with prefix, in ascending key order, until fn returns false or an error.
+	// Without fetchValues, nil is passed as value. The key can be kept, the value only until the transaction ends.
+	ForPrefix(prefix []byte, fetchValues bool, fn func(key []byte, value []byte) (bool, error)) error
+	// Like ForPrefix, but starts at the first key greater than or equal to start
+	ForRange(prefix []byte, start []byte, fetchValues bool, fn func(key []byte, value []byte) (bool, error)) error
+	Commit() error
+	Discard()
+}
+
+var (
+	ErrKeyNotFound = errors.New("Key not found")
+	ErrTxnTooBig   = errors.New("Transaction is too big")
+)
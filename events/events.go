@@ -0,0 +1,126 @@
+package events
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+Internal publish/subscribe bus for node events. The messages have the same layout as the IRI ZMQ feed:
+the topic followed by space separated fields, e.g. "lmi 512 513".
+Like in ZMQ, a subscription matches every message starting with the subscribed topic.
+*/
+
+const subscriberQueueSize = 10000
+
+type Subscriber struct {
+	Messages chan string
+	topics   map[string]bool
+	lock     *sync.RWMutex
+	dropped  uint64
+	closed   bool
+}
+
+var subscribers = make(map[*Subscriber]bool)
+var subscribersLock = &sync.RWMutex{}
+var subscriberCount int32 = 0
+
+/*
+Creates a new subscriber for the given topics. An empty topic matches all messages.
+*/
+func Subscribe(topics ...string) *Subscriber {
+	subscriber := &Subscriber{
+		Messages: make(chan string, subscriberQueueSize),
+		topics:   make(map[string]bool),
+		lock:     &sync.RWMutex{},
+	}
+	subscriber.Subscribe(topics...)
+
+	subscribersLock.Lock()
+	subscribers[subscriber] = true
+	atomic.StoreInt32(&subscriberCount, int32(len(subscribers)))
+	subscribersLock.Unlock()
+	return subscriber
+}
+
+/*
+Returns true if anyone is listening. Publishers use this to skip building expensive messages.
+*/
+func HasSubscribers() bool {
+	return atomic.LoadInt32(&subscriberCount) > 0
+}
+
+/*
+Sends the formatted message to all matching subscribers. Never blocks:
+messages for subscribers that do not keep up are dropped.
+*/
+func Publish(format string, args ...interface{}) {
+	if !HasSubscribers() {
+		return
+	}
+	message := fmt.Sprintf(format, args...)
+
+	subscribersLock.RLock()
+	defer subscribersLock.RUnlock()
+	for subscriber := range subscribers {
+		if !subscriber.matches(message) {
+			continue
+		}
+		select {
+		case subscriber.Messages <- message:
+		default:
+			atomic.AddUint64(&subscriber.dropped, 1)
+		}
+	}
+}
+
+func (subscriber *Subscriber) Subscribe(topics ...string) {
+	subscriber.lock.Lock()
+	defer subscriber.lock.Unlock()
+	for _, topic := range topics {
+		subscriber.topics[topic] = true
+	}
+}
+
+func (subscriber *Subscriber) Unsubscribe(topics ...string) {
+	subscriber.lock.Lock()
+	defer subscriber.lock.Unlock()
+	for _, topic := range topics {
+		delete(subscriber.topics, topic)
+	}
+}
+
+/*
+Number of messages dropped because the subscriber queue was full.
+*/
+func (subscriber *Subscriber) Dropped() uint64 {
+	return atomic.LoadUint64(&subscriber.dropped)
+}
+
+/*
+Removes the subscriber from the bus and closes its message channel.
+*/
+func (subscriber *Subscriber) Close() {
+	subscribersLock.Lock()
+	defer subscribersLock.Unlock()
+	if subscriber.closed {
+		return
+	}
+	subscriber.closed = true
+	delete(subscribers, subscriber)
+	atomic.StoreInt32(&subscriberCount, int32(len(subscribers)))
+	close(subscriber.Messages)
+}
+
+func (subscriber *Subscriber) matches(message string) bool {
+	subscriber.lock.RLock()
+	defer subscriber.lock.RUnlock()
+	for topic := range subscriber.topics {
+		if strings.HasPrefix(message, topic) {
+			return true
+		}
+	}
+	return false
+}
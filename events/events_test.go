@@ -0,0 +1,59 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestPublish(t *testing.T) {
+	address := "ADDRESS9ADDRESS9ADDRESS9ADDRESS9ADDRESS9ADDRESS9ADDRESS9ADDRESS9ADDRESS9ADDRESS99"
+	milestones := Subscribe("lmi", "lmsi")
+	addresses := Subscribe(address)
+	all := Subscribe("")
+	defer milestones.Close()
+	defer addresses.Close()
+	defer all.Close()
+
+	Publish("lmi %v %v", 1, 2)
+	Publish("tx %v", "HASH")
+	Publish("%v %v %v sn", address, "HASH", 2)
+
+	expectMessages(t, milestones, "lmi 1 2")
+	expectMessages(t, addresses, address+" HASH 2 sn")
+	expectMessages(t, all, "lmi 1 2", "tx HASH", address+" HASH 2 sn")
+
+	milestones.Unsubscribe("lmi")
+	Publish("lmi %v %v", 2, 3)
+	Publish("lmsi %v %v", 1, 2)
+	expectMessages(t, milestones, "lmsi 1 2")
+}
+
+func TestClose(t *testing.T) {
+	subscriber := Subscribe("tx")
+	subscriber.Close()
+	subscriber.Close()
+	if HasSubscribers() {
+		t.Error("Closed subscriber still registered")
+	}
+	Publish("tx %v", "HASH")
+	if _, ok := <-subscriber.Messages; ok {
+		t.Error("Message received after closing")
+	}
+}
+
+func expectMessages(t *testing.T, subscriber *Subscriber, expected ...string) {
+	for _, e := range expected {
+		select {
+		case message := <-subscriber.Messages:
+			if message != e {
+				t.Errorf("Expected '%v', got '%v'", e, message)
+			}
+		default:
+			t.Errorf("Expected '%v', got nothing", e)
+		}
+	}
+	select {
+	case message := <-subscriber.Messages:
+		t.Errorf("Unexpected message '%v'", message)
+	default:
+	}
+}
@@ -8,8 +8,10 @@ import (
 	"time"
 
 	"./api"
+	"./coordinator"
 	"./db"
 	"./logs"
+	"./network"
 	"./server"
 	"./snapshot"
 	"./tangle"
@@ -36,6 +38,8 @@ func main() {
 
 func StartHercules() {
 	logs.Log.Info("Starting Hercules. Please wait...")
+	network.Load(config)
+	coordinator.Load(config)
 	db.Load(config)
 	srv := server.Create(config)
 
@@ -43,6 +47,7 @@ func StartHercules() {
 	tangle.Start(srv, config)
 	server.Start()
 	api.Start(config)
+	coordinator.Start()
 
 	go db.StartPeriodicDatabaseCleanup()
 
@@ -88,21 +93,50 @@ func loadConfig() *viper.Viper {
 	flag.Bool("log.hello", true, "Show welcome banner")
 
 	flag.String("database.path", "data", "Path to the database directory")
+	flag.String("database.engine", "badger", "Database engine: badger or memory (nothing is persisted)")
+	flag.Bool("permanode.enabled", false, "Keep all transactions and index them by time for historical queries")
+
+	flag.Float64("tipselection.alpha", 0.001, "Randomness of the tip selection walk: 0 = uniformly random, higher = prefer heavier transactions")
+	flag.Int("tipselection.walkers", 2, "Number of parallel random walks per tip selection round")
 
 	flag.String("snapshots.path", "data", "Path to the snapshots directory")
 	flag.String("snapshots.filename", "", "If set, the snapshots will be saved using this name, "+
 		"otherwise <timestamp>.snap wil be used")
+	flag.Int("snapshots.format", 1, "Format of the saved snapshots: 1 (text) or 2 (binary, compressed and checksummed)")
+	flag.Int("snapshots.delta.interval", 0, "Number of delta snapshots to save between two full snapshots. 0 = off")
 	flag.String("snapshots.loadFile", "", "Path to a snapshot file to load")
-	flag.String("snapshots.loadIRIFile", "", "Path to an IRI snapshot file to load")
+	flag.StringSlice("snapshots.loadDeltas", nil, "Paths to the delta snapshot files to load on top of the loadFile, in order")
+	flag.String("snapshots.loadIRIFile", "", "Path to an IRI snapshot file or IRI local snapshot to load")
 	flag.String("snapshots.loadIRISpentFile", "", "Path to an IRI spent snapshot file to load")
 	flag.Int("snapshots.loadIRITimestamp", 0, "Timestamp for which to load the given IRI snapshot files.")
 	flag.Int("snapshots.interval", 0, "Interval in hours to automatically make the snapshots. 0 = off")
 	flag.Int("snapshots.period", 24, "How many hours of tangle data to keep after the snapshot.")
+	flag.Int("snapshots.milestoneDepth", 0, "If set, the automatic snapshots are made at the latest milestone index "+
+		"minus this depth instead of the period. 0 = off")
 	flag.Bool("snapshots.enableapi", true, "Enable snapshot api commands: "+
-		"makeSnapshot, getSnapshotsInfo")
+		"makeSnapshot, exportSnapshot, getSnapshotsInfo")
 	flag.Bool("snapshots.keep", false, "Whether to keep transactions past the horizon after making a snapshot.")
+	flag.String("snapshots.signing.keyFile", "", "File with the Ed25519 key to sign the saved snapshots with (generated if missing)")
+	flag.StringSlice("snapshots.signing.trustedKeys", nil, "Public keys (hex) of the trusted snapshot publishers")
+	flag.Bool("snapshots.signing.allowUnsigned", false, "Load snapshot files that are unsigned or signed by untrusted keys")
+	flag.StringSlice("snapshots.pins.transactions", nil, "Transaction hashes to keep beyond the snapshots")
+	flag.StringSlice("snapshots.pins.bundles", nil, "Bundle hashes to keep beyond the snapshots")
+	flag.StringSlice("snapshots.pins.addresses", nil, "Addresses whose transactions to keep beyond the snapshots")
+	flag.StringSlice("snapshots.pins.tags", nil, "Tags whose transactions to keep beyond the snapshots")
+
+	declareNetworkConfigs()
+
+	flag.Bool("coordinator.enabled", false, "Run a local coordinator issuing milestones (custom network profile only)")
+	flag.String("coordinator.seed", "", "Seed of the local coordinator")
+	flag.Int("coordinator.interval", 60, "Interval in seconds between two milestones of the local coordinator")
 
 	flag.IntP("node.port", "u", 14600, "UDP Node port")
+	flag.Int("node.tcpPort", 15600, "TCP Node port. 0 = no incoming TCP connections")
+	flag.Int("node.health.window", 600, "Sliding window in seconds for the neighbor health scores. 0 = off")
+	flag.Int("node.health.minSamples", 100, "Minimal amount of incoming messages in the window before a neighbor is throttled or dropped")
+	flag.Float64("node.health.throttleScore", 0.4, "Neighbors with a lower health score are throttled")
+	flag.Float64("node.health.dropScore", 0.2, "Neighbors with a lower health score are dropped and banned")
+	flag.Int("node.health.banDuration", 60, "How many minutes dropped neighbors stay banned")
 	flag.StringSliceP("node.neighbors", "n", nil, "Initial Node neighbors")
 
 	config.BindPFlags(flag.CommandLine)
@@ -147,6 +181,9 @@ func loadConfig() *viper.Viper {
 
 func declareApiConfigs() {
 	flag.Bool("api.debug", false, "Whether to log api access")
+	flag.Bool("api.metrics.enabled", false, "Serve Prometheus metrics on /metrics (to local clients only, unless API authentication is enabled)")
+	flag.Bool("api.events.enabled", false, "Stream node events (IRI ZMQ topics) over a WebSocket on /events")
+	flag.StringSlice("api.events.allowedOrigins", nil, "Origins of web pages allowed to connect to /events, besides the node's host")
 
 	flag.String("api.auth.username", "", "API Access Username")
 	flag.String("api.auth.password", "", "API Access Password")
@@ -169,6 +206,20 @@ func declareApiConfigs() {
 	flag.String("api.pow.powSrvPath", "/tmp/powSrv.sock", "Unix socket path of PowSrv")
 }
 
+/*
+The defaults correspond to the mainnet. They are only used with the custom network profile.
+*/
+func declareNetworkConfigs() {
+	flag.String("network.profile", network.MAINNET, "Network profile: mainnet, devnet or custom")
+	flag.String("network.coordinator.address", "", "Coordinator address (custom profile only)")
+	flag.Int("network.coordinator.depth", network.DEFAULT_CUSTOM_DEPTH, "Depth of the coordinator Merkle tree (custom profile only)")
+	flag.Int("network.coordinator.security", network.Mainnet.MilestoneSecurity, "Security level of the coordinator signatures (custom profile only)")
+	flag.Int("network.mwm", network.Mainnet.MWM, "Minimum weight magnitude of incoming transactions (custom profile only)")
+	flag.Int64("network.supply", network.Mainnet.TotalSupply, "Total supply of iotas (custom profile only)")
+	flag.Int64("network.snapshots.minSpentAddresses", network.Mainnet.MinSpentAddresses, "Minimal amount of spent addresses in a snapshot (custom profile only)")
+	flag.Int64("network.snapshots.minTimestamp", network.Mainnet.MinSnapshotTime, "Earliest allowed snapshot timestamp (custom profile only)")
+}
+
 func Hello() {
 	if !config.GetBool("log.hello") {
 		return
@@ -0,0 +1,205 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+Minimal implementation of the Prometheus text exposition format.
+Modules register their metrics with a function returning the current values,
+which is called on every scrape.
+*/
+
+const (
+	COUNTER   = "counter"
+	GAUGE     = "gauge"
+	HISTOGRAM = "histogram"
+)
+
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+type metric struct {
+	name      string
+	help      string
+	kind      string
+	collect   func() []Sample
+	histogram *Histogram
+}
+
+type Histogram struct {
+	label   string
+	buckets []float64
+	series  map[string]*histogramSeries
+	lock    *sync.Mutex
+}
+
+type histogramSeries struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Default buckets for durations in seconds
+var DurationBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+var metrics []*metric
+var metricsLock = &sync.RWMutex{}
+
+func AddCounter(name string, help string, value func() float64) {
+	AddCounterVec(name, help, func() []Sample {
+		return []Sample{{Value: value()}}
+	})
+}
+
+func AddGauge(name string, help string, value func() float64) {
+	AddGaugeVec(name, help, func() []Sample {
+		return []Sample{{Value: value()}}
+	})
+}
+
+func AddCounterVec(name string, help string, collect func() []Sample) {
+	add(&metric{name: name, help: help, kind: COUNTER, collect: collect})
+}
+
+func AddGaugeVec(name string, help string, collect func() []Sample) {
+	add(&metric{name: name, help: help, kind: GAUGE, collect: collect})
+}
+
+/*
+Registers a histogram with one series per value of the given label.
+*/
+func NewHistogram(name string, help string, label string, buckets []float64) *Histogram {
+	histogram := &Histogram{
+		label:   label,
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+		lock:    &sync.Mutex{},
+	}
+	add(&metric{name: name, help: help, kind: HISTOGRAM, histogram: histogram})
+	return histogram
+}
+
+func add(m *metric) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+
+	// Re-registering replaces the old metric
+	for i, existing := range metrics {
+		if existing.name == m.name {
+			metrics[i] = m
+			return
+		}
+	}
+	metrics = append(metrics, m)
+}
+
+func (histogram *Histogram) Observe(labelValue string, value float64) {
+	histogram.lock.Lock()
+	defer histogram.lock.Unlock()
+
+	series, ok := histogram.series[labelValue]
+	if !ok {
+		series = &histogramSeries{counts: make([]uint64, len(histogram.buckets))}
+		histogram.series[labelValue] = series
+	}
+	for i, bound := range histogram.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.sum += value
+	series.count++
+}
+
+/*
+Writes all registered metrics in the Prometheus text format.
+*/
+func Write(w io.Writer) {
+	metricsLock.RLock()
+	registered := make([]*metric, len(metrics))
+	copy(registered, metrics)
+	metricsLock.RUnlock()
+
+	for _, m := range registered {
+		fmt.Fprintf(w, "# HELP %v %v\n", m.name, escape(m.help, false))
+		fmt.Fprintf(w, "# TYPE %v %v\n", m.name, m.kind)
+		if m.histogram != nil {
+			m.histogram.write(w, m.name)
+			continue
+		}
+		for _, sample := range m.collect() {
+			fmt.Fprintf(w, "%v%v %v\n", m.name, formatLabels(sample.Labels), formatValue(sample.Value))
+		}
+	}
+}
+
+func (histogram *Histogram) write(w io.Writer, name string) {
+	histogram.lock.Lock()
+	defer histogram.lock.Unlock()
+
+	var labelValues []string
+	for labelValue := range histogram.series {
+		labelValues = append(labelValues, labelValue)
+	}
+	sort.Strings(labelValues)
+
+	for _, labelValue := range labelValues {
+		series := histogram.series[labelValue]
+		for i, bound := range histogram.buckets {
+			labels := map[string]string{histogram.label: labelValue, "le": formatValue(bound)}
+			fmt.Fprintf(w, "%v_bucket%v %v\n", name, formatLabels(labels), series.counts[i])
+		}
+		labels := map[string]string{histogram.label: labelValue, "le": "+Inf"}
+		fmt.Fprintf(w, "%v_bucket%v %v\n", name, formatLabels(labels), series.count)
+		labels = map[string]string{histogram.label: labelValue}
+		fmt.Fprintf(w, "%v_sum%v %v\n", name, formatLabels(labels), formatValue(series.sum))
+		fmt.Fprintf(w, "%v_count%v %v\n", name, formatLabels(labels), series.count)
+	}
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var names []string
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var pairs []string
+	for _, name := range names {
+		pairs = append(pairs, name+"=\""+escape(labels[name], true)+"\"")
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func escape(value string, quotes bool) string {
+	value = strings.Replace(value, "\\", "\\\\", -1)
+	value = strings.Replace(value, "\n", "\\n", -1)
+	if quotes {
+		value = strings.Replace(value, "\"", "\\\"", -1)
+	}
+	return value
+}
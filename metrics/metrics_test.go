@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	AddGauge("test_gauge", "A gauge", func() float64 { return 1.5 })
+	AddCounterVec("test_counter", "A counter", func() []Sample {
+		return []Sample{{Labels: map[string]string{"b": "x\"y", "a": "1"}, Value: 3}}
+	})
+	histogram := NewHistogram("test_duration_seconds", "A histogram", "command", []float64{0.1, 1})
+	histogram.Observe("foo", 0.05)
+	histogram.Observe("foo", 0.5)
+
+	var buf bytes.Buffer
+	Write(&buf)
+	output := buf.String()
+
+	expected := []string{
+		"# TYPE test_gauge gauge\ntest_gauge 1.5\n",
+		"# TYPE test_counter counter\ntest_counter{a=\"1\",b=\"x\\\"y\"} 3\n",
+		"test_duration_seconds_bucket{command=\"foo\",le=\"0.1\"} 1\n",
+		"test_duration_seconds_bucket{command=\"foo\",le=\"1\"} 2\n",
+		"test_duration_seconds_bucket{command=\"foo\",le=\"+Inf\"} 2\n",
+		"test_duration_seconds_sum{command=\"foo\"} 0.55\n",
+		"test_duration_seconds_count{command=\"foo\"} 2\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(output, e) {
+			t.Errorf("Output does not contain %q:\n%v", e, output)
+		}
+	}
+}
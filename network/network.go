@@ -0,0 +1,104 @@
+package network
+
+import (
+	"strings"
+
+	"../logs"
+	"github.com/spf13/viper"
+)
+
+const (
+	MAINNET = "mainnet"
+	DEVNET  = "devnet"
+	CUSTOM  = "custom"
+)
+
+// Default coordinator depth of the custom profile. A local coordinator keeps 2^depth keys in its
+// Merkle tree, which are generated on every start.
+const DEFAULT_CUSTOM_DEPTH = 16
+
+/*
+Network specific parameters. Everything that differs between the mainnet,
+the devnet and private tangles should be read from here.
+*/
+type Profile struct {
+	Name               string
+	CoordinatorAddress string // Merkle root of the coordinator milestone keys
+	MilestoneDepth     int    // Depth of the coordinator Merkle tree (number of keys = 2^depth)
+	MilestoneSecurity  int    // Security level of the coordinator milestone signatures
+	MWM                int    // Minimum weight magnitude for the transaction PoW
+	TotalSupply        int64  // Total amount of iotas in a snapshot
+	MinSpentAddresses  int64  // Minimal amount of spent addresses in a valid snapshot file
+	MinSnapshotTime    int64  // Earliest timestamp a snapshot can be made or loaded for
+}
+
+var Mainnet = Profile{
+	Name:               MAINNET,
+	CoordinatorAddress: "KPWCHICGJZXKE9GSUDXZYUAPLHAKAHYHDXNPHENTERYMMBQOPSQIDENXKLKCEYCPVTZQLEEJVYJZV9BWU",
+	MilestoneDepth:     20,
+	MilestoneSecurity:  1,
+	MWM:                14,
+	TotalSupply:        2779530283277761,
+	MinSpentAddresses:  521970,
+	MinSnapshotTime:    1525017600,
+}
+
+var Devnet = Profile{
+	Name:               DEVNET,
+	CoordinatorAddress: "EQQFCZBIHRHWPXKMTOLMYUYPCN9XLMJPYZVFJSAY9FQHCCLWTOLLUGKKMXYFDBOOYFBLBI9WUEILGECYM",
+	MilestoneDepth:     22,
+	MilestoneSecurity:  1,
+	MWM:                9,
+	TotalSupply:        2779530283277761,
+	MinSpentAddresses:  0,
+	MinSnapshotTime:    0,
+}
+
+// The profile currently in use. Defaults to the mainnet.
+var Current = Mainnet
+
+/*
+Selects the network profile according to the config. The custom profile
+reads all values from the "network" config section.
+*/
+func Load(config *viper.Viper) {
+	name := strings.ToLower(config.GetString("network.profile"))
+	switch name {
+	case "", MAINNET:
+		Current = Mainnet
+	case DEVNET:
+		Current = Devnet
+	case CUSTOM:
+		Current = loadCustom(config)
+	default:
+		logs.Log.Fatalf("Unknown network profile: %v", name)
+	}
+
+	logs.Log.Infof("Using %v network profile. Coordinator: %v, MWM: %v",
+		Current.Name, Current.CoordinatorAddress, Current.MWM)
+}
+
+func loadCustom(config *viper.Viper) Profile {
+	profile := Mainnet
+	profile.Name = CUSTOM
+
+	// A local coordinator sets the address itself, derived from its seed.
+	address := config.GetString("network.coordinator.address")
+	if len(address) != 81 && !(len(address) == 0 && config.GetBool("coordinator.enabled")) {
+		logs.Log.Fatalf("The custom network profile needs a valid coordinator address (81 trytes): '%v'", address)
+	}
+	profile.CoordinatorAddress = address
+
+	profile.MilestoneDepth = config.GetInt("network.coordinator.depth")
+	profile.MilestoneSecurity = config.GetInt("network.coordinator.security")
+	profile.MWM = config.GetInt("network.mwm")
+	profile.TotalSupply = config.GetInt64("network.supply")
+	profile.MinSpentAddresses = config.GetInt64("network.snapshots.minSpentAddresses")
+	profile.MinSnapshotTime = config.GetInt64("network.snapshots.minTimestamp")
+
+	if profile.MilestoneDepth < 1 || profile.MilestoneSecurity < 1 || profile.MilestoneSecurity > 3 ||
+		profile.MWM < 1 || profile.TotalSupply < 1 {
+		logs.Log.Fatalf("Invalid custom network profile: %+v", profile)
+	}
+	return profile
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"../logs"
+	"../utils"
+)
+
+const (
+	healthBuckets     = 10 // The sliding window is split into this many buckets
+	throttledDropRate = 50 // Percentage of incoming messages dropped from throttled neighbors
+)
+
+type HealthCounts struct {
+	Incoming  int
+	New       int
+	Invalid   int
+	Duplicate int
+	Requested int // Requests for missing transactions sent to the neighbor
+	Fulfilled int // Missing transactions received from the neighbor
+}
+
+/*
+Health of a neighbor over the sliding window. Updated by the health checker.
+*/
+type NeighborHealth struct {
+	Score     float64
+	Throttled bool
+	Window    HealthCounts
+	buckets   [healthBuckets]HealthCounts
+	current   int
+}
+
+type Ban struct {
+	Address string // Formatted like: <ip>:<port>
+	Until   time.Time
+	Reason  string
+}
+
+var bans = make(map[string]*Ban)
+var bansLock = &sync.RWMutex{}
+var healthTicker *time.Ticker
+
+func startHealthChecker() {
+	window := time.Duration(config.GetInt("node.health.window")) * time.Second
+	if window <= 0 {
+		logs.Log.Info("Neighbor health checks disabled")
+		return
+	}
+	healthTicker = time.NewTicker(window / healthBuckets)
+	for range healthTicker.C {
+		if ended {
+			break
+		}
+		checkNeighborsHealth()
+	}
+}
+
+func (health *NeighborHealth) track(msg *NeighborTrackingMessage) {
+	bucket := &health.buckets[health.current]
+	bucket.Incoming += msg.Incoming
+	bucket.New += msg.New
+	bucket.Invalid += msg.Invalid
+	bucket.Duplicate += msg.Duplicate
+	bucket.Requested += msg.Requested
+	bucket.Fulfilled += msg.Fulfilled
+}
+
+/*
+Sums up the buckets of the window, updates the score and starts a new bucket.
+*/
+func (health *NeighborHealth) update() {
+	window := HealthCounts{}
+	for _, bucket := range health.buckets {
+		window.Incoming += bucket.Incoming
+		window.New += bucket.New
+		window.Invalid += bucket.Invalid
+		window.Duplicate += bucket.Duplicate
+		window.Requested += bucket.Requested
+		window.Fulfilled += bucket.Fulfilled
+	}
+	health.Window = window
+	health.Score = window.score()
+
+	health.current = (health.current + 1) % healthBuckets
+	health.buckets[health.current] = HealthCounts{}
+}
+
+/*
+Score between 0 and 1: (1 - invalid ratio) * (0.5 + 0.25 * fulfilment rate + 0.25 * min(1, 10 * new ratio))
+*/
+func (counts HealthCounts) score() float64 {
+	if counts.Incoming == 0 {
+		return 1
+	}
+	invalidRatio := math.Min(1, float64(counts.Invalid)/float64(counts.Incoming))
+	newRatio := float64(counts.New) / float64(counts.Incoming)
+	return (1 - invalidRatio) * (0.5 + 0.25*counts.fulfilmentRate() + 0.25*math.Min(1, 10*newRatio))
+}
+
+func (counts HealthCounts) fulfilmentRate() float64 {
+	if counts.Requested == 0 {
+		return 1
+	}
+	return math.Min(1, float64(counts.Fulfilled)/float64(counts.Requested))
+}
+
+func (counts HealthCounts) ratio(value int) float64 {
+	if counts.Incoming == 0 {
+		return 0
+	}
+	return float64(value) / float64(counts.Incoming)
+}
+
+/*
+Returns the health of a neighbor in the form shown by the getNeighbors API call.
+*/
+func (health NeighborHealth) Report() map[string]interface{} {
+	window := health.Window
+	return map[string]interface{}{
+		"score":          health.Score,
+		"throttled":      health.Throttled,
+		"newRatio":       window.ratio(window.New),
+		"invalidRatio":   window.ratio(window.Invalid),
+		"duplicateRatio": window.ratio(window.Duplicate),
+		"fulfilmentRate": window.fulfilmentRate(),
+	}
+}
+
+/*
+Updates the scores of all neighbors. Neighbors below the throttle score get part of their messages dropped,
+neighbors below the drop score are removed and temporarily banned.
+*/
+func checkNeighborsHealth() {
+	minSamples := config.GetInt("node.health.minSamples")
+	throttleScore := config.GetFloat64("node.health.throttleScore")
+	dropScore := config.GetFloat64("node.health.dropScore")
+
+	var dropped []*Neighbor
+	NeighborsLock.Lock()
+	for _, neighbor := range Neighbors {
+		health := &neighbor.Health
+		health.update()
+		hasSamples := health.Window.Incoming >= minSamples
+		throttled := hasSamples && health.Score < throttleScore
+		if throttled != health.Throttled {
+			if throttled {
+				logs.Log.Warningf("Throttling neighbor '%v' (score %.2f)", neighbor.Addr, health.Score)
+			} else {
+				logs.Log.Infof("Neighbor '%v' is not throttled anymore (score %.2f)", neighbor.Addr, health.Score)
+			}
+		}
+		health.Throttled = throttled
+		if hasSamples && health.Score < dropScore {
+			dropped = append(dropped, neighbor)
+		}
+	}
+	NeighborsLock.Unlock()
+
+	for _, neighbor := range dropped {
+		window := neighbor.Health.Window
+		reason := fmt.Sprintf("Score %.2f (new: %.0f%%, invalid: %.0f%%, duplicate: %.0f%%, fulfilled requests: %.0f%%)",
+			neighbor.Health.Score, 100*window.ratio(window.New), 100*window.ratio(window.Invalid),
+			100*window.ratio(window.Duplicate), 100*window.fulfilmentRate())
+		evictNeighbor(neighbor, reason)
+	}
+}
+
+/*
+Removes a neighbor from the running node (but not from the saved neighbors) and bans it.
+*/
+func evictNeighbor(neighbor *Neighbor, reason string) {
+	address := GetFormattedAddress(neighbor.IP, neighbor.Port)
+	banDuration := time.Duration(config.GetInt("node.health.banDuration")) * time.Minute
+
+	bansLock.Lock()
+	bans[address] = &Ban{Address: address, Until: time.Now().Add(banDuration), Reason: reason}
+	bansLock.Unlock()
+
+	NeighborsLock.Lock()
+	delete(Neighbors, neighbor.Addr)
+	NeighborsLock.Unlock()
+	if neighbor.tcp != nil {
+		neighbor.tcp.close()
+	}
+	logs.Log.Warningf("Dropped neighbor '%v' and banned it for %v. %v", neighbor.Addr, banDuration, reason)
+}
+
+func isBanned(ipAddressWithPort string) bool {
+	bansLock.RLock()
+	ban, ok := bans[ipAddressWithPort]
+	bansLock.RUnlock()
+	if !ok {
+		return false
+	}
+	if time.Now().After(ban.Until) {
+		bansLock.Lock()
+		delete(bans, ipAddressWithPort)
+		bansLock.Unlock()
+		return false
+	}
+	return true
+}
+
+/*
+Returns the currently active bans.
+*/
+func GetBans() []Ban {
+	bansLock.RLock()
+	defer bansLock.RUnlock()
+
+	var result []Ban
+	for _, ban := range bans {
+		if time.Now().Before(ban.Until) {
+			result = append(result, *ban)
+		}
+	}
+	return result
+}
+
+/*
+Queues an incoming message, unless its sender is unknown or banned.
+Part of the messages of throttled neighbors are dropped.
+*/
+func receiveMessage(ipAddressWithPort string, msg []byte) {
+	if isBanned(ipAddressWithPort) {
+		return
+	}
+	NeighborsLock.RLock()
+	neighborExists, neighbor := checkNeighbourExistsByIPAddress(ipAddressWithPort)
+	throttled := neighborExists && neighbor.Health.Throttled
+	NeighborsLock.RUnlock()
+	if !neighborExists {
+		//logs.Log.Warning("Received from an unknown neighbor", address)
+		return
+	}
+	if throttled && utils.Random(0, 100) < throttledDropRate {
+		return
+	}
+	mq.enqueue(&Message{IPAddressWithPort: ipAddressWithPort, Msg: msg})
+}
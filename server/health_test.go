@@ -0,0 +1,62 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHealthScore(t *testing.T) {
+	healthy := HealthCounts{Incoming: 100, New: 20, Duplicate: 80, Requested: 10, Fulfilled: 10}
+	if healthy.score() != 1 {
+		t.Errorf("Healthy neighbor has score %v", healthy.score())
+	}
+
+	invalid := HealthCounts{Incoming: 100, Invalid: 100}
+	if invalid.score() != 0 {
+		t.Errorf("Invalid neighbor has score %v", invalid.score())
+	}
+
+	useless := HealthCounts{Incoming: 100, Duplicate: 100, Requested: 10}
+	if useless.score() != 0.5 {
+		t.Errorf("Useless neighbor has score %v", useless.score())
+	}
+
+	if (HealthCounts{}).score() != 1 {
+		t.Error("Neighbor without messages should have full score")
+	}
+}
+
+func TestHealthWindow(t *testing.T) {
+	health := &NeighborHealth{}
+	health.track(&NeighborTrackingMessage{Incoming: 10, Invalid: 10})
+	health.update()
+	if health.Window.Invalid != 10 || health.Score != 0 {
+		t.Errorf("Wrong window after first update: %+v", health.Window)
+	}
+
+	for i := 0; i < healthBuckets-1; i++ {
+		health.track(&NeighborTrackingMessage{Incoming: 1, New: 1})
+		health.update()
+	}
+	if health.Window.Invalid != 10 || health.Window.New != healthBuckets-1 {
+		t.Errorf("Wrong window before sliding: %+v", health.Window)
+	}
+
+	health.update()
+	if health.Window.Invalid != 0 || health.Window.Incoming != healthBuckets-1 {
+		t.Errorf("Old bucket did not slide out of the window: %+v", health.Window)
+	}
+}
+
+func TestIsBanned(t *testing.T) {
+	bans["1.2.3.4:14600"] = &Ban{Address: "1.2.3.4:14600", Until: time.Now().Add(time.Minute)}
+	bans["1.2.3.5:14600"] = &Ban{Address: "1.2.3.5:14600", Until: time.Now().Add(-time.Minute)}
+
+	if !isBanned("1.2.3.4:14600") {
+		t.Error("Active ban not honoured")
+	}
+	if isBanned("1.2.3.5:14600") || len(GetBans()) != 1 {
+		t.Error("Expired ban still active")
+	}
+	delete(bans, "1.2.3.4:14600")
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"sync/atomic"
+
+	"../metrics"
+)
+
+func registerMetrics() {
+	metrics.AddCounter("hercules_server_messages_total", "Messages received from all neighbors",
+		func() float64 {
+			return float64(atomic.LoadUint64(&total) + atomic.LoadUint64(&ops))
+		})
+	metrics.AddGaugeVec("hercules_server_queue_length", "Messages waiting in the server queues",
+		func() []metrics.Sample {
+			return []metrics.Sample{
+				{Labels: map[string]string{"queue": "receive"}, Value: float64(len(mq))},
+				{Labels: map[string]string{"queue": "incoming"}, Value: float64(len(server.Incoming))},
+				{Labels: map[string]string{"queue": "outgoing"}, Value: float64(len(server.Outgoing))},
+				{Labels: map[string]string{"queue": "tracking"}, Value: float64(len(NeighborTrackingQueue))},
+			}
+		})
+	metrics.AddGauge("hercules_neighbors", "Number of neighbors", func() float64 {
+		NeighborsLock.RLock()
+		defer NeighborsLock.RUnlock()
+		return float64(len(Neighbors))
+	})
+	metrics.AddGauge("hercules_neighbors_banned", "Number of currently banned neighbors", func() float64 {
+		return float64(len(GetBans()))
+	})
+	metrics.AddCounterVec("hercules_neighbor_transactions_total", "Transactions received per neighbor",
+		func() []metrics.Sample {
+			NeighborsLock.RLock()
+			defer NeighborsLock.RUnlock()
+
+			var samples []metrics.Sample
+			for _, neighbor := range Neighbors {
+				counts := map[string]int{"all": neighbor.Incoming, "new": neighbor.New, "invalid": neighbor.Invalid}
+				for kind, count := range counts {
+					samples = append(samples, metrics.Sample{
+						Labels: map[string]string{"neighbor": neighbor.Addr, "connection": neighbor.ConnectionType, "type": kind},
+						Value:  float64(count),
+					})
+				}
+			}
+			return samples
+		})
+	metrics.AddGaugeVec("hercules_neighbor_health_score", "Health score of each neighbor (0-1)",
+		func() []metrics.Sample {
+			NeighborsLock.RLock()
+			defer NeighborsLock.RUnlock()
+
+			var samples []metrics.Sample
+			for _, neighbor := range Neighbors {
+				samples = append(samples, metrics.Sample{
+					Labels: map[string]string{"neighbor": neighbor.Addr},
+					Value:  neighbor.Health.Score,
+				})
+			}
+			return samples
+		})
+}
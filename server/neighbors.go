@@ -8,25 +8,53 @@ import (
 	"../logs"
 )
 
-func AddNeighbor(address string) error {
+/*
+Adds a neighbor. Permanent neighbors are saved in the database and loaded again on restart.
+Adding an existing temporary neighbor as permanent makes it permanent.
+*/
+func AddNeighbor(address string, permanent bool) error {
 	neighbor, err := createNeighbor(address)
 
 	if err != nil {
 		return err
 	}
 
+	if isBanned(GetFormattedAddress(neighbor.IP, neighbor.Port)) {
+		return errors.New("Neighbor is temporarily banned")
+	}
+
 	NeighborsLock.Lock()
 	defer NeighborsLock.Unlock()
 
-	neighborExists, _ := checkNeighbourExists(neighbor)
+	neighborExists, existingNeighbor := checkNeighbourExists(neighbor)
 	if neighborExists {
+		if permanent && !existingNeighbor.Permanent {
+			err = saveNeighbor(existingNeighbor)
+			if err != nil {
+				return err
+			}
+			existingNeighbor.Permanent = true
+			return nil
+		}
 		return errors.New("Neighbor already exists")
 	}
 
+	if permanent {
+		err = saveNeighbor(neighbor)
+		if err != nil {
+			return err
+		}
+		neighbor.Permanent = true
+	}
+
 	Neighbors[neighbor.Addr] = neighbor
 
 	logAddNeighbor(neighbor)
 
+	if neighbor.ConnectionType == TCP {
+		go neighbor.tcp.run(neighbor)
+	}
+
 	return nil
 }
 
@@ -46,7 +74,16 @@ func RemoveNeighbor(address string) error {
 
 	neighborExists, neighbor := checkNeighbourExistsByAddress(address)
 	if neighborExists {
+		if neighbor.Permanent {
+			err := removeSavedNeighbor(neighbor)
+			if err != nil {
+				return err
+			}
+		}
 		delete(Neighbors, neighbor.Addr)
+		if neighbor.tcp != nil {
+			neighbor.tcp.close()
+		}
 		return nil
 	}
 
@@ -62,6 +99,7 @@ func TrackNeighbor(msg *NeighborTrackingMessage) {
 		neighbor.Incoming += msg.Incoming
 		neighbor.New += msg.New
 		neighbor.Invalid += msg.Invalid
+		neighbor.Health.track(msg)
 	}
 }
 
@@ -108,7 +146,7 @@ func createNeighbor(address string) (*Neighbor, error) {
 		return nil, err
 	}
 
-	if connectionType != UDP {
+	if connectionType != UDP && connectionType != TCP {
 		return nil, errors.New("This protocol is not supported yet")
 	}
 
@@ -138,6 +176,8 @@ func createNeighbor(address string) (*Neighbor, error) {
 		if err != nil {
 			return nil, err
 		}
+	} else {
+		neighbor.tcp = newTCPConnection()
 	}
 
 	return &neighbor, nil
@@ -14,7 +14,8 @@ var expectedConnectionType = "udp"
 var expectedIdentifier = "77.55.235.204"
 var expectedHostname = "field.carriota.com"
 var expectedPort = "443"
-var invalidConnectionType = "tcp"
+var expectedTCPConnectionType = "tcp"
+var invalidConnectionType = "http"
 
 var addresses = []string{
 	expectedIdentifier + ":" + expectedPort,
@@ -23,6 +24,8 @@ var addresses = []string{
 	expectedConnectionType + "://" + expectedIdentifier,
 	expectedConnectionType + "://" + expectedIdentifier + ":" + expectedPort,
 
+	expectedTCPConnectionType + "://" + expectedIdentifier + ":" + expectedPort,
+
 	invalidConnectionType + "://" + expectedIdentifier + ":" + expectedPort,
 }
 
@@ -33,7 +36,8 @@ func TestGetConnectionTypeAndIdentifierAndPort(t *testing.T) {
 		logs.Log.Info("Running test with neighbor's address: " + address)
 		connectionType, identifier, port, err := getConnectionTypeAndIdentifierAndPort(address)
 
-		if connectionType != expectedConnectionType && connectionType != invalidConnectionType || err != nil || identifier != expectedIdentifier {
+		if connectionType != expectedConnectionType && connectionType != expectedTCPConnectionType &&
+			connectionType != invalidConnectionType || err != nil || identifier != expectedIdentifier {
 			t.Error("Not all URI parameters have been detected!")
 		} else {
 
@@ -58,7 +62,7 @@ func TestAddNeighbor(t *testing.T) {
 
 	for _, address := range addresses {
 		logs.Log.Info("Running test with neighbor's address: " + address)
-		err := AddNeighbor(address)
+		err := AddNeighbor(address, false)
 
 		if strings.HasPrefix(address, invalidConnectionType) {
 			if err == nil {
@@ -112,7 +116,7 @@ func TestCheckNeighbourExistsByIPAddress(t *testing.T) {
 	for _, address := range testAddresses {
 		logs.Log.Info("Running test with neighbor's address: " + address)
 
-		err := AddNeighbor(address)
+		err := AddNeighbor(address, false)
 		if err != nil {
 			t.Error("Error during test set up")
 		}
@@ -159,7 +163,7 @@ func TestRemoveNeighbor(t *testing.T) {
 
 	for _, address := range addresses {
 		logs.Log.Info("Running test with neighbor's address: " + address)
-		err := AddNeighbor(address)
+		err := AddNeighbor(address, false)
 
 		if !strings.HasPrefix(address, invalidConnectionType) && err != nil {
 			t.Error("Error during test set up")
@@ -30,6 +30,9 @@ type Neighbor struct {
 	New            int
 	Invalid        int
 	ConnectionType string // Formatted like: udp
+	Permanent      bool   // Saved in the database and loaded again on restart
+	Health         NeighborHealth
+	tcp            *tcpConnection
 }
 
 type Message struct {
@@ -42,6 +45,9 @@ type NeighborTrackingMessage struct {
 	Incoming          int
 	New               int
 	Invalid           int
+	Duplicate         int
+	Requested         int
+	Fulfilled         int
 }
 
 type messageQueue chan *Message
@@ -80,11 +86,13 @@ func Create(serverConfig *viper.Viper) *Server {
 	Neighbors = make(map[string]*Neighbor)
 	logs.Log.Debug("Initial neighbors", config.GetStringSlice("node.neighbors"))
 	for _, address := range config.GetStringSlice("node.neighbors") {
-		err := AddNeighbor(address)
+		err := AddNeighbor(address, false)
 		if err != nil {
 			logs.Log.Warningf("Could not add neighbor '%v' (%v)", address, err)
 		}
 	}
+	loadSavedNeighbors()
+	registerMetrics()
 
 	c, err := net.ListenPacket("udp", ":"+config.GetString("node.port"))
 	if err != nil {
@@ -100,6 +108,7 @@ func Start() {
 		workers = nbWorkers
 	}
 	server.listenAndReceive(workers)
+	listenTCP()
 
 	flushTicker = time.NewTicker(flushInterval)
 	go func() {
@@ -107,10 +116,10 @@ func Start() {
 			if ended {
 				break
 			}
-			report()
-			atomic.AddUint64(&total, ops)
-			atomic.StoreUint64(&Speed, ops+1)
-			atomic.StoreUint64(&ops, 0)
+			current := atomic.SwapUint64(&ops, 0)
+			report(current)
+			atomic.AddUint64(&total, current)
+			atomic.StoreUint64(&Speed, current+1)
 		}
 	}()
 
@@ -125,6 +134,7 @@ func Start() {
 	}()
 
 	go listenNeighborTracker()
+	go startHealthChecker()
 	go func() {
 		for msg := range server.Outgoing {
 			if ended {
@@ -148,11 +158,21 @@ func End() {
 	ended = true
 	time.Sleep(time.Duration(5) * time.Second)
 	connection.Close()
-	atomic.AddUint64(&total, ops)
-	logs.Log.Debugf("Total iTXs %d\n", total)
+	if tcpListener != nil {
+		tcpListener.Close()
+	}
+	if healthTicker != nil {
+		healthTicker.Stop()
+	}
+	atomic.AddUint64(&total, atomic.SwapUint64(&ops, 0))
+	logs.Log.Debugf("Total iTXs %d\n", atomic.LoadUint64(&total))
 }
 
 func (neighbor Neighbor) Write(msg *Message) {
+	if neighbor.ConnectionType == TCP {
+		neighbor.tcp.write(msg.Msg)
+		return
+	}
 	_, err := connection.WriteTo(msg.Msg[0:], neighbor.UDPAddr)
 	if err != nil {
 		logs.Log.Errorf("Error sending message to neighbor '%v': %v", neighbor.Addr, err)
@@ -198,14 +218,7 @@ func (server Server) receive() {
 			continue
 		}
 		ipAddressWithPort := addr.String() // Format <ip>:<port>
-		NeighborsLock.RLock()
-		neighborExists, _ := checkNeighbourExistsByIPAddress(ipAddressWithPort)
-		NeighborsLock.RUnlock()
-		if neighborExists {
-			mq.enqueue(&Message{IPAddressWithPort: ipAddressWithPort, Msg: msg})
-		} else {
-			//logs.Log.Warning("Received from an unknown neighbor", address)
-		}
+		receiveMessage(ipAddressWithPort, msg)
 		time.Sleep(1)
 	}
 }
@@ -216,6 +229,6 @@ func handleMessage(msg *Message) {
 	atomic.AddUint64(&ops, 1)
 }
 
-func report() {
-	logs.Log.Debugf("Incoming TX/s: %.2f\n", float64(ops))
+func report(current uint64) {
+	logs.Log.Debugf("Incoming TX/s: %.2f\n", float64(current))
 }
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"../db"
+	"../logs"
+)
+
+/*
+Loads the permanent neighbors saved in the database. Neighbors already known from the config are marked permanent.
+*/
+func loadSavedNeighbors() {
+	var uris []string
+	_ = db.DB.View(func(txn db.Txn) error {
+		return txn.ForPrefix([]byte{db.KEY_NEIGHBOR}, false, func(key []byte, _ []byte) (bool, error) {
+			uri, err := db.GetString(key, txn)
+			if err == nil {
+				uris = append(uris, uri)
+			}
+			return true, nil
+		})
+	})
+
+	logs.Log.Debug("Saved neighbors", uris)
+	for _, uri := range uris {
+		err := AddNeighbor(uri, true)
+		if err != nil {
+			logs.Log.Warningf("Could not add saved neighbor '%v' (%v)", uri, err)
+		}
+	}
+}
+
+func getNeighborKey(neighbor *Neighbor) []byte {
+	return db.GetByteKey([]byte(neighbor.Addr), db.KEY_NEIGHBOR)
+}
+
+func getNeighborURI(neighbor *Neighbor) string {
+	return neighbor.ConnectionType + "://" + neighbor.Addr
+}
+
+func saveNeighbor(neighbor *Neighbor) error {
+	return db.Put(getNeighborKey(neighbor), getNeighborURI(neighbor), nil, nil)
+}
+
+func removeSavedNeighbor(neighbor *Neighbor) error {
+	return db.Remove(getNeighborKey(neighbor), nil)
+}
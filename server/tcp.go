@@ -0,0 +1,311 @@
+package server
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"../logs"
+)
+
+const (
+	TCPPacketSize       = UDPPacketSize
+	tcpPortHeaderSize   = 10 // Listening port of the sender, zero-padded. Same as IRI.
+	tcpCRC32Size        = 16 // CRC32 of the packet as zero-padded hex string, appended to each packet. Same as IRI.
+	tcpFrameSize        = TCPPacketSize + tcpCRC32Size
+	tcpHandshakeTimeout = time.Duration(10) * time.Second
+	tcpMinBackoff       = time.Duration(1) * time.Second
+	tcpMaxBackoff       = time.Duration(60) * time.Second
+	tcpQueueSize        = 10000
+)
+
+/*
+Connections to a TCP neighbor. Messages are sent through the outgoing connection, or through a connection
+the neighbor opened to our listener while there is none (e.g. the neighbor cannot be dialed).
+Incoming messages are read from all of them.
+*/
+type tcpConnection struct {
+	outgoing chan []byte
+	stop     chan struct{}
+	conn     net.Conn
+	inbound  map[net.Conn]bool
+	lock     sync.Mutex
+	stopped  bool
+}
+
+var tcpListener net.Listener
+
+func newTCPConnection() *tcpConnection {
+	return &tcpConnection{
+		outgoing: make(chan []byte, tcpQueueSize),
+		stop:     make(chan struct{}),
+		inbound:  make(map[net.Conn]bool),
+	}
+}
+
+/*
+Starts listening for incoming TCP connections. Only known TCP neighbors are accepted.
+*/
+func listenTCP() {
+	port := config.GetInt("node.tcpPort")
+	if port <= 0 {
+		logs.Log.Info("TCP listener disabled")
+		return
+	}
+	l, err := net.Listen(TCP, ":"+strconv.Itoa(port))
+	if err != nil {
+		logs.Log.Fatalf("Could not start the TCP listener on port %v: %v", port, err)
+	}
+	tcpListener = l
+	logs.Log.Infof("Listening for TCP neighbors on port %v", port)
+
+	go func() {
+		for !ended {
+			conn, err := tcpListener.Accept()
+			if err != nil {
+				if !ended {
+					logs.Log.Errorf("Error accepting TCP connection: %v", err)
+				}
+				continue
+			}
+			go handleInboundTCP(conn)
+		}
+	}()
+}
+
+func handleInboundTCP(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(tcpHandshakeTimeout))
+	port, err := readPortHeader(conn)
+	if err != nil {
+		logs.Log.Debugf("Invalid TCP handshake from '%v': %v", conn.RemoteAddr(), err)
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	remoteIP := conn.RemoteAddr().(*net.TCPAddr).IP.String()
+	ipAddressWithPort := net.JoinHostPort(remoteIP, port)
+	NeighborsLock.RLock()
+	neighborExists, neighbor := checkNeighbourExistsByIPAddress(ipAddressWithPort)
+	NeighborsLock.RUnlock()
+	if !neighborExists || neighbor.ConnectionType != TCP {
+		logs.Log.Debugf("Rejected TCP connection from unknown neighbor '%v'", ipAddressWithPort)
+		return
+	}
+
+	tcp := neighbor.tcp
+	if !tcp.addInbound(conn) {
+		return
+	}
+	defer tcp.removeInbound(conn)
+
+	logs.Log.Debugf("Incoming TCP connection from neighbor '%v'", neighbor.Addr)
+	disconnected := make(chan struct{})
+	go func() {
+		receiveTCP(conn, ipAddressWithPort, tcp.stop)
+		close(disconnected)
+	}()
+	tcp.send(conn, disconnected, true)
+	logs.Log.Debugf("Incoming TCP connection from neighbor '%v' closed", neighbor.Addr)
+}
+
+/*
+Reads the framed packets from a TCP connection and queues them as incoming messages.
+Packets with a wrong CRC32 are dropped.
+*/
+func receiveTCP(conn net.Conn, ipAddressWithPort string, stop chan struct{}) {
+	for !ended {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		frame := make([]byte, tcpFrameSize)
+		_, err := io.ReadFull(conn, frame)
+		if err != nil {
+			return
+		}
+		msg, ok := decodeTCPFrame(frame)
+		if !ok {
+			logs.Log.Debugf("Dropping TCP packet with wrong CRC32 from '%v'", ipAddressWithPort)
+			continue
+		}
+		receiveMessage(ipAddressWithPort, msg)
+	}
+}
+
+/*
+Returns the packet with its CRC32 appended as IRI does: 16 hex digits, zero-padded.
+*/
+func encodeTCPFrame(packet []byte) []byte {
+	frame := make([]byte, tcpFrameSize)
+	copy(frame, packet)
+	copy(frame[TCPPacketSize:], fmt.Sprintf("%0*x", tcpCRC32Size, crc32.ChecksumIEEE(frame[:TCPPacketSize])))
+	return frame
+}
+
+func decodeTCPFrame(frame []byte) ([]byte, bool) {
+	packet := frame[:TCPPacketSize]
+	expected := fmt.Sprintf("%0*x", tcpCRC32Size, crc32.ChecksumIEEE(packet))
+	return packet, strings.EqualFold(expected, string(frame[TCPPacketSize:]))
+}
+
+func readPortHeader(conn net.Conn) (string, error) {
+	header := make([]byte, tcpPortHeaderSize)
+	_, err := io.ReadFull(conn, header)
+	if err != nil {
+		return "", err
+	}
+	port, err := strconv.Atoi(strings.TrimLeft(string(header), "0"))
+	if err != nil || port <= 0 || port > 65535 {
+		return "", fmt.Errorf("invalid port header '%v'", string(header))
+	}
+	return strconv.Itoa(port), nil
+}
+
+func writePortHeader(conn net.Conn) error {
+	header := fmt.Sprintf("%0*d", tcpPortHeaderSize, config.GetInt("node.tcpPort"))
+	_, err := conn.Write([]byte(header))
+	return err
+}
+
+/*
+Keeps the outgoing connection to the neighbor open, reconnecting with an exponential backoff.
+*/
+func (tcp *tcpConnection) run(neighbor *Neighbor) {
+	backoff := tcpMinBackoff
+	for !ended && !tcp.isStopped() {
+		NeighborsLock.RLock()
+		address := GetFormattedAddress(neighbor.IP, neighbor.Port)
+		NeighborsLock.RUnlock()
+
+		conn, err := net.DialTimeout(TCP, address, tcpHandshakeTimeout)
+		if err == nil {
+			err = writePortHeader(conn)
+		}
+		if err != nil {
+			if conn != nil {
+				conn.Close()
+			}
+			logs.Log.Debugf("Could not connect to TCP neighbor '%v' (%v). Retrying in %v", neighbor.Addr, err, backoff)
+			select {
+			case <-tcp.stop:
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > tcpMaxBackoff {
+				backoff = tcpMaxBackoff
+			}
+			continue
+		}
+
+		logs.Log.Infof("Connected to TCP neighbor '%v'", neighbor.Addr)
+		backoff = tcpMinBackoff
+		tcp.setConn(conn)
+		disconnected := make(chan struct{})
+		go func() {
+			receiveTCP(conn, address, tcp.stop)
+			close(disconnected)
+		}()
+		tcp.send(conn, disconnected, false)
+		tcp.setConn(nil)
+		conn.Close()
+		logs.Log.Infof("Disconnected from TCP neighbor '%v'", neighbor.Addr)
+	}
+}
+
+/*
+Writes the queued messages to the connection until it fails or the neighbor is removed.
+An inbound connection only sends while there is no outgoing connection.
+*/
+func (tcp *tcpConnection) send(conn net.Conn, disconnected chan struct{}, inbound bool) {
+	for !ended {
+		outgoing := tcp.outgoing
+		if inbound && tcp.isConnected() {
+			// Not reading from a nil channel, rechecking after a while
+			outgoing = nil
+		}
+		select {
+		case <-tcp.stop:
+			return
+		case <-disconnected:
+			return
+		case <-time.After(tcpMinBackoff):
+		case msg := <-outgoing:
+			_, err := conn.Write(msg)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+/*
+Queues a message for the neighbor. Messages are dropped while the queue is full.
+*/
+func (tcp *tcpConnection) write(msg []byte) {
+	select {
+	case tcp.outgoing <- encodeTCPFrame(msg):
+	default:
+	}
+}
+
+func (tcp *tcpConnection) setConn(conn net.Conn) {
+	tcp.lock.Lock()
+	defer tcp.lock.Unlock()
+	tcp.conn = conn
+}
+
+func (tcp *tcpConnection) isConnected() bool {
+	tcp.lock.Lock()
+	defer tcp.lock.Unlock()
+	return tcp.conn != nil
+}
+
+/*
+Registers a connection accepted from the neighbor, so that it is closed when the neighbor is removed.
+*/
+func (tcp *tcpConnection) addInbound(conn net.Conn) bool {
+	tcp.lock.Lock()
+	defer tcp.lock.Unlock()
+	if tcp.stopped {
+		return false
+	}
+	tcp.inbound[conn] = true
+	return true
+}
+
+func (tcp *tcpConnection) removeInbound(conn net.Conn) {
+	tcp.lock.Lock()
+	defer tcp.lock.Unlock()
+	delete(tcp.inbound, conn)
+}
+
+func (tcp *tcpConnection) isStopped() bool {
+	tcp.lock.Lock()
+	defer tcp.lock.Unlock()
+	return tcp.stopped
+}
+
+func (tcp *tcpConnection) close() {
+	tcp.lock.Lock()
+	defer tcp.lock.Unlock()
+	if tcp.stopped {
+		return
+	}
+	tcp.stopped = true
+	close(tcp.stop)
+	if tcp.conn != nil {
+		tcp.conn.Close()
+	}
+	for conn := range tcp.inbound {
+		conn.Close()
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"testing"
+)
+
+func TestTCPFrame(t *testing.T) {
+	packet := bytes.Repeat([]byte{7}, 100)
+	frame := encodeTCPFrame(packet)
+	if len(frame) != TCPPacketSize+tcpCRC32Size {
+		t.Fatalf("Frame has size %v", len(frame))
+	}
+
+	padded := make([]byte, TCPPacketSize)
+	copy(padded, packet)
+	expected := fmt.Sprintf("%016x", crc32.ChecksumIEEE(padded))
+	if string(frame[TCPPacketSize:]) != expected {
+		t.Errorf("Frame has CRC32 %v, expected %v", string(frame[TCPPacketSize:]), expected)
+	}
+
+	decoded, ok := decodeTCPFrame(frame)
+	if !ok || !bytes.Equal(decoded, padded) {
+		t.Error("Frame could not be decoded")
+	}
+
+	frame[0] = 8
+	if _, ok := decodeTCPFrame(frame); ok {
+		t.Error("Tampered frame was accepted")
+	}
+}
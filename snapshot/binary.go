@@ -0,0 +1,447 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"../db"
+	"../logs"
+)
+
+/*
+Binary snapshot format (v2):
+
+	header:  "HERCSNAP", version (uint16), timestamp (int64), compression (byte, 1 = gzip)
+	content: gzip stream of
+	         section table: number of sections (uvarint), then per section its id (byte) and entry count (uvarint)
+	         the sections, in the order of the table, each entry being length-prefixed (uvarint) bytes,
+	         followed by the value (int64) for the balances
+	         SHA-256 over the header and the content before it
+
+The milestone section comes first and belongs to the header: it is empty unless the snapshot was
+made at a milestone index. Milestone snapshots have no pending bundles, ignored or pins sections. Delta snapshots start with a base section referencing the previous
+snapshot of the chain and only contain the changed balances (zero for removed ones) and the newly
+spent addresses.
+
+All integers are big-endian.
+*/
+const (
+	binaryMagic     = "HERCSNAP"
+	binaryVersion   = 2
+	compressionGzip = byte(1)
+
+	maxBinaryEntrySize = 4096
+)
+
+const (
+	sectionBalances  = byte(1) // address, value
+	sectionSpent     = byte(2) // address
+	sectionBundles   = byte(3) // pending bundle key
+	sectionIgnored   = byte(4) // snapshotted transaction key
+	sectionPins      = byte(5) // pin line
+	sectionBase      = byte(6) // base snapshot timestamp (int64) and SHA-256 (delta snapshots only)
+	sectionMilestone = byte(7) // milestone index (int64) and hash (milestone snapshots only)
+)
+
+type snapshotSection struct {
+	id     byte
+	prefix byte
+}
+
+var binarySections = []snapshotSection{
+	{sectionMilestone, 0},
+	{sectionBalances, db.KEY_SNAPSHOT_BALANCE},
+	{sectionSpent, db.KEY_SNAPSHOT_SPENT},
+	{sectionBundles, db.KEY_PENDING_BUNDLE},
+	{sectionIgnored, db.KEY_SNAPSHOTTED},
+	{sectionPins, 0},
+}
+
+/*
+Milestone snapshots leave out the pending bundles, snapshotted transactions and pins, which
+depend on the node, so that every node writes the same file for the same milestone.
+*/
+var milestoneSections = []snapshotSection{
+	{sectionMilestone, 0},
+	{sectionBalances, db.KEY_SNAPSHOT_BALANCE},
+	{sectionSpent, db.KEY_SNAPSHOT_SPENT},
+}
+
+/*
+Calls fn for the entries of the section: the address, key or pin and, for the balances, the value.
+Zero balances are skipped, like in the text format.
+*/
+func forSectionEntries(section snapshotSection, fn func(entry []byte, value int64) error) error {
+	if section.id == sectionMilestone {
+		if milestone := GetSnapshotMilestone(nil); milestone != nil {
+			return fn(milestone.bytes(), 0)
+		}
+		return nil
+	}
+	if section.id == sectionPins {
+		for _, pin := range GetPins() {
+			err := fn([]byte(pin.String()), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return db.DB.View(func(txn db.Txn) error {
+		return txn.ForPrefix([]byte{section.prefix}, section.id == sectionBalances, func(key []byte, v []byte) (bool, error) {
+			var value int64 = 0
+			entry := key
+			switch section.id {
+			case sectionBalances:
+				err := gob.NewDecoder(bytes.NewBuffer(v)).Decode(&value)
+				if err != nil {
+					logs.Log.Error("Could not parse a snapshot value from database!", err)
+					return false, err
+				}
+				if value == 0 {
+					return true, nil
+				}
+				entry = key[1:]
+			case sectionSpent:
+				entry = key[1:]
+			}
+			return true, fn(entry, value)
+		})
+	})
+}
+
+/*
+Writes the current database snapshot in the binary format, as delta of the given previous state if not nil.
+*/
+func writeBinarySnapshot(file io.Writer, timestamp int, delta *snapshotDelta) error {
+	checksum := sha256.New()
+	header := new(bytes.Buffer)
+	header.WriteString(binaryMagic)
+	binary.Write(header, binary.BigEndian, uint16(binaryVersion))
+	binary.Write(header, binary.BigEndian, int64(timestamp))
+	header.WriteByte(compressionGzip)
+
+	bw := bufio.NewWriter(file)
+	_, err := bw.Write(header.Bytes())
+	if err != nil {
+		return err
+	}
+	checksum.Write(header.Bytes())
+
+	gz := gzip.NewWriter(bw)
+	w := io.MultiWriter(gz, checksum)
+
+	sections := binarySections
+	if GetSnapshotMilestone(nil) != nil {
+		sections = milestoneSections
+	}
+	forEntries := forSectionEntries
+	if delta != nil {
+		sections = append([]snapshotSection{{sectionBase, 0}}, sections...)
+		forEntries = delta.forSectionEntries
+	}
+
+	// Each section is read once and buffered, so that its count matches its entries
+	counts := make([]uint64, len(sections))
+	buffers := make([]*bytes.Buffer, len(sections))
+	for i, section := range sections {
+		buffers[i] = new(bytes.Buffer)
+		err := forEntries(section, func(entry []byte, value int64) error {
+			counts[i]++
+			writeUvarint(buffers[i], uint64(len(entry)))
+			buffers[i].Write(entry)
+			if section.id == sectionBalances {
+				return binary.Write(buffers[i], binary.BigEndian, value)
+			}
+			return nil
+		})
+		if err != nil {
+			logs.Log.Errorf("Could not read snapshot section %v: %v", section.id, err)
+			return err
+		}
+	}
+
+	// Section table
+	writeUvarint(w, uint64(len(sections)))
+	for i, section := range sections {
+		w.Write([]byte{section.id})
+		writeUvarint(w, counts[i])
+	}
+
+	for i, section := range sections {
+		_, err := buffers[i].WriteTo(w)
+		if err != nil {
+			logs.Log.Errorf("Could not write snapshot section %v: %v", section.id, err)
+			return err
+		}
+		buffers[i] = nil
+	}
+
+	_, err = gz.Write(checksum.Sum(nil))
+	if err != nil {
+		return err
+	}
+	err = gz.Close()
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func writeUvarint(w io.Writer, value uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	w.Write(buf[:binary.PutUvarint(buf, value)])
+}
+
+/*
+Returns whether the reader starts with a binary snapshot header.
+*/
+func isBinarySnapshot(rd *bufio.Reader) bool {
+	magic, err := rd.Peek(len(binaryMagic))
+	return err == nil && string(magic) == binaryMagic
+}
+
+func readBinaryHeader(rd io.Reader) (*SnapshotHeader, []byte, error) {
+	raw := make([]byte, len(binaryMagic)+2+8+1)
+	_, err := io.ReadFull(rd, raw)
+	if err != nil || string(raw[:len(binaryMagic)]) != binaryMagic {
+		return nil, nil, errors.New("invalid binary snapshot header")
+	}
+	version := binary.BigEndian.Uint16(raw[len(binaryMagic):])
+	timestamp := int64(binary.BigEndian.Uint64(raw[len(binaryMagic)+2:]))
+	if version != binaryVersion {
+		return nil, nil, errors.New("unknown header version found (hercules upgrade necessary?)")
+	}
+	if raw[len(raw)-1] != compressionGzip {
+		return nil, nil, errors.New("unknown snapshot compression")
+	}
+	return &SnapshotHeader{int64(version), timestamp, nil}, raw, nil
+}
+
+/*
+Hashes everything read through it, so that the checksum at the end can be compared with the content.
+*/
+type checksumReader struct {
+	rd       *bufio.Reader
+	checksum hash.Hash
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	r.checksum.Write(p[:n])
+	return n, err
+}
+
+func (r *checksumReader) ReadByte() (byte, error) {
+	b, err := r.rd.ReadByte()
+	if err == nil {
+		r.checksum.Write([]byte{b})
+	}
+	return b, err
+}
+
+/*
+Reads a binary snapshot file, calling fn for every entry with its section, and verifies the checksum.
+Returns an error if the file is truncated, corrupted or fn fails.
+*/
+func readBinarySnapshot(path string, fn func(section byte, entry []byte, value int64) error) (*SnapshotHeader, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fileReader := bufio.NewReader(f)
+	header, raw, err := readBinaryHeader(fileReader)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(fileReader)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	rd := &checksumReader{bufio.NewReader(gz), sha256.New()}
+	rd.checksum.Write(raw)
+
+	sectionCount, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return nil, err
+	}
+	var ids []byte
+	var counts []uint64
+	for i := uint64(0); i < sectionCount; i++ {
+		id, err := rd.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		count, err := binary.ReadUvarint(rd)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+		counts = append(counts, count)
+	}
+
+	for i, id := range ids {
+		for n := uint64(0); n < counts[i]; n++ {
+			size, err := binary.ReadUvarint(rd)
+			if err != nil {
+				return nil, err
+			}
+			if size > maxBinaryEntrySize {
+				return nil, fmt.Errorf("snapshot entry too big in section %v", id)
+			}
+			entry := make([]byte, size)
+			_, err = io.ReadFull(rd, entry)
+			if err != nil {
+				return nil, err
+			}
+			var value int64 = 0
+			if id == sectionBalances {
+				err = binary.Read(rd, binary.BigEndian, &value)
+				if err != nil {
+					return nil, err
+				}
+			}
+			err = fn(id, entry, value)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	expected := rd.checksum.Sum(nil)
+	actual := make([]byte, len(expected))
+	_, err = io.ReadFull(rd.rd, actual)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(expected, actual) {
+		return nil, errors.New("snapshot checksum mismatch")
+	}
+	// Reaching the end of the stream also verifies the gzip trailer
+	_, err = io.ReadFull(rd.rd, make([]byte, 1))
+	if err != io.EOF {
+		return nil, errors.New("snapshot does not end after its checksum")
+	}
+	return header, nil
+}
+
+var errMilestoneRead = errors.New("snapshot milestone read")
+
+/*
+Returns the milestone of a binary snapshot file, if it was made at a milestone index. Only the
+beginning of the file is read.
+*/
+func readBinaryMilestone(path string) (*SnapshotMilestone, error) {
+	var milestone *SnapshotMilestone
+	_, err := readBinarySnapshot(path, func(section byte, entry []byte, _ int64) error {
+		switch section {
+		case sectionBase:
+			return nil
+		case sectionMilestone:
+			m, err := parseSnapshotMilestone(entry)
+			if err != nil {
+				return err
+			}
+			milestone = m
+		}
+		return errMilestoneRead
+	})
+	if err != nil && err != errMilestoneRead {
+		return nil, err
+	}
+	return milestone, nil
+}
+
+/*
+Verifies the checksum, the total supply and the number of spent addresses of a binary snapshot file.
+*/
+func checkBinarySnapshotFileIntegrity(path string) error {
+	var total int64 = 0
+	var totalSpent int64 = 0
+	_, err := readBinarySnapshot(path, func(section byte, entry []byte, value int64) error {
+		switch section {
+		case sectionBalances:
+			total += value
+		case sectionSpent:
+			totalSpent++
+		case sectionBase:
+			return errors.New("delta snapshot cannot be checked without its base")
+		}
+		return nil
+	})
+	if err != nil {
+		logs.Log.Errorf("Could not read snapshot file: %v", err)
+		return err
+	}
+	return checkSnapshotTotals(total, totalSpent)
+}
+
+/*
+Loads the entries of a binary snapshot file into the database.
+*/
+func doLoadBinarySnapshot(path string) error {
+	var total int64 = 0
+	var totalSpent int64 = 0
+	var txn = db.DB.NewTransaction(true)
+	var load = func(fn func(txn db.Txn) error) error {
+		err := fn(txn)
+		if err == db.ErrTxnTooBig {
+			err = txn.Commit()
+			if err != nil {
+				return err
+			}
+			txn = db.DB.NewTransaction(true)
+			err = fn(txn)
+		}
+		return err
+	}
+
+	_, err := readBinarySnapshot(path, func(section byte, entry []byte, value int64) error {
+		switch section {
+		case sectionBalances:
+			total += value
+			return load(func(txn db.Txn) error { return loadValueSnapshot(entry, value, txn) })
+		case sectionSpent:
+			totalSpent++
+			return load(func(txn db.Txn) error { return loadSpentSnapshot(entry, txn) })
+		case sectionBundles, sectionIgnored:
+			return loadKey(entry)
+		case sectionBase:
+			return errors.New("delta snapshot cannot be loaded without its base")
+		case sectionPins:
+			pin, err := parsePin(string(entry))
+			if err != nil {
+				logs.Log.Warning("Skipping pin from snapshot:", err)
+				return nil
+			}
+			return AddPin(pin)
+		}
+		return nil
+	})
+	if err != nil {
+		txn.Discard()
+		return err
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return err
+	}
+
+	logs.Log.Debugf("Snapshot total value: %v", total)
+	logs.Log.Debugf("Snapshot total spent addresses: %v", totalSpent)
+	return nil
+}
@@ -0,0 +1,174 @@
+package snapshot
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"../convert"
+	"../db"
+	"../network"
+)
+
+func getTestAddress(name string) []byte {
+	return convert.TrytesToBytes(name + strings.Repeat("9", 81-len(name)))[:49]
+}
+
+func resetTestPins() {
+	pins = make(map[string]map[string]bool)
+	pinnedTXKeys = make(map[string]bool)
+	pinnedBundles = make(map[string]bool)
+}
+
+/*
+Empties the database and selects the devnet, whose snapshots need no spent addresses, for loading a snapshot.
+*/
+func resetTestSnapshotLoad(t *testing.T) {
+	db.DB = db.NewMemoryStore()
+	resetTestPins()
+	CurrentTimestamp = 0
+	current := network.Current
+	network.Current = network.Devnet
+	allowUnsigned = true
+	t.Cleanup(func() {
+		network.Current = current
+		allowUnsigned = false
+	})
+}
+
+/*
+Fills a new memory store with snapshot entries of every section.
+*/
+func saveTestSnapshotState(t *testing.T) {
+	db.DB = db.NewMemoryStore()
+	resetTestPins()
+	for name, value := range map[string]int64{"A": 100, "B": 0, "C": 50} {
+		if err := db.Put(db.GetAddressKey(getTestAddress(name), db.KEY_SNAPSHOT_BALANCE), value, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Put(db.GetAddressKey(getTestAddress("A"), db.KEY_SNAPSHOT_SPENT), true, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(db.GetByteKey([]byte("bundle"), db.KEY_PENDING_BUNDLE), true, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(db.GetByteKey([]byte("tx"), db.KEY_SNAPSHOTTED), true, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	pin, err := NewPin(PIN_TAG, "TEST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddPin(pin); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestBinarySnapshot(t *testing.T, dir string, name string, timestamp int, delta *snapshotDelta) string {
+	file, err := os.Create(path.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := writeBinarySnapshot(file, timestamp, delta); err != nil {
+		t.Fatal(err)
+	}
+	return file.Name()
+}
+
+type testSnapshotEntry struct {
+	entry []byte
+	value int64
+}
+
+func readTestBinarySnapshot(t *testing.T, path string) (*SnapshotHeader, map[byte][]testSnapshotEntry) {
+	sections := make(map[byte][]testSnapshotEntry)
+	header, err := readBinarySnapshot(path, func(section byte, entry []byte, value int64) error {
+		sections[section] = append(sections[section], testSnapshotEntry{entry, value})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header, sections
+}
+
+func TestBinarySnapshotRoundTrip(t *testing.T) {
+	saveTestSnapshotState(t)
+	file := writeTestBinarySnapshot(t, t.TempDir(), "1000.snap", 1000, nil)
+
+	header, sections := readTestBinarySnapshot(t, file)
+	if header.timestamp != 1000 {
+		t.Errorf("Header timestamp is %v, expected 1000", header.timestamp)
+	}
+	expected := map[byte][]testSnapshotEntry{
+		sectionBalances: {{getTestAddress("A"), 100}, {getTestAddress("C"), 50}},
+		sectionSpent:    {{getTestAddress("A"), 0}},
+		sectionBundles:  {{db.GetByteKey([]byte("bundle"), db.KEY_PENDING_BUNDLE), 0}},
+		sectionIgnored:  {{db.GetByteKey([]byte("tx"), db.KEY_SNAPSHOTTED), 0}},
+		sectionPins:     {{[]byte((&Pin{PIN_TAG, "TEST" + strings.Repeat("9", 23)}).String()), 0}},
+	}
+	if len(sections) != len(expected) {
+		t.Errorf("Got %v sections, expected %v", len(sections), len(expected))
+	}
+	for id, entries := range expected {
+		if len(sections[id]) != len(entries) {
+			t.Errorf("Section %v has %v entries, expected %v", id, len(sections[id]), len(entries))
+			continue
+		}
+		for _, entry := range entries {
+			found := false
+			for _, e := range sections[id] {
+				found = found || (bytes.Equal(e.entry, entry.entry) && e.value == entry.value)
+			}
+			if !found {
+				t.Errorf("Section %v is missing %v", id, entry)
+			}
+		}
+	}
+
+	milestone, err := readBinaryMilestone(file)
+	if err != nil || milestone != nil {
+		t.Errorf("Read milestone %v (%v) from a snapshot at a timestamp", milestone, err)
+	}
+
+	db.DB = db.NewMemoryStore()
+	resetTestPins()
+	if err := doLoadBinarySnapshot(file); err != nil {
+		t.Fatal(err)
+	}
+	balance, err := db.GetInt64(db.GetAddressKey(getTestAddress("A"), db.KEY_BALANCE), nil)
+	if err != nil || balance != 100 {
+		t.Errorf("Loaded balance is %v (%v), expected 100", balance, err)
+	}
+	if !db.Has(db.GetAddressKey(getTestAddress("A"), db.KEY_SPENT), nil) {
+		t.Error("Spent address was not loaded")
+	}
+	if !db.Has(db.GetByteKey([]byte("bundle"), db.KEY_PENDING_BUNDLE), nil) ||
+		!db.Has(db.GetByteKey([]byte("tx"), db.KEY_SNAPSHOTTED), nil) {
+		t.Error("Keys were not loaded")
+	}
+	if len(GetPins()) != 1 {
+		t.Errorf("Loaded %v pins, expected 1", len(GetPins()))
+	}
+}
+
+func TestBinarySnapshotCorruption(t *testing.T) {
+	saveTestSnapshotState(t)
+	file := writeTestBinarySnapshot(t, t.TempDir(), "1000.snap", 1000, nil)
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, content[:len(content)-10], 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = readBinarySnapshot(file, func(byte, []byte, int64) error { return nil })
+	if err == nil {
+		t.Error("Truncated snapshot was read")
+	}
+}
@@ -12,16 +12,16 @@ import (
 
 	"../logs"
 	"../db"
+	"../network"
 	"../utils"
 
-	"github.com/dgraph-io/badger"
 	"github.com/pkg/errors"
 )
 
 /*
 Returns if the given timestamp is more recent than the current database snapshot.
  */
-func IsNewerThanSnapshot(timestamp int, txn *badger.Txn) bool {
+func IsNewerThanSnapshot(timestamp int, txn db.Txn) bool {
 	current := GetSnapshotTimestamp(txn)
 	return timestamp > current
 }
@@ -29,7 +29,7 @@ func IsNewerThanSnapshot(timestamp int, txn *badger.Txn) bool {
 /*
 Returns if the given timestamp is more recent than the current database snapshot.
  */
-func IsEqualOrNewerThanSnapshot(timestamp int, txn *badger.Txn) bool {
+func IsEqualOrNewerThanSnapshot(timestamp int, txn db.Txn) bool {
 	current := GetSnapshotTimestamp(txn)
 	return timestamp >= current
 }
@@ -50,30 +50,21 @@ This is just an additional measure to prevent tangle inconsistencies.
  */
 func CanSnapshot(timestamp int) bool {
 	pendingConfirmationsBehindHorizon := false
-	err := db.DB.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = true
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := []byte{db.KEY_EVENT_CONFIRMATION_PENDING}
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			v, err := it.Item().Value()
-			if err != nil {
-				return err
-			}
-			var ts = 0
+	err := db.DB.View(func(txn db.Txn) error {
+		return txn.ForPrefix([]byte{db.KEY_EVENT_CONFIRMATION_PENDING}, true, func(_ []byte, v []byte) (bool, error) {
+			var pending []int
 			buf := bytes.NewBuffer(v)
 			dec := gob.NewDecoder(buf)
-			err = dec.Decode(&ts)
+			err := dec.Decode(&pending)
 			if err != nil {
-				return err
+				return false, err
 			}
-			if ts > 0 && ts <= timestamp {
+			if len(pending) > 0 && pending[0] > 0 && pending[0] <= timestamp {
 				pendingConfirmationsBehindHorizon = true
-				break
+				return false, nil
 			}
-		}
-		return nil
+			return true, nil
+		})
 	})
 	return err == nil && !pendingConfirmationsBehindHorizon
 }
@@ -82,38 +73,26 @@ func checkDatabaseSnapshot () bool {
 	logs.Log.Info("Checking database snapshot integrity")
 	var total int64 = 0
 
-	err := db.DB.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = true
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := []byte{db.KEY_SNAPSHOT_BALANCE}
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			v, err := it.Item().Value()
-			if err == nil {
-				var value int64 = 0
-				buf := bytes.NewBuffer(v)
-				dec := gob.NewDecoder(buf)
-				err := dec.Decode(&value)
-				if err == nil {
-					total += value
-				} else {
-					logs.Log.Error("Could not parse a snapshot value from database!")
-					return err
-				}
-			} else {
-				logs.Log.Error("Could not read a snapshot value from database!")
-				return err
+	err := db.DB.View(func(txn db.Txn) error {
+		return txn.ForPrefix([]byte{db.KEY_SNAPSHOT_BALANCE}, true, func(_ []byte, v []byte) (bool, error) {
+			var value int64 = 0
+			buf := bytes.NewBuffer(v)
+			dec := gob.NewDecoder(buf)
+			err := dec.Decode(&value)
+			if err != nil {
+				logs.Log.Error("Could not parse a snapshot value from database!")
+				return false, err
 			}
-		}
-		return nil
+			total += value
+			return true, nil
+		})
 	})
 	if err != nil { return false }
-	if total == TOTAL_IOTAS {
+	if total == network.Current.TotalSupply {
 		logs.Log.Info("Database snapshot integrity check passed")
 		return true
 	} else {
-		logs.Log.Errorf("Database snapshot integrity check failed: %v should be %v", total, TOTAL_IOTAS)
+		logs.Log.Errorf("Database snapshot integrity check failed: %v should be %v", total, network.Current.TotalSupply)
 		logs.Log.Fatal("The database is in an inconsistent state now :(. Dying...")
 		return false
 	}
@@ -141,6 +120,12 @@ func checkSnapshotFile (path string) (timestamp int64, err error) {
 		return 0, errors.New("current snapshot more recent")
 	}
 
+	err = verifySnapshotSignature(path)
+	if err != nil {
+		logs.Log.Errorf("Snapshot signature check failed: %v", err)
+		return 0, err
+	}
+
 	err = checkSnapshotFileIntegrity(path)
 	if err != nil { return 0, err }
 
@@ -148,6 +133,12 @@ func checkSnapshotFile (path string) (timestamp int64, err error) {
 }
 
 func checkSnapshotFileIntegrity (path string) error {
+	header, err := loadHeader(path)
+	if err != nil { return err }
+	if header.version == binaryVersion {
+		return checkBinarySnapshotFileIntegrity(path)
+	}
+
 	f, err := os.OpenFile(path, os.O_RDONLY, os.ModePerm)
 	if err != nil {
 		logs.Log.Fatalf("open file error: %v", err)
@@ -194,13 +185,17 @@ func checkSnapshotFileIntegrity (path string) error {
 		firstLine = false
 	}
 
-	if totalSpent < MIN_SPENT_ADDRESSES {
+	return checkSnapshotTotals(total, totalSpent)
+}
+
+func checkSnapshotTotals (total int64, totalSpent int64) error {
+	if totalSpent < network.Current.MinSpentAddresses {
 		logs.Log.Error("Spent addresses count is wrong!")
 		return errors.New("spent addresses validation failed")
 	}
 
-	if total != TOTAL_IOTAS {
-		logs.Log.Errorf("Address balances validation failed %v vs %v!", TOTAL_IOTAS, total)
+	if total != network.Current.TotalSupply {
+		logs.Log.Errorf("Address balances validation failed %v vs %v!", network.Current.TotalSupply, total)
 		return errors.New("address balance validation failed")
 	}
 
@@ -217,13 +212,24 @@ func checkPendingSnapshot () {
 	timestamp, filename := IsLocked(nil)
 	if timestamp >= 0 {
 		if len(filename) > 0 {
+			paths := strings.Split(filename, ",")
 			newFilename := config.GetString("snapshots.loadFile")
 			if len(newFilename) > 0 {
-				filename = newFilename
+				paths = append([]string{newFilename}, config.GetStringSlice("snapshots.loadDeltas")...)
 			}
-			logs.Log.Info("Found pending snapshot lock. Trying to continue... ", filename)
-			LoadSnapshot(filename)
+			logs.Log.Info("Found pending snapshot lock. Trying to continue... ", strings.Join(paths, ", "))
+			if len(paths) == 1 && IsIRILocalSnapshot(paths[0]) {
+				LoadIRILocalSnapshot(paths[0])
+				return
+			}
+			LoadSnapshotChain(paths)
 		} else {
+			index, err := db.GetInt(keySnapshotMilestoneLock, nil)
+			if err == nil && index > 0 {
+				logs.Log.Info("Found pending milestone snapshot lock. Trying to continue... ", index)
+				MakeMilestoneSnapshot(index, "")
+				return
+			}
 			logs.Log.Info("Found pending snapshot lock. Trying to continue... ", timestamp)
 			MakeSnapshot(timestamp, "")
 		}
@@ -0,0 +1,397 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"../convert"
+	"../db"
+	"../logs"
+)
+
+var snapshotChainKey = db.GetByteKey([]byte("snapshotChain"), db.KEY_OTHER)
+
+/*
+Reference of a delta snapshot to the previous snapshot of its chain.
+*/
+type snapshotBase struct {
+	timestamp int64
+	hash      []byte
+}
+
+func (base *snapshotBase) bytes() []byte {
+	b := make([]byte, 8, 8+len(base.hash))
+	binary.BigEndian.PutUint64(b, uint64(base.timestamp))
+	return append(b, base.hash...)
+}
+
+func parseSnapshotBase(entry []byte) (*snapshotBase, error) {
+	if len(entry) != 8+32 {
+		return nil, errors.New("invalid snapshot base reference")
+	}
+	return &snapshotBase{int64(binary.BigEndian.Uint64(entry)), entry[8:]}, nil
+}
+
+/*
+Content of a snapshot chain, applied file by file. Balances and spent addresses accumulate,
+the other sections are complete in every file.
+*/
+type snapshotState struct {
+	balances map[string]int64
+	spent    map[string]bool
+	bundles  [][]byte
+	ignored  [][]byte
+	pins     []string
+}
+
+func newSnapshotState() *snapshotState {
+	return &snapshotState{balances: make(map[string]int64), spent: make(map[string]bool)}
+}
+
+func (state *snapshotState) apply(section byte, entry []byte, value int64) {
+	switch section {
+	case sectionBalances:
+		if value == 0 {
+			delete(state.balances, string(entry))
+		} else {
+			state.balances[string(entry)] = value
+		}
+	case sectionSpent:
+		state.spent[string(entry)] = true
+	case sectionBundles:
+		state.bundles = append(state.bundles, entry)
+	case sectionIgnored:
+		state.ignored = append(state.ignored, entry)
+	case sectionPins:
+		state.pins = append(state.pins, string(entry))
+	}
+}
+
+/*
+A delta snapshot to write: the current database snapshot compared with the state of the chain.
+*/
+type snapshotDelta struct {
+	base  snapshotBase
+	state *snapshotState
+}
+
+/*
+Like forSectionEntries, but only with the balances that changed since the base (zero if removed)
+and the addresses spent since then.
+*/
+func (delta *snapshotDelta) forSectionEntries(section snapshotSection, fn func(entry []byte, value int64) error) error {
+	switch section.id {
+	case sectionBase:
+		return fn(delta.base.bytes(), 0)
+	case sectionBalances:
+		seen := make(map[string]bool)
+		err := forSectionEntries(section, func(entry []byte, value int64) error {
+			seen[string(entry)] = true
+			if previous, ok := delta.state.balances[string(entry)]; ok && previous == value {
+				return nil
+			}
+			return fn(entry, value)
+		})
+		if err != nil {
+			return err
+		}
+		var removed []string
+		for address := range delta.state.balances {
+			if !seen[address] {
+				removed = append(removed, address)
+			}
+		}
+		sort.Strings(removed)
+		for _, address := range removed {
+			err := fn([]byte(address), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case sectionSpent:
+		return forSectionEntries(section, func(entry []byte, value int64) error {
+			if delta.state.spent[string(entry)] {
+				return nil
+			}
+			return fn(entry, value)
+		})
+	}
+	return forSectionEntries(section, fn)
+}
+
+/*
+Returns the chain of snapshot files saved by this node: the last full snapshot and its deltas.
+*/
+func getSnapshotChain() []string {
+	var chain []string
+	err := db.Get(snapshotChainKey, &chain, nil)
+	if err != nil {
+		return nil
+	}
+	return chain
+}
+
+func setSnapshotChain(chain []string) {
+	err := db.Put(snapshotChainKey, chain, nil, nil)
+	if err != nil {
+		logs.Log.Errorf("Could not save the snapshot chain: %v", err)
+	}
+}
+
+/*
+Returns whether the file is part of the current snapshot chain.
+*/
+func isInSnapshotChain(file string) bool {
+	for _, chainFile := range getSnapshotChain() {
+		if path.Clean(chainFile) == path.Clean(file) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Returns the delta to write for the next snapshot, or nil if a full snapshot is due:
+when deltas are disabled, after the configured number of deltas or if the chain cannot be read.
+*/
+func getSnapshotDelta() *snapshotDelta {
+	interval := config.GetInt("snapshots.delta.interval")
+	chain := getSnapshotChain()
+	if interval <= 0 || len(chain) == 0 || len(chain) > interval {
+		return nil
+	}
+	state, base, err := readSnapshotChain(chain)
+	if err != nil {
+		logs.Log.Warningf("Could not read the snapshot chain, saving a full snapshot: %v", err)
+		return nil
+	}
+	return &snapshotDelta{*base, state}
+}
+
+/*
+Reads a full snapshot file and the deltas based on it, checking that each delta references
+the previous file. Returns the resulting state and the reference to the last file.
+*/
+func readSnapshotChain(paths []string) (*snapshotState, *snapshotBase, error) {
+	state := newSnapshotState()
+	var previous *snapshotBase
+	for i, path := range paths {
+		timestamp, base, err := readSnapshotFile(path, state)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%v: %v", path, err)
+		}
+		if i == 0 && base != nil {
+			return nil, nil, fmt.Errorf("%v is a delta snapshot, the chain must start with a full one", path)
+		} else if i > 0 && base == nil {
+			return nil, nil, fmt.Errorf("%v is not a delta snapshot", path)
+		} else if i > 0 && (base.timestamp != previous.timestamp || !bytes.Equal(base.hash, previous.hash)) {
+			return nil, nil, fmt.Errorf("%v is not based on %v", path, paths[i-1])
+		}
+		digest, err := fileDigest(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		previous = &snapshotBase{timestamp, digest}
+	}
+	return state, previous, nil
+}
+
+/*
+Applies a snapshot file of either format to the state. Returns its timestamp and base, if it is a delta.
+*/
+func readSnapshotFile(path string, state *snapshotState) (int64, *snapshotBase, error) {
+	header, err := loadHeader(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	state.bundles = nil
+	state.ignored = nil
+	state.pins = nil
+	if header.version != binaryVersion {
+		return header.timestamp, nil, readTextSnapshot(path, state)
+	}
+
+	var base *snapshotBase
+	_, err = readBinarySnapshot(path, func(section byte, entry []byte, value int64) error {
+		if section == sectionBase {
+			base, err = parseSnapshotBase(entry)
+			return err
+		}
+		state.apply(section, entry, value)
+		return nil
+	})
+	return header.timestamp, base, err
+}
+
+func readTextSnapshot(path string, state *snapshotState) error {
+	f, err := os.OpenFile(path, os.O_RDONLY, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stage := 0
+	firstLine := true
+	rd := bufio.NewReader(f)
+	for {
+		line, err := rd.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if line == SNAPSHOT_SEPARATOR {
+			stage++
+			continue
+		}
+		if firstLine {
+			firstLine = false
+			if !strings.Contains(line, ";") {
+				// Header
+				continue
+			}
+		}
+		if len(line) == 0 {
+			continue
+		}
+		switch stage {
+		case 0:
+			tokens := strings.Split(line, ";")
+			if len(tokens) < 2 {
+				return errors.New("invalid balance line: " + line)
+			}
+			value, err := strconv.ParseInt(tokens[1], 10, 64)
+			if err != nil {
+				return err
+			}
+			state.apply(sectionBalances, convert.TrytesToBytes(tokens[0])[:49], value)
+		case 1:
+			state.apply(sectionSpent, convert.TrytesToBytes(line)[:49], 0)
+		case 2:
+			state.apply(sectionBundles, convert.TrytesToBytes(line)[:16], 0)
+		case 3:
+			state.apply(sectionIgnored, convert.TrytesToBytes(line)[:16], 0)
+		}
+	}
+	return nil
+}
+
+/*
+Checks the signatures of a snapshot chain, the references of the deltas and the final totals.
+Returns the timestamp of the last snapshot and the final state.
+*/
+func checkSnapshotChain(paths []string) (int64, *snapshotState, error) {
+	for _, path := range paths {
+		_, err := loadHeader(path)
+		if err != nil {
+			return 0, nil, err
+		}
+		err = verifySnapshotSignature(path)
+		if err != nil {
+			logs.Log.Errorf("Snapshot signature check failed for %v: %v", path, err)
+			return 0, nil, err
+		}
+	}
+	state, last, err := readSnapshotChain(paths)
+	if err != nil {
+		logs.Log.Errorf("Snapshot chain check failed: %v", err)
+		return 0, nil, err
+	}
+
+	current, err := db.GetInt([]byte{db.KEY_SNAPSHOT_DATE}, nil)
+	if err == nil && int64(current) > last.timestamp {
+		return 0, nil, errors.New("current snapshot more recent")
+	}
+
+	var total int64 = 0
+	for _, value := range state.balances {
+		total += value
+	}
+	err = checkSnapshotTotals(total, int64(len(state.spent)))
+	if err != nil {
+		return 0, nil, err
+	}
+	return last.timestamp, state, nil
+}
+
+/*
+Loads the final state of a snapshot chain into the database.
+*/
+func doLoadSnapshotState(state *snapshotState) error {
+	err := db.RemoveAll(db.KEY_BALANCE)
+	if err != nil {
+		return err
+	}
+	err = db.RemoveAll(db.KEY_SNAPSHOT_BALANCE)
+	if err != nil {
+		return err
+	}
+
+	var txn = db.DB.NewTransaction(true)
+	var load = func(fn func(txn db.Txn) error) error {
+		err := fn(txn)
+		if err == db.ErrTxnTooBig {
+			err = txn.Commit()
+			if err != nil {
+				return err
+			}
+			txn = db.DB.NewTransaction(true)
+			err = fn(txn)
+		}
+		return err
+	}
+
+	for address, value := range state.balances {
+		address, value := []byte(address), value
+		err := load(func(txn db.Txn) error { return loadValueSnapshot(address, value, txn) })
+		if err != nil {
+			txn.Discard()
+			return err
+		}
+	}
+	for address := range state.spent {
+		address := []byte(address)
+		err := load(func(txn db.Txn) error { return loadSpentSnapshot(address, txn) })
+		if err != nil {
+			txn.Discard()
+			return err
+		}
+	}
+	err = txn.Commit()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range append(state.bundles, state.ignored...) {
+		err := loadKey(key)
+		if err != nil {
+			return err
+		}
+	}
+	for _, line := range state.pins {
+		pin, err := parsePin(line)
+		if err != nil {
+			logs.Log.Warning("Skipping pin from snapshot:", err)
+			continue
+		}
+		err = AddPin(pin)
+		if err != nil {
+			return err
+		}
+	}
+
+	logs.Log.Debugf("Snapshot total spent addresses: %v", len(state.spent))
+	return nil
+}
@@ -0,0 +1,59 @@
+package snapshot
+
+import (
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"../db"
+	"github.com/spf13/viper"
+)
+
+func TestDeltaSnapshotsKeepTheirBase(t *testing.T) {
+	saveTestSnapshotState(t)
+	config = viper.New()
+	config.Set("snapshots.delta.interval", 2)
+	config.Set("snapshots.filename", "fixed.snap")
+	dir := t.TempDir()
+	timestamp := int(time.Now().Unix()) - 1000
+
+	if err := SaveSnapshot(dir, timestamp, ""); err != nil {
+		t.Fatal(err)
+	}
+	full := path.Join(dir, "fixed.snap")
+	if chain := getSnapshotChain(); len(chain) != 1 || chain[0] != full {
+		t.Fatalf("Snapshot chain is %v, expected the full snapshot", chain)
+	}
+
+	address := db.GetAddressKey(getTestAddress("D"), db.KEY_SNAPSHOT_BALANCE)
+	if err := db.Put(address, int64(30), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveSnapshot(dir, timestamp+100, ""); err != nil {
+		t.Fatal(err)
+	}
+	delta := path.Join(dir, strconv.Itoa(timestamp+100)+".delta.snap")
+	chain := getSnapshotChain()
+	if len(chain) != 2 || chain[0] != full || chain[1] != delta {
+		t.Fatalf("Snapshot chain is %v, expected the full snapshot and a delta", chain)
+	}
+	state, _, err := readSnapshotChain(chain)
+	if err != nil {
+		t.Fatalf("Could not read the snapshot chain: %v", err)
+	}
+	if state.balances[string(getTestAddress("D"))] != 30 || state.balances[string(getTestAddress("A"))] != 100 {
+		t.Errorf("Balances of the chain are %v", state.balances)
+	}
+
+	// Overwriting the delta would break the chain
+	if err := SaveSnapshot(dir, timestamp+200, path.Base(delta)); err != nil {
+		t.Fatal(err)
+	}
+	if chain := getSnapshotChain(); len(chain) != 1 || chain[0] != delta {
+		t.Fatalf("Snapshot chain is %v, expected a new full snapshot", chain)
+	}
+	if _, base, err := readSnapshotFile(delta, newSnapshotState()); err != nil || base != nil {
+		t.Errorf("Snapshot saved over the chain is not a full snapshot (%v)", err)
+	}
+}
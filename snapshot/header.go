@@ -9,12 +9,15 @@ import (
 	"strings"
 	"time"
 
+	"../convert"
 	"../logs"
+	"../network"
 )
 
 type SnapshotHeader struct {
 	version int64
 	timestamp int64
+	milestone *SnapshotMilestone
 }
 
 
@@ -27,6 +30,21 @@ func loadHeader(path string) (*SnapshotHeader, error) {
 	defer f.Close()
 
 	rd := bufio.NewReader(f)
+	if isBinarySnapshot(rd) {
+		header, _, err := readBinaryHeader(rd)
+		if err != nil {
+			return nil, err
+		}
+		if header.timestamp < network.Current.MinSnapshotTime || header.timestamp > time.Now().Unix() {
+			return nil, errors.New("header validation failed")
+		}
+		header.milestone, err = readBinaryMilestone(path)
+		if err != nil {
+			return nil, err
+		}
+		return header, nil
+	}
+
 	line, err := rd.ReadString('\n')
 	line = strings.TrimSpace(line)
 	tokens := strings.Split(line, ",")
@@ -35,10 +53,10 @@ func loadHeader(path string) (*SnapshotHeader, error) {
 	if len(tokens) < 2 {
 		filename := filepath.Base(path)
 		timestamp, err := strconv.ParseInt(strings.Split(filename, ".")[0], 10, 32)
-		if err != nil || timestamp < TIMESTAMP_MIN || timestamp > time.Now().Unix() {
+		if err != nil || timestamp < network.Current.MinSnapshotTime || timestamp > time.Now().Unix() {
 			return nil, errors.New("no header found and filename seems not to be a timestamp")
 		}
-		return &SnapshotHeader{0,timestamp}, nil
+		return &SnapshotHeader{0,timestamp, nil}, nil
 	}
 
 	version, err := strconv.ParseInt(tokens[0], 10, 32)
@@ -51,7 +69,7 @@ func loadHeader(path string) (*SnapshotHeader, error) {
 
 	if version > 0 {
 		timestamp, err = strconv.ParseInt(tokens[1], 10, 32)
-		if err != nil || timestamp < TIMESTAMP_MIN || timestamp > time.Now().Unix() {
+		if err != nil || timestamp < network.Current.MinSnapshotTime || timestamp > time.Now().Unix() {
 			return nil, errors.New("header validation failed")
 		}
 	}
@@ -60,5 +78,15 @@ func loadHeader(path string) (*SnapshotHeader, error) {
 		return nil, errors.New("unknown header version found (hercules upgrade necessary?)")
 	}
 
-	return &SnapshotHeader{version, timestamp}, nil
+	// Milestone snapshots: version,timestamp,milestone index,milestone hash
+	var milestone *SnapshotMilestone
+	if len(tokens) >= 4 {
+		index, err := strconv.ParseInt(tokens[2], 10, 32)
+		if err != nil || len(tokens[3]) != 81 {
+			return nil, errors.New("invalid snapshot milestone in header")
+		}
+		milestone = &SnapshotMilestone{int(index), convert.TrytesToBytes(tokens[3])[:49]}
+	}
+
+	return &SnapshotHeader{version, timestamp, milestone}, nil
 }
\ No newline at end of file
@@ -7,7 +7,6 @@ import (
 	"io"
 	"strings"
 	"strconv"
-	"github.com/dgraph-io/badger"
 	"../logs"
 	"../db"
 	"../convert"
@@ -69,8 +68,8 @@ func loadIRISnapshotSpent(spentPath string) error {
 		}
 		err = loadSpentSnapshot(convert.TrytesToBytes(strings.TrimSpace(line))[:49], txn)
 		if err != nil {
-			if err == badger.ErrTxnTooBig {
-				err := txn.Commit(func(e error) {})
+			if err == db.ErrTxnTooBig {
+				err := txn.Commit()
 				if err != nil {
 					return err
 				}
@@ -83,7 +82,7 @@ func loadIRISnapshotSpent(spentPath string) error {
 		}
 	}
 
-	return txn.Commit(func (e error) {})
+	return txn.Commit()
 }
 
 func loadIRISnapshotValues(valuesPath string) error {
@@ -120,8 +119,8 @@ func loadIRISnapshotValues(valuesPath string) error {
 		total += value
 		err = loadValueSnapshot(address, value, txn)
 		if err != nil {
-			if err == badger.ErrTxnTooBig {
-				err := txn.Commit(func(e error) {})
+			if err == db.ErrTxnTooBig {
+				err := txn.Commit()
 				if err != nil {
 					return err
 				}
@@ -136,5 +135,5 @@ func loadIRISnapshotValues(valuesPath string) error {
 
 	logs.Log.Debugf("Snapshot total value: %v", total)
 
-	return txn.Commit(func (e error) {})
+	return txn.Commit()
 }
@@ -0,0 +1,432 @@
+package snapshot
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"../convert"
+	"../db"
+	"../logs"
+	"../network"
+	"../transaction"
+	"../utils"
+)
+
+/*
+IRI local snapshots consist of three text files sharing a base path:
+
+	<base>.snapshot.state:          ADDRESS;BALANCE per line
+	<base>.snapshot.spentaddresses: one spent address per line
+	<base>.snapshot.meta:           milestone hash, milestone index, timestamp, number of solid entry points,
+	                                number of seen milestones, then HASH;INDEX lines for both
+*/
+const (
+	iriStateSuffix = ".snapshot.state"
+	iriSpentSuffix = ".snapshot.spentaddresses"
+	iriMetaSuffix  = ".snapshot.meta"
+)
+
+var iriNullHash = strings.Repeat("9", 81)
+
+type iriEntryPoint struct {
+	hash  string
+	index int
+}
+
+type iriSnapshotMeta struct {
+	hash             string
+	index            int
+	timestamp        int64
+	solidEntryPoints []iriEntryPoint
+	seenMilestones   []iriEntryPoint
+}
+
+/*
+Returns the base path of an IRI local snapshot, given the base or one of its three files.
+*/
+func getIRILocalSnapshotBase(path string) string {
+	for _, suffix := range []string{iriStateSuffix, iriSpentSuffix, iriMetaSuffix} {
+		if strings.HasSuffix(path, suffix) {
+			return strings.TrimSuffix(path, suffix)
+		}
+	}
+	return path
+}
+
+/*
+Returns whether the path points to an IRI local snapshot, i.e. its meta file exists.
+*/
+func IsIRILocalSnapshot(path string) bool {
+	_, err := os.Stat(getIRILocalSnapshotBase(path) + iriMetaSuffix)
+	return err == nil
+}
+
+func readIRISnapshotMeta(path string) (*iriSnapshotMeta, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) < 5 || len(lines[0]) != 81 {
+		return nil, errors.New("invalid IRI snapshot meta file")
+	}
+
+	meta := &iriSnapshotMeta{hash: lines[0]}
+	meta.index, err = strconv.Atoi(lines[1])
+	if err != nil {
+		return nil, err
+	}
+	meta.timestamp, err = strconv.ParseInt(lines[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	solidCount, err := strconv.Atoi(lines[3])
+	if err != nil {
+		return nil, err
+	}
+	seenCount, err := strconv.Atoi(lines[4])
+	if err != nil {
+		return nil, err
+	}
+	if solidCount < 0 || seenCount < 0 || len(lines) != 5+solidCount+seenCount {
+		return nil, errors.New("wrong number of entries in IRI snapshot meta file")
+	}
+	for i, line := range lines[5:] {
+		tokens := strings.Split(line, ";")
+		if len(tokens) != 2 || len(tokens[0]) != 81 {
+			return nil, errors.New("invalid IRI snapshot meta entry: " + line)
+		}
+		index, err := strconv.Atoi(tokens[1])
+		if err != nil {
+			return nil, err
+		}
+		if i < solidCount {
+			meta.solidEntryPoints = append(meta.solidEntryPoints, iriEntryPoint{tokens[0], index})
+		} else {
+			meta.seenMilestones = append(meta.seenMilestones, iriEntryPoint{tokens[0], index})
+		}
+	}
+	return meta, nil
+}
+
+func forIRISnapshotLines(path string, fn func(line string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		err := fn(line)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+/*
+Reads the balances and spent addresses of an IRI local snapshot.
+*/
+func readIRILocalSnapshotState(base string) (*snapshotState, error) {
+	state := newSnapshotState()
+	err := forIRISnapshotLines(base+iriStateSuffix, func(line string) error {
+		tokens := strings.Split(line, ";")
+		if len(tokens) != 2 || len(tokens[0]) < 81 {
+			return errors.New("invalid IRI snapshot balance: " + line)
+		}
+		value, err := strconv.ParseInt(tokens[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		state.apply(sectionBalances, convert.TrytesToBytes(tokens[0][:81])[:49], value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = forIRISnapshotLines(base+iriSpentSuffix, func(line string) error {
+		if len(line) < 81 {
+			return errors.New("invalid IRI snapshot spent address: " + line)
+		}
+		state.apply(sectionSpent, convert.TrytesToBytes(line[:81])[:49], 0)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+/*
+Loads an IRI local snapshot like a snapshot file. Its timestamp is validated like the one of a snapshot
+header and the signatures of its three files are verified, so unsigned IRI snapshots need
+snapshots.signing.allowUnsigned. The snapshot milestone is taken from the meta file and its solid entry
+points are marked as edges of the tangle, so that they are not requested.
+*/
+func LoadIRILocalSnapshot(path string) error {
+	base := getIRILocalSnapshotBase(path)
+	logs.Log.Notice("Reading IRI local snapshot, please do not kill the process or stop the computer", base)
+	if CurrentTimestamp > 0 {
+		logs.Log.Warning("It seems that the the tangle database already exists. Skipping snapshot load from file.")
+		return nil
+	}
+
+	meta, err := readIRISnapshotMeta(base + iriMetaSuffix)
+	if err != nil {
+		logs.Log.Errorf("Could not read IRI snapshot meta file: %v", err)
+		return err
+	}
+	logs.Log.Infof("IRI local snapshot at milestone %v (%v), %v solid entry points",
+		meta.index, utils.GetHumanReadableTime(int(meta.timestamp)), len(meta.solidEntryPoints))
+	if meta.timestamp < network.Current.MinSnapshotTime || meta.timestamp > time.Now().Unix() {
+		return errors.New("IRI snapshot meta validation failed")
+	}
+	if !IsNewerThanSnapshot(int(meta.timestamp), nil) {
+		logs.Log.Infof("The given snapshot (%v) timestamp is older than the current one. Skipping", base)
+		return nil
+	}
+	for _, suffix := range []string{iriStateSuffix, iriSpentSuffix, iriMetaSuffix} {
+		err := verifySnapshotSignature(base + suffix)
+		if err != nil {
+			logs.Log.Errorf("Snapshot signature check failed: %v", err)
+			return err
+		}
+	}
+
+	state, err := readIRILocalSnapshotState(base)
+	if err != nil {
+		logs.Log.Errorf("Could not read IRI local snapshot: %v", err)
+		return err
+	}
+	var total int64 = 0
+	for _, value := range state.balances {
+		total += value
+	}
+	err = checkSnapshotTotals(total, int64(len(state.spent)))
+	if err != nil {
+		return err
+	}
+	err = Lock(int(meta.timestamp), base, nil)
+	if err != nil {
+		return err
+	}
+
+	db.Locker.Lock()
+	defer db.Locker.Unlock()
+
+	// Give time for other processes to finalize
+	time.Sleep(WAIT_SNAPSHOT_DURATION)
+
+	err = trimData(meta.timestamp, nil)
+	if err != nil {
+		return err
+	}
+	err = doLoadSnapshotState(state)
+	if err != nil {
+		logs.Log.Error("Failed loading IRI local snapshot!", err)
+		return err
+	}
+	for _, entryPoint := range meta.solidEntryPoints {
+		if entryPoint.hash == iriNullHash {
+			continue
+		}
+		key := db.GetByteKey(convert.TrytesToBytes(entryPoint.hash)[:49], db.KEY_EDGE)
+		err := db.Put(key, true, nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+	// Deltas cannot be based on IRI files
+	setSnapshotChain(nil)
+	err = setSnapshotMilestone(&SnapshotMilestone{meta.index, convert.TrytesToBytes(meta.hash)[:49]}, nil)
+	if err != nil {
+		return err
+	}
+
+	if !checkDatabaseSnapshot() {
+		return errors.New("failed database snapshot integrity check")
+	}
+	err = db.DB.Update(func(txn db.Txn) error {
+		err := SetSnapshotTimestamp(int(meta.timestamp), txn)
+		if err != nil {
+			return err
+		}
+		return Unlock(txn)
+	})
+	if err != nil {
+		return err
+	}
+	logs.Log.Notice("IRI local snapshot loaded")
+	return nil
+}
+
+/*
+Writes the current snapshot as IRI local snapshot files into the snapshots directory and returns
+their base path. Only milestone snapshots can be exported, as IRI needs the milestone index.
+*/
+func ExportIRILocalSnapshot(name string) (string, error) {
+	if InProgress {
+		return "", errors.New("a snapshot is currently in progress")
+	}
+	milestone := GetSnapshotMilestone(nil)
+	if milestone == nil {
+		return "", errors.New("the current snapshot was not made at a milestone")
+	}
+	if len(name) == 0 {
+		name = strconv.Itoa(milestone.Index)
+	}
+	snapshotDir := config.GetString("snapshots.path")
+	utils.CreateDirectory(snapshotDir)
+	base := path.Join(snapshotDir, path.Base(name))
+	logs.Log.Noticef("Exporting snapshot at milestone %v to IRI local snapshot %v...", milestone.Index, base)
+
+	err := writeIRISnapshotFile(base+iriStateSuffix, func(w *bufio.Writer) error {
+		return forSectionEntries(snapshotSection{sectionBalances, db.KEY_SNAPSHOT_BALANCE}, func(entry []byte, value int64) error {
+			_, err := fmt.Fprintf(w, "%v;%v\n", convert.BytesToTrytes(entry)[:81], value)
+			return err
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	err = writeIRISnapshotFile(base+iriSpentSuffix, func(w *bufio.Writer) error {
+		return forSectionEntries(snapshotSection{sectionSpent, db.KEY_SNAPSHOT_SPENT}, func(entry []byte, _ int64) error {
+			_, err := fmt.Fprintln(w, convert.BytesToTrytes(entry)[:81])
+			return err
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+
+	solidEntryPoints, err := getSolidEntryPoints(milestone)
+	if err != nil {
+		return "", err
+	}
+	meta := &iriSnapshotMeta{
+		hash:             convert.BytesToTrytes(milestone.Hash)[:81],
+		index:            milestone.Index,
+		timestamp:        int64(GetSnapshotTimestamp(nil)),
+		solidEntryPoints: solidEntryPoints,
+	}
+	err = writeIRISnapshotFile(base+iriMetaSuffix, func(w *bufio.Writer) error {
+		fmt.Fprintln(w, meta.hash)
+		fmt.Fprintln(w, meta.index)
+		fmt.Fprintln(w, meta.timestamp)
+		fmt.Fprintln(w, len(meta.solidEntryPoints))
+		fmt.Fprintln(w, len(meta.seenMilestones))
+		for _, entryPoint := range append(meta.solidEntryPoints, meta.seenMilestones...) {
+			_, err := fmt.Fprintf(w, "%v;%v\n", entryPoint.hash, entryPoint.index)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	logs.Log.Notice("IRI local snapshot exported")
+	return base, nil
+}
+
+/*
+Returns the solid entry points of a milestone snapshot: the null hash, the snapshot milestone and the
+confirmed transactions at or below it (trimmed or pending to be trimmed) that are referenced by transactions
+kept in the database. Their index is the one of their confirming milestone, if it is still known, otherwise
+the one of the snapshot milestone.
+*/
+func getSolidEntryPoints(milestone *SnapshotMilestone) ([]iriEntryPoint, error) {
+	hash := convert.BytesToTrytes(milestone.Hash)[:81]
+	entryPoints := []iriEntryPoint{{iriNullHash, milestone.Index}, {hash, milestone.Index}}
+	seen := map[string]bool{iriNullHash: true, hash: true}
+
+	err := db.DB.View(func(txn db.Txn) error {
+		return txn.ForPrefix([]byte{db.KEY_BYTES}, false, func(key []byte, _ []byte) (bool, error) {
+			if db.Has(db.AsKey(key, db.KEY_EVENT_TRIM_PENDING), txn) {
+				return true, nil
+			}
+			txBytes, err := db.GetBytes(key, txn)
+			if err != nil {
+				return false, err
+			}
+			trits := convert.BytesToTrits(txBytes)[:8019]
+			tx := transaction.TritsToTX(&trits, txBytes)
+			for _, referenced := range [][]byte{tx.TrunkTransaction, tx.BranchTransaction} {
+				trytes := convert.BytesToTrytes(referenced)[:81]
+				if seen[trytes] {
+					continue
+				}
+				hashKey := db.GetByteKey(referenced, db.KEY_HASH)
+				index := 0
+				if db.Has(db.AsKey(hashKey, db.KEY_EVENT_TRIM_PENDING), txn) {
+					if !db.Has(db.AsKey(hashKey, db.KEY_CONFIRMED), txn) {
+						continue
+					}
+					index = getConfirmationIndex(hashKey, txn)
+				} else if db.Has(hashKey, txn) || db.Has(db.AsKey(hashKey, db.KEY_PENDING_HASH), txn) {
+					// Kept or still requested
+					continue
+				}
+				if index <= 0 || index > milestone.Index {
+					index = milestone.Index
+				}
+				seen[trytes] = true
+				entryPoints = append(entryPoints, iriEntryPoint{trytes, index})
+			}
+			return true, nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entryPoints[2:], func(i, j int) bool { return entryPoints[2+i].hash < entryPoints[2+j].hash })
+	return entryPoints, nil
+}
+
+func writeIRISnapshotFile(path string, write func(w *bufio.Writer) error) error {
+	file, err := os.Create(path + "_")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	err = write(w)
+	if err != nil {
+		return err
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(path+"_", path)
+	if err != nil {
+		return err
+	}
+	return signSnapshotFile(path)
+}
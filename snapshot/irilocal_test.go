@@ -0,0 +1,194 @@
+package snapshot
+
+import (
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"../convert"
+	"../db"
+	"github.com/spf13/viper"
+)
+
+const testIRILocalSnapshot = "testdata/devnet"
+
+func readTestLines(t *testing.T, file string) []string {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	sort.Strings(lines)
+	return lines
+}
+
+func TestLoadIRILocalSnapshot(t *testing.T) {
+	resetTestSnapshotLoad(t)
+	allowUnsigned = false
+	if err := LoadIRILocalSnapshot(testIRILocalSnapshot); err == nil {
+		t.Fatal("Unsigned IRI local snapshot was loaded")
+	}
+
+	allowUnsigned = true
+	if err := LoadIRILocalSnapshot(testIRILocalSnapshot + iriMetaSuffix); err != nil {
+		t.Fatal(err)
+	}
+	if GetSnapshotTimestamp(nil) != 1540000000 {
+		t.Errorf("Snapshot timestamp is %v, expected 1540000000", GetSnapshotTimestamp(nil))
+	}
+	milestone := GetSnapshotMilestone(nil)
+	if milestone == nil || milestone.Index != 1000 || !strings.HasPrefix(convert.BytesToTrytes(milestone.Hash), "MILESTONE9") {
+		t.Errorf("Snapshot milestone is %v, expected 1000", milestone)
+	}
+	balance, err := db.GetInt64(db.GetAddressKey(getTestAddress("ADDRESSB"), db.KEY_BALANCE), nil)
+	if err != nil || balance != 1000 {
+		t.Errorf("Balance is %v (%v), expected 1000", balance, err)
+	}
+	for _, name := range []string{"ADDRESSA", "ADDRESSC"} {
+		if !db.Has(db.GetAddressKey(getTestAddress(name), db.KEY_SPENT), nil) {
+			t.Errorf("%v is not spent", name)
+		}
+	}
+	if !db.Has(db.GetByteKey(getTestAddress("MILESTONE"), db.KEY_EDGE), nil) {
+		t.Error("Solid entry point is not an edge")
+	}
+	if _, locked := IsLocked(nil); locked != "" || InProgress {
+		t.Error("Snapshot is still locked")
+	}
+}
+
+func TestIRILocalSnapshotRoundTrip(t *testing.T) {
+	resetTestSnapshotLoad(t)
+	if err := LoadIRILocalSnapshot(testIRILocalSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	// The export is signed, so the import needs no unsigned files
+	setTestSigning(t, true, false)
+	dir := t.TempDir()
+	config = viper.New()
+	config.Set("snapshots.path", dir)
+	base, err := ExportIRILocalSnapshot("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base != path.Join(dir, "1000") {
+		t.Errorf("Exported to %v, expected the milestone index as name", base)
+	}
+
+	for _, suffix := range []string{iriStateSuffix, iriSpentSuffix} {
+		expected := readTestLines(t, testIRILocalSnapshot+suffix)
+		exported := readTestLines(t, base+suffix)
+		if strings.Join(exported, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Exported %v is %v, expected %v", suffix, exported, expected)
+		}
+	}
+	meta, err := readIRISnapshotMeta(base + iriMetaSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.hash != convert.BytesToTrytes(getTestAddress("MILESTONE"))[:81] || meta.index != 1000 || meta.timestamp != 1540000000 {
+		t.Errorf("Exported meta is %v", meta)
+	}
+	if len(meta.solidEntryPoints) != 2 || meta.solidEntryPoints[0].hash != iriNullHash {
+		t.Errorf("Exported solid entry points are %v", meta.solidEntryPoints)
+	}
+
+	resetTestSnapshotLoad(t)
+	allowUnsigned = false
+	if err := LoadIRILocalSnapshot(base); err != nil {
+		t.Fatal(err)
+	}
+	balance, err := db.GetInt64(db.GetAddressKey(getTestAddress("ADDRESSB"), db.KEY_BALANCE), nil)
+	if err != nil || balance != 1000 {
+		t.Errorf("Balance after the round trip is %v (%v), expected 1000", balance, err)
+	}
+	if milestone := GetSnapshotMilestone(nil); milestone == nil || milestone.Index != 1000 {
+		t.Errorf("Snapshot milestone after the round trip is %v, expected 1000", milestone)
+	}
+}
+
+func TestIRILocalSnapshotTimestampIsValidated(t *testing.T) {
+	resetTestSnapshotLoad(t)
+	dir := t.TempDir()
+	for _, suffix := range []string{iriStateSuffix, iriSpentSuffix, iriMetaSuffix} {
+		content, err := ioutil.ReadFile(testIRILocalSnapshot + suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if suffix == iriMetaSuffix {
+			future := strconv.FormatInt(time.Now().Unix()+3600, 10)
+			content = []byte(strings.Replace(string(content), "\n1540000000\n", "\n"+future+"\n", 1))
+		}
+		if err := ioutil.WriteFile(path.Join(dir, "future"+suffix), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := LoadIRILocalSnapshot(path.Join(dir, "future")); err == nil {
+		t.Error("IRI local snapshot from the future was loaded")
+	}
+	if timestamp, _ := IsLocked(nil); timestamp > 0 || GetSnapshotTimestamp(nil) > 0 {
+		t.Error("Invalid IRI local snapshot was partially loaded")
+	}
+}
+
+/*
+Saves a transaction that is kept in the database, referencing the given hashes.
+*/
+func saveTestReferencingTX(t *testing.T, name string, trunk string, branch string) {
+	trits := make([]int, 8019)
+	copy(trits[7290:7533], convert.TrytesToTrits(convert.BytesToTrytes(getTestAddress(trunk))[:81]))
+	copy(trits[7533:7776], convert.TrytesToTrits(convert.BytesToTrytes(getTestAddress(branch))[:81]))
+	key := db.GetByteKey(getTestAddress(name), db.KEY_HASH)
+	if err := db.Put(db.AsKey(key, db.KEY_BYTES), convert.TritsToBytes(trits), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(key, getTestAddress(name), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportedSolidEntryPoints(t *testing.T) {
+	resetTestSnapshotLoad(t)
+	if err := LoadIRILocalSnapshot(testIRILocalSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	// TRIMMED is confirmed by milestone 990 and trimmed after the export, GONE is trimmed already
+	trimmed := db.GetByteKey(getTestAddress("TRIMMED"), db.KEY_HASH)
+	for _, key := range [][]byte{db.AsKey(trimmed, db.KEY_EVENT_TRIM_PENDING), db.AsKey(trimmed, db.KEY_CONFIRMED)} {
+		if err := db.Put(key, true, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Put(db.AsKey(trimmed, db.KEY_CONFIRMATION), []int64{990, 0}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	requested := db.GetByteKey(getTestAddress("REQUESTED"), db.KEY_PENDING_HASH)
+	if err := db.Put(requested, true, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	saveTestReferencingTX(t, "KEPT", "TRIMMED", "GONE")
+	saveTestReferencingTX(t, "TIP", "KEPT", "REQUESTED")
+
+	entryPoints, err := getSolidEntryPoints(GetSnapshotMilestone(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []iriEntryPoint{
+		{iriNullHash, 1000},
+		{convert.BytesToTrytes(getTestAddress("MILESTONE"))[:81], 1000},
+		{convert.BytesToTrytes(getTestAddress("GONE"))[:81], 1000},
+		{convert.BytesToTrytes(getTestAddress("TRIMMED"))[:81], 990},
+	}
+	if len(entryPoints) != len(expected) {
+		t.Fatalf("Solid entry points are %v, expected %v", entryPoints, expected)
+	}
+	for i := range expected {
+		if entryPoints[i] != expected[i] {
+			t.Errorf("Solid entry point %v is %v, expected %v", i, entryPoints[i], expected[i])
+		}
+	}
+}
@@ -8,27 +8,40 @@ import (
 	"strconv"
 	"os"
 	"github.com/pkg/errors"
-	"github.com/dgraph-io/badger"
 	"../db"
 	"../logs"
 	"../convert"
 )
 
 func LoadSnapshot (path string) error {
-	logs.Log.Info("Loading snapshot from", path)
+	return LoadSnapshotChain([]string{path})
+}
+
+/*
+Loads a snapshot file, or a full snapshot file followed by the chain of delta snapshots based on it.
+*/
+func LoadSnapshotChain (paths []string) error {
+	logs.Log.Info("Loading snapshot from", strings.Join(paths, ", "))
 	if CurrentTimestamp > 0 {
 		logs.Log.Warning("It seems that the the tangle database already exists. Skipping snapshot load from file.")
 		return nil
 	}
-	timestamp, err := checkSnapshotFile(path)
+	var timestamp int64
+	var state *snapshotState
+	var err error
+	if len(paths) == 1 {
+		timestamp, err = checkSnapshotFile(paths[0])
+	} else {
+		timestamp, state, err = checkSnapshotChain(paths)
+	}
 	if err != nil { return err }
 	logs.Log.Debug("Timestamp:", timestamp)
 
 	if !IsNewerThanSnapshot(int(timestamp), nil) {
-		logs.Log.Infof("The given snapshot (%v) timestamp is older than the current one. Skipping", path)
+		logs.Log.Infof("The given snapshot (%v) timestamp is older than the current one. Skipping", paths[len(paths)-1])
 		return nil
 	}
-	Lock(int(timestamp), path, nil)
+	Lock(int(timestamp), strings.Join(paths, ","), nil)
 
 	db.Locker.Lock()
 	defer db.Locker.Unlock()
@@ -37,15 +50,25 @@ func LoadSnapshot (path string) error {
 	time.Sleep(WAIT_SNAPSHOT_DURATION)
 
 	logs.Log.Debug("Saving trimmable TXs flags...")
-	err = trimData(timestamp)
+	err = trimData(timestamp, nil)
 	logs.Log.Debug("Saved trimmable TXs flags:", len(edgeTransactions))
 	if err != nil { return err }
 
-	err = doLoadSnapshot(path)
+	if state == nil {
+		err = doLoadSnapshot(paths[0])
+	} else {
+		err = doLoadSnapshotState(state)
+	}
+	if err != nil { return err }
+	setSnapshotChain(paths)
+
+	header, err := loadHeader(paths[len(paths)-1])
+	if err != nil { return err }
+	err = setSnapshotMilestone(header.milestone, nil)
 	if err != nil { return err }
 
 	if checkDatabaseSnapshot() {
-		return db.DB.Update(func(txn *badger.Txn) error {
+		return db.DB.Update(func(txn db.Txn) error {
 			err:= SetSnapshotTimestamp(int(timestamp), txn)
 			if err != nil { return err }
 
@@ -58,7 +81,7 @@ func LoadSnapshot (path string) error {
 	}
 }
 
-func loadValueSnapshot(address []byte, value int64, txn *badger.Txn) error {
+func loadValueSnapshot(address []byte, value int64, txn db.Txn) error {
 	addressKey := db.GetAddressKey(address, db.KEY_SNAPSHOT_BALANCE)
 	err := db.Put(addressKey, value, nil, txn)
 	if err != nil {
@@ -71,7 +94,7 @@ func loadValueSnapshot(address []byte, value int64, txn *badger.Txn) error {
 	return nil
 }
 
-func loadSpentSnapshot(address []byte, txn *badger.Txn) error {
+func loadSpentSnapshot(address []byte, txn db.Txn) error {
 	err := db.PutBytes(db.GetAddressKey(address, db.KEY_SNAPSHOT_SPENT), address, nil, txn)
 	if err != nil { return err }
 	err = db.Put(db.GetAddressKey(address, db.KEY_SNAPSHOT_SPENT), true, nil, txn)
@@ -87,6 +110,10 @@ func loadKey(key []byte) error {
 
 func doLoadSnapshot (path string) error{
 	logs.Log.Infof("Loading values from %v. It can take several minutes. Please hold...", path)
+	header, err := loadHeader(path)
+	if err != nil {
+		return err
+	}
 	f, err := os.OpenFile(path, os.O_RDONLY, os.ModePerm)
 	if err != nil {
 		logs.Log.Fatalf("open file error: %v", err)
@@ -100,6 +127,10 @@ func doLoadSnapshot (path string) error{
 		return err
 	}
 
+	if header.version == binaryVersion {
+		return doLoadBinarySnapshot(path)
+	}
+
 	stage := 0
 	rd := bufio.NewReader(f)
 	var total int64 = 0
@@ -139,8 +170,8 @@ func doLoadSnapshot (path string) error{
 			total += value
 			err = loadValueSnapshot(address, value, txn)
 			if err != nil {
-				if err == badger.ErrTxnTooBig {
-					err := txn.Commit(func(e error) {})
+				if err == db.ErrTxnTooBig {
+					err := txn.Commit()
 					if err != nil {
 						return err
 					}
@@ -155,8 +186,8 @@ func doLoadSnapshot (path string) error{
 			totalSpent++
 			err = loadSpentSnapshot(convert.TrytesToBytes(strings.TrimSpace(line))[:49], txn)
 			if err != nil {
-				if err == badger.ErrTxnTooBig {
-					err := txn.Commit(func(e error) {})
+				if err == db.ErrTxnTooBig {
+					err := txn.Commit()
 					if err != nil {
 						return err
 					}
@@ -171,8 +202,8 @@ func doLoadSnapshot (path string) error{
 			key := convert.TrytesToBytes(line)[:16]
 			err = loadKey(key)
 			if err != nil {
-				if err == badger.ErrTxnTooBig {
-					err := txn.Commit(func(e error) {})
+				if err == db.ErrTxnTooBig {
+					err := txn.Commit()
 					if err != nil {
 						return err
 					}
@@ -186,7 +217,7 @@ func doLoadSnapshot (path string) error{
 		}
 	}
 
-	err = txn.Commit(func(e error) {})
+	err = txn.Commit()
 	if err != nil {
 		return err
 	}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"encoding/gob"
 	"github.com/pkg/errors"
-	"github.com/dgraph-io/badger"
 	"../db"
 	"../logs"
 	"../convert"
@@ -20,7 +19,15 @@ type KeyValue struct {
 Creates a snapshot on the current tangle database.
  */
 func MakeSnapshot(timestamp int, filename string) error {
-	logs.Log.Infof("Making snapshot for Unix time %v...", timestamp)
+	return makeSnapshot(timestamp, nil, filename)
+}
+
+func makeSnapshot(timestamp int, milestone *SnapshotMilestone, filename string) error {
+	if milestone != nil {
+		logs.Log.Infof("Making snapshot for milestone %v (Unix time %v)...", milestone.Index, timestamp)
+	} else {
+		logs.Log.Infof("Making snapshot for Unix time %v...", timestamp)
+	}
 	InProgress = true
 	defer func() {
 		InProgress = false
@@ -39,7 +46,7 @@ func MakeSnapshot(timestamp int, filename string) error {
 		return false
 	}
 
-	err := db.DB.Update(func(txn *badger.Txn) error {
+	err := db.DB.Update(func(txn db.Txn) error {
 		if !IsEqualOrNewerThanSnapshot(int(timestamp), nil) {
 			logs.Log.Infof("The given snapshot (%v) timestamp is older than the current one. Skipping", timestamp)
 			return errors.New("given snapshot is older than current one")
@@ -48,7 +55,7 @@ func MakeSnapshot(timestamp int, filename string) error {
 			logs.Log.Warning("Tangle not fully synchronized - cannot create snapshot!")
 			return errors.New("tangle not fully synchronized")
 		}
-		if !CanSnapshot(timestamp) {
+		if (milestone == nil && !CanSnapshot(timestamp)) || (milestone != nil && !CanSnapshotMilestone(milestone.Index)) {
 			logs.Log.Warning("Pending confirmations behind the snapshot horizon - cannot create snapshot!")
 			return errors.New("tangle not fully synchronized")
 		}
@@ -59,58 +66,45 @@ func MakeSnapshot(timestamp int, filename string) error {
 		}
 
 		Lock(int(timestamp), "", nil)
+		if milestone != nil {
+			db.Put(keySnapshotMilestoneLock, milestone.Index, nil, nil)
+		}
 
-		opts := badger.DefaultIteratorOptions
-		it := txn.NewIterator(opts)
-		itClosed := false
-		defer func () {
-			if !itClosed {
-				it.Close()
-			}
-		}()
-		prefix := []byte{db.KEY_TIMESTAMP}
 		logs.Log.Debug("Collecting all value bundles before the snapshot horizon...")
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			item := it.Item()
-			k := item.Key()
-			v, err := item.Value()
-			if err == nil {
-				var txTimestamp = 0
-				buf := bytes.NewBuffer(v)
-				dec := gob.NewDecoder(buf)
-				err := dec.Decode(&txTimestamp)
-				if err == nil {
-					if txTimestamp <= timestamp {
-						key := db.AsKey(k, db.KEY_CONFIRMED)
-						if db.Has(key, txn) && !db.Has(db.AsKey(key, db.KEY_EVENT_TRIM_PENDING), txn){
-							value, err := db.GetInt64(db.AsKey(key, db.KEY_VALUE), txn)
-							if err == nil && value != 0 {
-								txBytes, err := db.GetBytes(db.AsKey(key, db.KEY_BYTES), txn)
-								if err != nil { return err }
-								trits := convert.BytesToTrits(txBytes)[:8019]
-								tx := transaction.TritsToFastTX(&trits, txBytes)
-								if !contains(tx.Bundle) {
-									bundles = append(bundles, tx.Bundle)
-								}
-							}
+		err := txn.ForPrefix([]byte{db.KEY_TIMESTAMP}, true, func(k []byte, v []byte) (bool, error) {
+			var txTimestamp = 0
+			buf := bytes.NewBuffer(v)
+			dec := gob.NewDecoder(buf)
+			err := dec.Decode(&txTimestamp)
+			if err != nil {
+				logs.Log.Error("Could not parse a TX timestamp value!")
+				return false, err
+			}
+			if milestone != nil || txTimestamp <= timestamp {
+				key := db.AsKey(k, db.KEY_CONFIRMED)
+				if db.Has(key, txn) && !db.Has(db.AsKey(key, db.KEY_EVENT_TRIM_PENDING), txn) &&
+					isInSnapshot(key, txTimestamp, timestamp, milestone, txn) {
+					value, err := db.GetInt64(db.AsKey(key, db.KEY_VALUE), txn)
+					if err == nil && value != 0 {
+						txBytes, err := db.GetBytes(db.AsKey(key, db.KEY_BYTES), txn)
+						if err != nil { return false, err }
+						trits := convert.BytesToTrits(txBytes)[:8019]
+						tx := transaction.TritsToFastTX(&trits, txBytes)
+						if !contains(tx.Bundle) {
+							bundles = append(bundles, tx.Bundle)
 						}
 					}
-				} else {
-					logs.Log.Error("Could not parse a TX timestamp value!")
-					return err
 				}
-			} else {
-				logs.Log.Error("Could not get a TX timestamp value!")
-				return err
 			}
+			return true, nil
+		})
+		if err != nil {
+			return err
 		}
 
-		it.Close()
-		itClosed = true
-
 		logs.Log.Debugf("Found %v value bundles. Collecting corresponding transactions...", len(bundles))
 		for _, bundleHash := range bundles {
-			bundleTxs, snaps, bundleKeep, err := loadAllFromBundle(bundleHash, timestamp, txn)
+			bundleTxs, snaps, bundleKeep, err := loadAllFromBundle(bundleHash, timestamp, milestone, txn)
 			if err != nil { return err }
 			txs = append(txs, bundleTxs...)
 			if bundleKeep != nil && len(bundleKeep) == 16 {
@@ -128,7 +122,7 @@ func MakeSnapshot(timestamp int, filename string) error {
 	logs.Log.Debugf("Found %v value transactions. Applying to previous snapshot...", len(txs))
 	for _, kv := range txs {
 		var trimKey []byte
-		err := db.DB.Update(func(txn *badger.Txn) error {
+		err := db.DB.Update(func(txn db.Txn) error {
 			// First: update snapshot balances
 			address, err := db.GetBytes(db.AsKey(kv.key, db.KEY_ADDRESS_HASH), txn)
 			if err != nil { return err }
@@ -171,8 +165,10 @@ func MakeSnapshot(timestamp int, filename string) error {
 
 	if checkDatabaseSnapshot() {
 		logs.Log.Debug("Scheduling transaction trimming")
-		trimData(int64(timestamp))
-		err = db.DB.Update(func(txn *badger.Txn) error {
+		trimData(int64(timestamp), milestone)
+		err = setSnapshotMilestone(milestone, nil)
+		if err != nil { return err }
+		err = db.DB.Update(func(txn db.Txn) error {
 			err:= SetSnapshotTimestamp(timestamp, txn)
 			if err != nil { return err }
 
@@ -191,46 +187,49 @@ func MakeSnapshot(timestamp int, filename string) error {
 	}
 }
 
-func loadAllFromBundle (bundleHash []byte, timestamp int, txn *badger.Txn) ([]KeyValue, [][]byte, []byte, error) {
+func loadAllFromBundle (bundleHash []byte, timestamp int, milestone *SnapshotMilestone, txn db.Txn) ([]KeyValue, [][]byte, []byte, error) {
 	var totalValue int64 = 0
-	opts := badger.DefaultIteratorOptions
-	opts.PrefetchValues = false
-	it := txn.NewIterator(opts)
-	defer it.Close()
 	prefix := db.GetByteKey(bundleHash, db.KEY_BUNDLE)
 	var txs []KeyValue
 	var snapshotted [][]byte
 	var nonZero = false
 
-	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-		k := it.Item().Key()
+	err := txn.ForPrefix(prefix, false, func(k []byte, _ []byte) (bool, error) {
 		key := make([]byte, 16)
 		copy(key, k[16:])
 		// Filter out unconfirmed reattachments:
 		if !db.Has(db.AsKey(key, db.KEY_CONFIRMED), txn) || db.Has(db.AsKey(key, db.KEY_EVENT_TRIM_PENDING), txn) {
-			continue
+			return true, nil
 		}
 
 		txTimestamp, err := db.GetInt(db.AsKey(key, db.KEY_TIMESTAMP), txn)
 		if err != nil {
-			return nil, nil, nil, err
+			return false, err
 		}
-		if txTimestamp > timestamp {
+		if milestone != nil {
+			// Confirmed by a later milestone
+			if !isInSnapshot(key, txTimestamp, timestamp, milestone, txn) {
+				return true, nil
+			}
+		} else if txTimestamp > timestamp {
 			snapshotted = append(snapshotted, db.AsKey(prefix, db.KEY_SNAPSHOTTED))
 		}
 
 		valueKey := db.AsKey(key, db.KEY_VALUE)
 		value, err := db.GetInt64(valueKey, txn)
-		if err == nil {
-			if value != 0 {
-				totalValue += value
-				txs = append(txs, KeyValue{valueKey, value})
-				nonZero = true
-			}
-		} else {
+		if err != nil {
 			logs.Log.Errorf("Error reading value for %v", valueKey)
-			return nil, nil, nil, err
+			return false, err
+		}
+		if value != 0 {
+			totalValue += value
+			txs = append(txs, KeyValue{valueKey, value})
+			nonZero = true
 		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
 	}
 	// Probably debris from last snapshot. Has most probably to do with timestamps vs attachment timestamps
 	if totalValue != 0 || !nonZero {
@@ -0,0 +1,208 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	"../convert"
+	"../db"
+	"../logs"
+)
+
+/*
+The milestone a snapshot was made at. Such a snapshot contains exactly the transactions
+confirmed by this milestone and the ones before it, so that it does not depend on the
+transaction timestamps.
+*/
+type SnapshotMilestone struct {
+	Index int
+	Hash  []byte
+}
+
+var keySnapshotMilestone = db.GetByteKey([]byte("snapshotMilestone"), db.KEY_OTHER)
+var keySnapshotMilestoneLock = db.GetByteKey([]byte("snapshotMilestoneLock"), db.KEY_OTHER)
+
+func (milestone *SnapshotMilestone) bytes() []byte {
+	b := make([]byte, 8, 8+len(milestone.Hash))
+	binary.BigEndian.PutUint64(b, uint64(milestone.Index))
+	return append(b, milestone.Hash...)
+}
+
+func parseSnapshotMilestone(entry []byte) (*SnapshotMilestone, error) {
+	if len(entry) != 8+49 {
+		return nil, errors.New("invalid snapshot milestone")
+	}
+	return &SnapshotMilestone{int(binary.BigEndian.Uint64(entry)), entry[8:]}, nil
+}
+
+func (milestone *SnapshotMilestone) String() string {
+	return fmt.Sprintf("%v,%v", milestone.Index, convert.BytesToTrytes(milestone.Hash)[:81])
+}
+
+/*
+Returns the milestone of the current snapshot, or nil if it was made at a timestamp.
+*/
+func GetSnapshotMilestone(txn db.Txn) *SnapshotMilestone {
+	var milestone SnapshotMilestone
+	err := db.Get(keySnapshotMilestone, &milestone, txn)
+	if err != nil {
+		return nil
+	}
+	return &milestone
+}
+
+func setSnapshotMilestone(milestone *SnapshotMilestone, txn db.Txn) error {
+	if milestone == nil {
+		return db.Remove(keySnapshotMilestone, txn)
+	}
+	return db.Put(keySnapshotMilestone, milestone, nil, txn)
+}
+
+/*
+Returns the confirmed milestone with the given index and its timestamp.
+*/
+func getMilestone(index int, txn db.Txn) (*SnapshotMilestone, int, error) {
+	var key []byte
+	err := txn.ForPrefix([]byte{db.KEY_MILESTONE}, true, func(k []byte, v []byte) (bool, error) {
+		var ms = 0
+		if gob.NewDecoder(bytes.NewBuffer(v)).Decode(&ms) == nil && ms == index {
+			key = db.AsKey(k, db.KEY_HASH)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if key == nil {
+		return nil, 0, fmt.Errorf("milestone %v not found", index)
+	}
+	if !db.Has(db.AsKey(key, db.KEY_CONFIRMED), txn) {
+		return nil, 0, fmt.Errorf("milestone %v is not confirmed yet", index)
+	}
+	hash, err := db.GetBytes(db.AsKey(key, db.KEY_HASH), txn)
+	if err != nil {
+		return nil, 0, err
+	}
+	timestamp, err := db.GetInt(db.AsKey(key, db.KEY_TIMESTAMP), txn)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &SnapshotMilestone{index, hash}, timestamp, nil
+}
+
+/*
+Returns the highest known milestone index.
+*/
+func getLatestMilestoneIndex() int {
+	latest := 0
+	_ = db.DB.View(func(txn db.Txn) error {
+		return txn.ForPrefix([]byte{db.KEY_MILESTONE}, true, func(_ []byte, v []byte) (bool, error) {
+			var ms = 0
+			if gob.NewDecoder(bytes.NewBuffer(v)).Decode(&ms) == nil && ms > latest {
+				latest = ms
+			}
+			return true, nil
+		})
+	})
+	return latest
+}
+
+/*
+Returns the index of the milestone that confirmed the transaction, or 0 if it is not known.
+*/
+func getConfirmationIndex(key []byte, txn db.Txn) int {
+	var confirmation []int64
+	err := db.Get(db.AsKey(key, db.KEY_CONFIRMATION), &confirmation, txn)
+	if err != nil || len(confirmation) == 0 {
+		return 0
+	}
+	return int(confirmation[0])
+}
+
+/*
+Returns whether a confirmed transaction belongs to the snapshot: by its confirming milestone for
+milestone snapshots, by its timestamp otherwise.
+*/
+func isInSnapshot(key []byte, txTimestamp int, timestamp int, milestone *SnapshotMilestone, txn db.Txn) bool {
+	if milestone != nil {
+		index := getConfirmationIndex(key, txn)
+		return index > 0 && index <= milestone.Index
+	}
+	return txTimestamp <= timestamp
+}
+
+/*
+Returns whether a confirmed value transaction that is not trimmed yet has no recorded confirming
+milestone, e.g. because it was confirmed before it was recorded or through an unknown milestone.
+*/
+func hasUnknownConfirmations(txn db.Txn) bool {
+	unknown := false
+	_ = txn.ForPrefix([]byte{db.KEY_CONFIRMED}, false, func(key []byte, _ []byte) (bool, error) {
+		if db.Has(db.AsKey(key, db.KEY_EVENT_TRIM_PENDING), txn) {
+			return true, nil
+		}
+		value, err := db.GetInt64(db.AsKey(key, db.KEY_VALUE), txn)
+		if err == nil && value != 0 && getConfirmationIndex(key, txn) == 0 {
+			unknown = true
+			return false, nil
+		}
+		return true, nil
+	})
+	return unknown
+}
+
+/*
+Checks that no confirmation of the given milestone or an earlier one is still pending.
+Milestone snapshots are also refused while the confirming milestone of some value transactions
+is not known, as they could not be attributed to the same snapshot by every node.
+*/
+func CanSnapshotMilestone(index int) bool {
+	pendingConfirmations := false
+	err := db.DB.View(func(txn db.Txn) error {
+		if hasUnknownConfirmations(txn) {
+			logs.Log.Warning("The confirming milestone of some transactions is unknown - cannot create a milestone snapshot!")
+			pendingConfirmations = true
+			return nil
+		}
+		return txn.ForPrefix([]byte{db.KEY_EVENT_CONFIRMATION_PENDING}, true, func(_ []byte, v []byte) (bool, error) {
+			var pending []int
+			err := gob.NewDecoder(bytes.NewBuffer(v)).Decode(&pending)
+			if err != nil {
+				return false, err
+			}
+			// Confirmations of an unknown milestone would be unknown too
+			if len(pending) < 2 || pending[1] <= index {
+				pendingConfirmations = true
+				return false, nil
+			}
+			return true, nil
+		})
+	})
+	return err == nil && !pendingConfirmations
+}
+
+/*
+Creates a snapshot containing the past cone of the milestone with the given index.
+*/
+func MakeMilestoneSnapshot(index int, filename string) error {
+	var milestone *SnapshotMilestone
+	var timestamp int
+	err := db.DB.View(func(txn db.Txn) (err error) {
+		milestone, timestamp, err = getMilestone(index, txn)
+		return err
+	})
+	if err != nil {
+		logs.Log.Warningf("Cannot snapshot at milestone %v: %v", index, err)
+		return err
+	}
+	current := GetSnapshotMilestone(nil)
+	if current != nil && current.Index >= index {
+		logs.Log.Infof("The given milestone (%v) is not newer than the current snapshot (%v). Skipping", index, current.Index)
+		return errors.New("given milestone is not newer than the current snapshot")
+	}
+	return makeSnapshot(timestamp, milestone, filename)
+}
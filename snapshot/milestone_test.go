@@ -0,0 +1,111 @@
+package snapshot
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"../db"
+	"github.com/spf13/viper"
+)
+
+/*
+Saves the milestone snapshot of the test state, with node-local entries that differ per node.
+*/
+func saveTestMilestoneSnapshot(t *testing.T, dir string, node string, format int) []byte {
+	saveTestSnapshotState(t)
+	// Enough addresses for the key order to differ from the order of their trytes
+	for _, name := range []string{"AB", "BA", "AD", "DA", "NB", "BN"} {
+		if err := db.Put(db.GetAddressKey(getTestAddress(name), db.KEY_SNAPSHOT_BALANCE), int64(1), nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := setSnapshotMilestone(&SnapshotMilestone{5, getTestAddress("M")}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(db.GetByteKey([]byte(node), db.KEY_PENDING_BUNDLE), true, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	pin, err := NewPin(PIN_TAG, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddPin(pin); err != nil {
+		t.Fatal(err)
+	}
+
+	config = viper.New()
+	config.Set("snapshots.format", format)
+	if err := SaveSnapshot(path.Join(dir, node), 1000, "milestone.snap"); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(path.Join(dir, node, "milestone.snap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestMilestoneSnapshotsAreIdentical(t *testing.T) {
+	dir := t.TempDir()
+	defer func() { lowEndDevice = false }()
+	for _, format := range []int{1, binaryVersion} {
+		lowEndDevice = false
+		first := saveTestMilestoneSnapshot(t, dir, "ONE", format)
+		// Low-end devices do not sort the lines of other snapshots
+		lowEndDevice = true
+		second := saveTestMilestoneSnapshot(t, dir, "TWO", format)
+		if !bytes.Equal(first, second) {
+			t.Errorf("Milestone snapshots of format %v differ between nodes", format)
+		}
+	}
+
+	file := path.Join(dir, "TWO", "milestone.snap")
+	_, sections := readTestBinarySnapshot(t, file)
+	if len(sections[sectionBundles]) > 0 || len(sections[sectionIgnored]) > 0 || len(sections[sectionPins]) > 0 {
+		t.Error("Binary milestone snapshot contains node-local sections")
+	}
+	milestone, err := readBinaryMilestone(file)
+	if err != nil || milestone == nil || milestone.Index != 5 || !bytes.Equal(milestone.Hash, getTestAddress("M")) {
+		t.Errorf("Read milestone %v (%v), expected index 5", milestone, err)
+	}
+}
+
+func TestUnknownConfirmationsPreventMilestoneSnapshots(t *testing.T) {
+	db.DB = db.NewMemoryStore()
+	known := db.GetByteKey([]byte("known"), db.KEY_CONFIRMED)
+	if err := db.Put(known, 0, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(db.AsKey(known, db.KEY_VALUE), int64(10), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(db.AsKey(known, db.KEY_CONFIRMATION), []int64{5, 0}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	milestone := &SnapshotMilestone{5, getTestAddress("M")}
+	if !isInSnapshot(known, 2000, 1000, milestone, nil) || isInSnapshot(known, 0, 1000, &SnapshotMilestone{4, nil}, nil) {
+		t.Error("Transaction is not attributed to its confirming milestone")
+	}
+	if !CanSnapshotMilestone(5) {
+		t.Error("Milestone snapshot was refused")
+	}
+
+	unknown := db.GetByteKey([]byte("unknown"), db.KEY_CONFIRMED)
+	if err := db.Put(unknown, 0, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(db.AsKey(unknown, db.KEY_VALUE), int64(-10), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(db.AsKey(unknown, db.KEY_CONFIRMATION), []int64{0, 0}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if isInSnapshot(unknown, 0, 1000, milestone, nil) {
+		t.Error("Transaction with an unknown confirming milestone is attributed by its timestamp")
+	}
+	if CanSnapshotMilestone(5) {
+		t.Error("Milestone snapshot was allowed with an unknown confirming milestone")
+	}
+}
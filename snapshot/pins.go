@@ -0,0 +1,240 @@
+package snapshot
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"../convert"
+	"../db"
+	"../logs"
+	"../transaction"
+)
+
+const (
+	PIN_TRANSACTION = "transaction"
+	PIN_BUNDLE      = "bundle"
+	PIN_ADDRESS     = "address"
+	PIN_TAG         = "tag"
+
+	maxCachedPinnedBundles = 100000
+)
+
+/*
+Rule keeping matching transactions, and the rest of their bundles, in the database beyond the snapshots.
+Hash holds the transaction, bundle or address hash (81 trytes) or the tag (27 trytes).
+*/
+type Pin struct {
+	Type string
+	Hash string
+}
+
+var pins = make(map[string]map[string]bool)
+var pinnedTXKeys = make(map[string]bool)
+var pinnedBundles = make(map[string]bool)
+var pinsLocker = &sync.RWMutex{}
+
+/*
+Validates and normalizes a pin rule. Tags shorter than 27 trytes are padded with 9s.
+*/
+func NewPin(pinType string, hash string) (*Pin, error) {
+	length := 81
+	switch pinType {
+	case PIN_TRANSACTION, PIN_BUNDLE, PIN_ADDRESS:
+		if len(hash) == 90 {
+			hash = hash[:81]
+		}
+	case PIN_TAG:
+		length = 27
+		if len(hash) < length {
+			hash += strings.Repeat("9", length-len(hash))
+		}
+	default:
+		return nil, errors.New("unknown pin type: " + pinType)
+	}
+	if !convert.IsTrytes(hash, length) {
+		return nil, errors.New("wrong " + pinType + " trytes: " + hash)
+	}
+	return &Pin{pinType, hash}, nil
+}
+
+func (pin *Pin) String() string {
+	return pin.Type + ";" + pin.Hash
+}
+
+func parsePin(line string) (*Pin, error) {
+	tokens := strings.Split(line, ";")
+	if len(tokens) != 2 {
+		return nil, errors.New("wrong pin: " + line)
+	}
+	return NewPin(tokens[0], tokens[1])
+}
+
+func getPinKey(pin *Pin) []byte {
+	return db.GetByteKey([]byte(pin.String()), db.KEY_PIN)
+}
+
+/*
+Loads the pins saved in the database and adds the ones from the config.
+*/
+func loadPins() {
+	var loaded []*Pin
+	_ = db.DB.View(func(txn db.Txn) error {
+		return txn.ForPrefix([]byte{db.KEY_PIN}, false, func(key []byte, _ []byte) (bool, error) {
+			var pin Pin
+			err := db.Get(key, &pin, txn)
+			if err != nil {
+				logs.Log.Warning("Could not load a pin", err)
+				return true, nil
+			}
+			loaded = append(loaded, &pin)
+			return true, nil
+		})
+	})
+	pinsLocker.Lock()
+	for _, pin := range loaded {
+		addToPins(pin)
+	}
+	pinsLocker.Unlock()
+
+	for pinType, option := range map[string]string{
+		PIN_TRANSACTION: "snapshots.pins.transactions",
+		PIN_BUNDLE:      "snapshots.pins.bundles",
+		PIN_ADDRESS:     "snapshots.pins.addresses",
+		PIN_TAG:         "snapshots.pins.tags",
+	} {
+		for _, hash := range config.GetStringSlice(option) {
+			pin, err := NewPin(pinType, hash)
+			if err == nil {
+				err = AddPin(pin)
+			}
+			if err != nil {
+				logs.Log.Warningf("Could not add pin from %v: %v", option, err)
+			}
+		}
+	}
+	logs.Log.Infof("Loaded %v pins", len(GetPins()))
+}
+
+/*
+Adds a pin. Pins are persisted and saved in the snapshot files.
+*/
+func AddPin(pin *Pin) error {
+	err := db.Put(getPinKey(pin), pin, nil, nil)
+	if err != nil {
+		return err
+	}
+	pinsLocker.Lock()
+	addToPins(pin)
+	pinsLocker.Unlock()
+	return nil
+}
+
+/*
+Removes a pin. Transactions only kept because of it are trimmed again.
+*/
+func RemovePin(pin *Pin) error {
+	err := db.Remove(getPinKey(pin), nil)
+	if err != nil {
+		return err
+	}
+
+	pinsLocker.Lock()
+	delete(pins[pin.Type], pin.Hash)
+	if len(pins[pin.Type]) == 0 {
+		delete(pins, pin.Type)
+	}
+	if pin.Type == PIN_TRANSACTION {
+		delete(pinnedTXKeys, string(getPinnedTXKey(pin.Hash)))
+	}
+	pinnedBundles = make(map[string]bool)
+	pinsLocker.Unlock()
+
+	go scheduleTrimPending()
+	return nil
+}
+
+/*
+Returns all pins, sorted by type and hash.
+*/
+func GetPins() []*Pin {
+	pinsLocker.RLock()
+	defer pinsLocker.RUnlock()
+
+	var result []*Pin
+	for pinType, hashes := range pins {
+		for hash := range hashes {
+			result = append(result, &Pin{pinType, hash})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].String() < result[j].String() })
+	return result
+}
+
+func addToPins(pin *Pin) {
+	if pins[pin.Type] == nil {
+		pins[pin.Type] = make(map[string]bool)
+	}
+	pins[pin.Type][pin.Hash] = true
+	if pin.Type == PIN_TRANSACTION {
+		pinnedTXKeys[string(getPinnedTXKey(pin.Hash))] = true
+	}
+	pinnedBundles = make(map[string]bool)
+}
+
+func getPinnedTXKey(hash string) []byte {
+	return db.GetByteKey(convert.TrytesToBytes(hash)[:49], db.KEY_HASH)
+}
+
+/*
+Returns whether the transaction or another one of its bundle matches a pin.
+*/
+func isPinned(hashKey []byte, tx *transaction.FastTX, txn db.Txn) bool {
+	pinsLocker.Lock()
+	defer pinsLocker.Unlock()
+
+	if len(pins) == 0 {
+		return false
+	}
+	if matchesPin(hashKey, tx) {
+		return true
+	}
+
+	bundle := string(tx.Bundle)
+	pinned, ok := pinnedBundles[bundle]
+	if ok {
+		return pinned
+	}
+
+	prefix := db.GetByteKey(tx.Bundle, db.KEY_BUNDLE)
+	_ = txn.ForPrefix(prefix, false, func(k []byte, _ []byte) (bool, error) {
+		key := db.AsKey(k[16:], db.KEY_HASH)
+		txBytes, err := db.GetBytes(db.AsKey(key, db.KEY_BYTES), txn)
+		if err != nil {
+			return true, nil
+		}
+		trits := convert.BytesToTrits(txBytes)[:8019]
+		pinned = matchesPin(key, transaction.TritsToFastTX(&trits, txBytes))
+		return !pinned, nil
+	})
+
+	if len(pinnedBundles) >= maxCachedPinnedBundles {
+		pinnedBundles = make(map[string]bool)
+	}
+	pinnedBundles[bundle] = pinned
+	return pinned
+}
+
+func matchesPin(hashKey []byte, tx *transaction.FastTX) bool {
+	if pinnedTXKeys[string(hashKey)] {
+		return true
+	}
+	if len(pins[PIN_BUNDLE]) > 0 && pins[PIN_BUNDLE][convert.BytesToTrytes(tx.Bundle)[:81]] {
+		return true
+	}
+	if len(pins[PIN_ADDRESS]) > 0 && pins[PIN_ADDRESS][convert.BytesToTrytes(tx.Address)[:81]] {
+		return true
+	}
+	return len(pins[PIN_TAG]) > 0 && pins[PIN_TAG][convert.BytesToTrytes(tx.Tag)[:27]]
+}
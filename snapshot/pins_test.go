@@ -0,0 +1,70 @@
+package snapshot
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"../db"
+	"../network"
+	"github.com/spf13/viper"
+)
+
+/*
+Saves a snapshot holding the whole supply in the default text format and returns its path.
+*/
+func saveTestDefaultSnapshot(t *testing.T, dir string, timestamp int) string {
+	address := db.GetAddressKey(getTestAddress("SUPPLY"), db.KEY_SNAPSHOT_BALANCE)
+	if err := db.Put(address, network.Current.TotalSupply, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	config = viper.New()
+	config.Set("snapshots.format", 1)
+	if err := SaveSnapshot(dir, timestamp, ""); err != nil {
+		t.Fatal(err)
+	}
+	return path.Join(dir, strconv.Itoa(timestamp)+".snap")
+}
+
+func isTestBinarySnapshot(t *testing.T, file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	return isBinarySnapshot(bufio.NewReader(f))
+}
+
+func TestPinsAreKeptBySnapshots(t *testing.T) {
+	resetTestSnapshotLoad(t)
+	timestamp := int(time.Now().Unix()) - 1000
+	dir := t.TempDir()
+	file := saveTestDefaultSnapshot(t, path.Join(dir, "unpinned"), timestamp)
+	if isTestBinarySnapshot(t, file) {
+		t.Error("Snapshot without pins was not saved in the text format")
+	}
+
+	pin, err := NewPin(PIN_TAG, "TEST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddPin(pin); err != nil {
+		t.Fatal(err)
+	}
+	file = saveTestDefaultSnapshot(t, path.Join(dir, "pinned"), timestamp)
+	if !isTestBinarySnapshot(t, file) {
+		t.Error("Snapshot with pins was saved in the text format")
+	}
+
+	resetTestSnapshotLoad(t)
+	if err := LoadSnapshot(file); err != nil {
+		t.Fatal(err)
+	}
+	pins := GetPins()
+	if len(pins) != 1 || pins[0].String() != pin.String() {
+		t.Errorf("Loaded pins %v, expected %v", pins, pin)
+	}
+}
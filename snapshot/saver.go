@@ -9,7 +9,6 @@ import (
 	"encoding/gob"
 	"fmt"
 	"sort"
-	"github.com/dgraph-io/badger"
 	"../logs"
 	"../db"
 	"../convert"
@@ -24,6 +23,11 @@ func SaveSnapshot(snapshotDir string, timestamp int, filename string) error {
 
 
 	timestampString := strconv.FormatInt(int64(timestamp), 10)
+	delta := getSnapshotDelta()
+	if delta != nil && len(filename) == 0 {
+		// The configured filename would overwrite the previous file of the chain
+		filename = timestampString + ".delta.snap"
+	}
 	if len(filename) == 0 {
 		filename = config.GetString("snapshots.filename")
 	}
@@ -32,6 +36,10 @@ func SaveSnapshot(snapshotDir string, timestamp int, filename string) error {
 	}
 
 	savepth := path.Join(snapshotDir, filename)
+	if delta != nil && isInSnapshotChain(savepth) {
+		logs.Log.Warningf("%v is part of the snapshot chain, saving a full snapshot instead of a delta", savepth)
+		delta = nil
+	}
 	pth := savepth + "_"
 	file, err := os.Create(pth)
 	if err != nil {
@@ -40,15 +48,46 @@ func SaveSnapshot(snapshotDir string, timestamp int, filename string) error {
 	}
 	defer file.Close()
 
+	binary := delta != nil || config.GetInt("snapshots.format") == binaryVersion
+	if !binary && GetSnapshotMilestone(nil) == nil && len(GetPins()) > 0 {
+		// The text format has no pins: older versions would load them as keys
+		logs.Log.Warning("Pins are only kept by binary snapshots, saving the snapshot in the binary format")
+		binary = true
+	}
+	if binary {
+		err = writeBinarySnapshot(file, timestamp, delta)
+		if err != nil { return err }
+		logs.Log.Notice("Snapshot saved")
+		err = os.Rename(pth, savepth)
+		if err != nil { return err }
+		err = signSnapshotFile(savepth)
+		if err != nil { return err }
+		if delta != nil {
+			logs.Log.Noticef("Saved as delta of the snapshot from %v", delta.base.timestamp)
+			setSnapshotChain(append(getSnapshotChain(), savepth))
+		} else {
+			setSnapshotChain([]string{savepth})
+		}
+		return nil
+	}
+
 	w := bufio.NewWriter(file)
 
 	var lineBuffer []string
 
-	// Write header
-	fmt.Fprintln(w, currentHeaderVersion+","+timestampString)
+	// Write header, with the milestone for milestone snapshots
+	header := currentHeaderVersion+","+timestampString
+	milestone := GetSnapshotMilestone(nil)
+	if milestone != nil {
+		header += "," + milestone.String()
+	}
+	fmt.Fprintln(w, header)
+
+	// Milestone snapshots are always sorted, so that every node writes the same file
+	sortLines := !lowEndDevice || milestone != nil
 
 	var addToBuffer = func (line string) {
-		if lowEndDevice {
+		if !sortLines {
 			fmt.Fprintln(w, line)
 		} else {
 			lineBuffer = append(lineBuffer, line)
@@ -57,110 +96,95 @@ func SaveSnapshot(snapshotDir string, timestamp int, filename string) error {
 
 	var commitBuffer = func () {
 		defer func() { lineBuffer = nil } ()
-		if lowEndDevice || lineBuffer == nil { return }
+		if !sortLines || lineBuffer == nil { return }
 		sort.Strings(lineBuffer)
 		for _, line := range lineBuffer {
 			fmt.Fprintln(w, line)
 		}
 	}
 
-	err = db.DB.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = true
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := []byte{db.KEY_SNAPSHOT_BALANCE}
-
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			item := it.Item()
-			key := item.Key()
-			v, err := item.Value()
-			if err == nil {
-				var value int64 = 0
-				buf := bytes.NewBuffer(v)
-				dec := gob.NewDecoder(buf)
-				err := dec.Decode(&value)
-				if err == nil {
-					// Do not save zero-value addresses
-					if value == 0 { continue }
-
-					line := convert.BytesToTrytes(key[1:])[:81] + ";" + strconv.FormatInt(int64(value), 10)
-					addToBuffer(line)
-				} else {
-					logs.Log.Error("Could not parse a snapshot value from database!", err)
-					return err
-				}
-			} else {
-				logs.Log.Error("Could not read a snapshot value from database!", err)
-				return err
+	err = db.DB.View(func(txn db.Txn) error {
+		err := txn.ForPrefix([]byte{db.KEY_SNAPSHOT_BALANCE}, true, func(key []byte, v []byte) (bool, error) {
+			var value int64 = 0
+			buf := bytes.NewBuffer(v)
+			dec := gob.NewDecoder(buf)
+			err := dec.Decode(&value)
+			if err != nil {
+				logs.Log.Error("Could not parse a snapshot value from database!", err)
+				return false, err
 			}
+			// Do not save zero-value addresses
+			if value == 0 { return true, nil }
+
+			line := convert.BytesToTrytes(key[1:])[:81] + ";" + strconv.FormatInt(int64(value), 10)
+			addToBuffer(line)
+			return true, nil
+		})
+		if err != nil {
+			logs.Log.Error("Could not read a snapshot value from database!", err)
+			return err
 		}
 		commitBuffer()
 		return nil
 	})
 
 	fmt.Fprintln(w, SNAPSHOT_SEPARATOR)
-	err = db.DB.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = false
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := []byte{db.KEY_SNAPSHOT_SPENT}
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			key := it.Item().Key()
+	err = db.DB.View(func(txn db.Txn) error {
+		err := txn.ForPrefix([]byte{db.KEY_SNAPSHOT_SPENT}, false, func(key []byte, _ []byte) (bool, error) {
 			line := convert.BytesToTrytes(key[1:])[:81]
 			addToBuffer(line)
-		}
+			return true, nil
+		})
 		commitBuffer()
-		return nil
+		return err
 	})
 	if err != nil { return err }
 
 
+	// Milestone snapshots leave out the node-local sections, so that every node writes the same file
 	fmt.Fprintln(w, SNAPSHOT_SEPARATOR)
-	err = db.DB.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = false
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := []byte{db.KEY_PENDING_BUNDLE}
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			key := it.Item().Key()
+	if milestone == nil {
+		err = db.DB.View(func(txn db.Txn) error {
+			err := txn.ForPrefix([]byte{db.KEY_PENDING_BUNDLE}, false, func(key []byte, _ []byte) (bool, error) {
+				line := convert.BytesToTrytes(key)
+				addToBuffer(line)
+				return true, nil
+			})
 			if err != nil {
 				logs.Log.Error("Could not get keep Bundle from the database!", err)
 				return err
 			}
-			line := convert.BytesToTrytes(key)
-			addToBuffer(line)
-		}
-		commitBuffer()
-		return nil
-	})
-	if err != nil { return err }
-
+			commitBuffer()
+			return nil
+		})
+		if err != nil { return err }
+	}
 
 	fmt.Fprintln(w, SNAPSHOT_SEPARATOR)
-	err = db.DB.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = false
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := []byte{db.KEY_SNAPSHOTTED}
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			key := it.Item().Key()
+	if milestone == nil {
+		err = db.DB.View(func(txn db.Txn) error {
+			err := txn.ForPrefix([]byte{db.KEY_SNAPSHOTTED}, false, func(key []byte, _ []byte) (bool, error) {
+				line := convert.BytesToTrytes(key)
+				addToBuffer(line)
+				return true, nil
+			})
 			if err != nil {
 				logs.Log.Error("Could not get ignore TX from the database!", err)
 				return err
 			}
-			line := convert.BytesToTrytes(key)
-			addToBuffer(line)
-		}
-		commitBuffer()
-		return nil
-	})
-	if err != nil { return err }
+			commitBuffer()
+			return nil
+		})
+		if err != nil { return err }
+	}
+
 	logs.Log.Notice("Snapshot saved, flushing...")
 	err = w.Flush()
 	if err != nil { return err }
-	return os.Rename(pth, savepth)
+	err = os.Rename(pth, savepth)
+	if err != nil { return err }
+	err = signSnapshotFile(savepth)
+	if err != nil { return err }
+	setSnapshotChain([]string{savepth})
+	return nil
 }
@@ -0,0 +1,177 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"../logs"
+)
+
+/*
+Snapshot files are signed with a detached signature file next to them, named <snapshot file>.sig:
+
+	ed25519 <public key, hex> <signature of the SHA-256 of the snapshot file, hex>
+*/
+const (
+	signatureSuffix = ".sig"
+	signatureScheme = "ed25519"
+)
+
+var signingKey ed25519.PrivateKey
+var trustedKeys []ed25519.PublicKey
+var allowUnsigned = false
+
+func signatureOnLoad() {
+	keyFile := config.GetString("snapshots.signing.keyFile")
+	if len(keyFile) > 0 {
+		key, err := loadSigningKey(keyFile)
+		if err != nil {
+			logs.Log.Fatalf("Could not load the snapshot signing key: %v", err)
+		}
+		signingKey = key
+		logs.Log.Infof("Signing snapshots with public key %v", hex.EncodeToString(key.Public().(ed25519.PublicKey)))
+	}
+
+	for _, trusted := range config.GetStringSlice("snapshots.signing.trustedKeys") {
+		key, err := hex.DecodeString(strings.TrimSpace(trusted))
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			logs.Log.Warningf("Ignoring invalid trusted snapshot key: %v", trusted)
+			continue
+		}
+		trustedKeys = append(trustedKeys, ed25519.PublicKey(key))
+	}
+	allowUnsigned = config.GetBool("snapshots.signing.allowUnsigned")
+	if allowUnsigned {
+		logs.Log.Warning("Unsigned and untrusted snapshot files will be loaded")
+	}
+}
+
+/*
+Loads the private key from the hex encoded seed in the given file. A new key is generated
+and saved there if the file does not exist.
+*/
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		err = ioutil.WriteFile(path, []byte(hex.EncodeToString(key.Seed())+"\n"), 0600)
+		if err != nil {
+			return nil, err
+		}
+		logs.Log.Noticef("Generated a new snapshot signing key in %v", path)
+		return key, nil
+	} else if err != nil {
+		return nil, err
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, errors.New("the key file must contain a hex encoded 32 bytes seed")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func fileDigest(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	digest := sha256.New()
+	_, err = io.Copy(digest, f)
+	if err != nil {
+		return nil, err
+	}
+	return digest.Sum(nil), nil
+}
+
+/*
+Writes the signature file of the given snapshot file, if a signing key is configured.
+*/
+func signSnapshotFile(path string) error {
+	if signingKey == nil {
+		return nil
+	}
+	digest, err := fileDigest(path)
+	if err != nil {
+		return err
+	}
+	line := fmt.Sprintf("%v %v %v\n", signatureScheme,
+		hex.EncodeToString(signingKey.Public().(ed25519.PublicKey)),
+		hex.EncodeToString(ed25519.Sign(signingKey, digest)))
+	err = ioutil.WriteFile(path+signatureSuffix+"_", []byte(line), 0644)
+	if err != nil {
+		return err
+	}
+	logs.Log.Notice("Snapshot signed")
+	return os.Rename(path+signatureSuffix+"_", path+signatureSuffix)
+}
+
+/*
+Verifies the signature file of the given snapshot file against the trusted keys.
+Unsigned files or files signed by another key are only accepted if allowed in the config.
+Invalid signatures are always refused.
+*/
+func verifySnapshotSignature(path string) error {
+	data, err := ioutil.ReadFile(path + signatureSuffix)
+	if os.IsNotExist(err) {
+		if allowUnsigned {
+			logs.Log.Warningf("Loading unsigned snapshot file %v", path)
+			return nil
+		}
+		return errors.New("the snapshot file is not signed (no " + path + signatureSuffix + ")")
+	} else if err != nil {
+		return err
+	}
+
+	tokens := strings.Fields(string(data))
+	if len(tokens) != 3 || tokens[0] != signatureScheme {
+		return errors.New("unknown snapshot signature format")
+	}
+	publicKey, err := hex.DecodeString(tokens[1])
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return errors.New("invalid snapshot signature public key")
+	}
+	signature, err := hex.DecodeString(tokens[2])
+	if err != nil {
+		return errors.New("invalid snapshot signature")
+	}
+	digest, err := fileDigest(path)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(ed25519.PublicKey(publicKey), digest, signature) {
+		return errors.New("the snapshot signature is invalid")
+	}
+
+	for _, trusted := range trustedKeys {
+		if bytes.Equal(trusted, publicKey) {
+			logs.Log.Infof("Snapshot signed by trusted key %v", tokens[1])
+			return nil
+		}
+	}
+	if allowUnsigned {
+		logs.Log.Warningf("Loading snapshot signed by untrusted key %v", tokens[1])
+		return nil
+	}
+	return errors.New("the snapshot is signed by an untrusted key: " + tokens[1])
+}
+
+/*
+Returns whether a signature file exists for the given snapshot file.
+*/
+func IsSigned(path string) bool {
+	_, err := os.Stat(path + signatureSuffix)
+	return err == nil
+}
@@ -0,0 +1,89 @@
+package snapshot
+
+import (
+	"crypto/ed25519"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func setTestSigning(t *testing.T, trusted bool, unsigned bool) ed25519.PublicKey {
+	publicKey, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingKey = key
+	trustedKeys = nil
+	if trusted {
+		trustedKeys = []ed25519.PublicKey{publicKey}
+	}
+	allowUnsigned = unsigned
+	t.Cleanup(func() {
+		signingKey = nil
+		trustedKeys = nil
+		allowUnsigned = false
+	})
+	return publicKey
+}
+
+func writeTestSignedFile(t *testing.T) string {
+	file := path.Join(t.TempDir(), "1000.snap")
+	if err := ioutil.WriteFile(file, []byte("snapshot content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := signSnapshotFile(file); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestTrustedSignatureIsAccepted(t *testing.T) {
+	setTestSigning(t, true, false)
+	file := writeTestSignedFile(t)
+	if !IsSigned(file) {
+		t.Fatal("Signature file was not written")
+	}
+	if err := verifySnapshotSignature(file); err != nil {
+		t.Errorf("Trusted signature was refused: %v", err)
+	}
+}
+
+func TestUntrustedSignatureIsRefused(t *testing.T) {
+	setTestSigning(t, false, false)
+	file := writeTestSignedFile(t)
+	if err := verifySnapshotSignature(file); err == nil {
+		t.Error("Signature of an untrusted key was accepted")
+	}
+	allowUnsigned = true
+	if err := verifySnapshotSignature(file); err != nil {
+		t.Errorf("Signature of an untrusted key was refused although allowed: %v", err)
+	}
+}
+
+func TestUnsignedFileIsRefused(t *testing.T) {
+	setTestSigning(t, true, false)
+	file := writeTestSignedFile(t)
+	if err := os.Remove(file + signatureSuffix); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifySnapshotSignature(file); err == nil {
+		t.Error("Unsigned file was accepted")
+	}
+	allowUnsigned = true
+	if err := verifySnapshotSignature(file); err != nil {
+		t.Errorf("Unsigned file was refused although allowed: %v", err)
+	}
+}
+
+func TestTamperedFileIsRefused(t *testing.T) {
+	setTestSigning(t, true, true)
+	file := writeTestSignedFile(t)
+	if err := ioutil.WriteFile(file, []byte("snapshot content!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Invalid signatures are refused even if unsigned files are allowed
+	if err := verifySnapshotSignature(file); err == nil {
+		t.Error("Tampered file was accepted")
+	}
+}
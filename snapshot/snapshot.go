@@ -5,19 +5,16 @@ import (
 
 	"../db"
 	"../logs"
-	"github.com/dgraph-io/badger"
+	"../metrics"
 	"github.com/spf13/viper"
 )
 
 const (
 	SNAPSHOT_SEPARATOR         = "==="
-	TIMESTAMP_MIN              = 1525017600
 	WAIT_SNAPSHOT_DURATION     = time.Duration(3) * time.Second
-	MIN_SPENT_ADDRESSES        = 521970
 	MAX_LATEST_TRANSACTION_AGE = 300
 )
 
-var TOTAL_IOTAS int64 = 2779530283277761
 var keySnapshotDate = []byte{db.KEY_SNAPSHOT_DATE}
 var keySnapshotLock = []byte{db.KEY_SNAPSHOT_LOCK}
 var keySnapshotFile = []byte{db.KEY_SNAPSHOT_FILE}
@@ -36,6 +33,9 @@ func Start(cfg *viper.Viper) {
 	lowEndDevice = config.GetBool("light")
 	CurrentTimestamp = GetSnapshotTimestamp(nil)
 	logs.Log.Infof("Current snapshot timestamp: %v", CurrentTimestamp)
+	registerMetrics()
+	loadPins()
+	signatureOnLoad()
 
 	// LoadIRISnapshot("snapshotMainnet.txt", "previousEpochsSpentAddresses.txt", 1525017600)
 	// LoadAddressBytes("snapshotMainnet.txt")
@@ -50,7 +50,9 @@ func Start(cfg *viper.Viper) {
 	iri2 := config.GetString("snapshots.loadIRISpentFile")
 	iriTimestamp := config.GetInt("snapshots.loadIRITimestamp")
 	if len(snapshotToLoad) > 0 {
-		LoadSnapshot(snapshotToLoad)
+		LoadSnapshotChain(append([]string{snapshotToLoad}, config.GetStringSlice("snapshots.loadDeltas")...))
+	} else if len(iri1) > 0 && IsIRILocalSnapshot(iri1) {
+		LoadIRILocalSnapshot(iri1)
 	} else if len(iri1) > 0 && len(iri2) > 0 && iriTimestamp > 0 {
 		LoadIRISnapshot(iri1, iri2, iriTimestamp)
 	}
@@ -63,7 +65,7 @@ func Start(cfg *viper.Viper) {
 /*
 Sets the current snapshot date in the database
 */
-func SetSnapshotTimestamp(timestamp int, txn *badger.Txn) error {
+func SetSnapshotTimestamp(timestamp int, txn db.Txn) error {
 	err := db.Put(keySnapshotDate, timestamp, nil, txn)
 	if err == nil {
 		CurrentTimestamp = timestamp
@@ -75,14 +77,14 @@ func SetSnapshotTimestamp(timestamp int, txn *badger.Txn) error {
 Returns timestamp if snapshot lock is present. Otherwise negative number.
 If this is a file lock (snapshot being loaded from a file)
 */
-func IsLocked(txn *badger.Txn) (timestamp int, filename string) {
+func IsLocked(txn db.Txn) (timestamp int, filename string) {
 	return GetSnapshotLock(txn), GetSnapshotFileLock(txn)
 }
 
 /*
 Creates a snapshot lock in the database
 */
-func Lock(timestamp int, filename string, txn *badger.Txn) error {
+func Lock(timestamp int, filename string, txn db.Txn) error {
 	InProgress = true
 	err := db.Put(keySnapshotLock, timestamp, nil, txn)
 	if err != nil {
@@ -94,19 +96,23 @@ func Lock(timestamp int, filename string, txn *badger.Txn) error {
 /*
 Removes a snapshot lock in the database
 */
-func Unlock(txn *badger.Txn) error {
+func Unlock(txn db.Txn) error {
 	InProgress = false
 	err := db.Remove(keySnapshotLock, txn)
 	if err != nil {
 		return err
 	}
+	err = db.Remove(keySnapshotMilestoneLock, txn)
+	if err != nil {
+		return err
+	}
 	return db.Remove(keySnapshotFile, txn)
 }
 
 /*
 Returns the date unix timestamp of the last snapshot
 */
-func GetSnapshotLock(txn *badger.Txn) int {
+func GetSnapshotLock(txn db.Txn) int {
 	timestamp, err := db.GetInt(keySnapshotLock, txn)
 	if err != nil {
 		return -1
@@ -117,7 +123,7 @@ func GetSnapshotLock(txn *badger.Txn) int {
 /*
 Returns the date unix timestamp of the last snapshot
 */
-func GetSnapshotTimestamp(txn *badger.Txn) int {
+func GetSnapshotTimestamp(txn db.Txn) int {
 	if CurrentTimestamp > 0 {
 		return CurrentTimestamp
 	}
@@ -132,7 +138,7 @@ func GetSnapshotTimestamp(txn *badger.Txn) int {
 /*
 Returns the date unix timestamp of the last snapshot
 */
-func GetSnapshotFileLock(txn *badger.Txn) string {
+func GetSnapshotFileLock(txn db.Txn) string {
 	filename, err := db.GetString(keySnapshotFile, txn)
 	if err != nil {
 		return ""
@@ -146,15 +152,25 @@ Starts a periodic snapshot runner
 func startAutosnapshots() {
 	snapshotPeriod := config.GetInt("snapshots.period")
 	snapshotInterval := config.GetInt("snapshots.interval")
+	milestoneDepth := config.GetInt("snapshots.milestoneDepth")
 	if snapshotInterval == 0 {
 		return
 	}
-	logs.Log.Infof("Automatic snapshots will be done every %v hours, keeping the past %v hours.",
-		snapshotInterval, snapshotPeriod)
+	if milestoneDepth > 0 {
+		logs.Log.Infof("Automatic snapshots will be done every %v hours, keeping the past %v milestones.",
+			snapshotInterval, milestoneDepth)
+	} else {
+		logs.Log.Infof("Automatic snapshots will be done every %v hours, keeping the past %v hours.",
+			snapshotInterval, snapshotPeriod)
+	}
 	ticker := time.NewTicker(time.Duration(60*snapshotInterval) * time.Minute)
 	for range ticker.C {
 		logs.Log.Info("Starting automatic snapshot...")
 		if !InProgress {
+			if milestoneDepth > 0 {
+				MakeMilestoneSnapshot(getLatestMilestoneIndex()-milestoneDepth, "")
+				continue
+			}
 			timestamp := int(time.Now().Unix()) - (snapshotPeriod * 3600)
 			MakeSnapshot(timestamp, "")
 		} else {
@@ -163,3 +179,15 @@ func startAutosnapshots() {
 	}
 
 }
+
+func registerMetrics() {
+	metrics.AddGauge("hercules_snapshot_in_progress", "1 while a snapshot is being made or loaded", func() float64 {
+		if InProgress {
+			return 1
+		}
+		return 0
+	})
+	metrics.AddGauge("hercules_snapshot_timestamp_seconds", "Timestamp of the current snapshot", func() float64 {
+		return float64(CurrentTimestamp)
+	})
+}
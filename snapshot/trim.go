@@ -9,30 +9,14 @@ import (
 	"../db"
 	"../logs"
 	"../transaction"
-	"github.com/dgraph-io/badger"
 )
 
 func trimTXRunner() {
-	if config.GetBool("snapshots.keep") {
+	if config.GetBool("snapshots.keep") || config.GetBool("permanode.enabled") {
 		logs.Log.Notice("The trimmed transactions after the snapshots will be kept in the database.")
 		return
 	}
-	logs.Log.Debug("Loading trimmable TXs", len(edgeTransactions))
-	db.DB.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = false
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := []byte{db.KEY_EVENT_TRIM_PENDING}
-		total := 0
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			total++
-			hashKey := db.AsKey(it.Item().Key(), db.KEY_HASH)
-			edgeTransactions <- &hashKey
-		}
-		return nil
-	})
-	logs.Log.Debug("Loaded trimmable TXs", len(edgeTransactions))
+	scheduleTrimPending()
 	for hashKey := range edgeTransactions {
 		db.Locker.Lock()
 		db.Locker.Unlock()
@@ -45,50 +29,60 @@ func trimTXRunner() {
 }
 
 /*
-Removes all data from the database that is before a given timestamp
+Queues all transactions flagged for trimming. Pinned ones are kept flagged, so they are
+trimmed once the pin is removed.
+*/
+func scheduleTrimPending() {
+	logs.Log.Debug("Loading trimmable TXs", len(edgeTransactions))
+	var keys [][]byte
+	db.DB.View(func(txn db.Txn) error {
+		return txn.ForPrefix([]byte{db.KEY_EVENT_TRIM_PENDING}, false, func(key []byte, _ []byte) (bool, error) {
+			keys = append(keys, db.AsKey(key, db.KEY_HASH))
+			return true, nil
+		})
+	})
+	for i := range keys {
+		edgeTransactions <- &keys[i]
+	}
+	logs.Log.Debug("Loaded trimmable TXs", len(edgeTransactions))
+}
+
+/*
+Removes all data from the database that is before a given timestamp. For milestone snapshots,
+transactions confirmed by a later milestone are kept.
 */
 // TODO: (OPT) decrease transactions counter? What about confirmed? Not first priority now.
 // The counter can be treated as incremental counter of all TXs known plus received since start of the node.
-func trimData(timestamp int64) error {
+func trimData(timestamp int64, milestone *SnapshotMilestone) error {
 	var txs [][]byte
 	var total = 0
 	var found = 0
 
-	err := db.DB.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := []byte{db.KEY_TIMESTAMP}
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+	err := db.DB.View(func(txn db.Txn) error {
+		return txn.ForPrefix([]byte{db.KEY_TIMESTAMP}, true, func(k []byte, v []byte) (bool, error) {
 			total++
-			item := it.Item()
-			k := item.Key()
-			v, err := item.Value()
-			if err == nil {
-				var txTimestamp = 0
-				buf := bytes.NewBuffer(v)
-				dec := gob.NewDecoder(buf)
-				err := dec.Decode(&txTimestamp)
-				if err == nil {
-					// TODO: since the milestone timestamps are often zero, it might be a good idea to keep them..?
-					// Theoretically, they are not needed any longer. :-/
-					if int64(txTimestamp) <= timestamp {
-						key := db.AsKey(k, db.KEY_EVENT_TRIM_PENDING)
-						if !db.Has(key, txn) {
-							txs = append(txs, key)
-							found++
-						}
-					}
-				} else {
-					logs.Log.Error("Could not parse a TX timestamp value!")
-					return err
+			var txTimestamp = 0
+			buf := bytes.NewBuffer(v)
+			dec := gob.NewDecoder(buf)
+			err := dec.Decode(&txTimestamp)
+			if err != nil {
+				logs.Log.Error("Could not parse a TX timestamp value!")
+				return false, err
+			}
+			// TODO: since the milestone timestamps are often zero, it might be a good idea to keep them..?
+			// Theoretically, they are not needed any longer. :-/
+			if int64(txTimestamp) <= timestamp {
+				if milestone != nil && getConfirmationIndex(k, txn) > milestone.Index {
+					return true, nil
+				}
+				key := db.AsKey(k, db.KEY_EVENT_TRIM_PENDING)
+				if !db.Has(key, txn) {
+					txs = append(txs, key)
+					found++
 				}
-			} else {
-				logs.Log.Error("Could not get a TX timestamp value!")
-				return err
 			}
-		}
-		return nil
+			return true, nil
+		})
 	})
 	if err != nil {
 		return err
@@ -99,8 +93,8 @@ func trimData(timestamp int64) error {
 	for _, k := range txs {
 		err := db.Put(k, true, nil, txn)
 		if err != nil {
-			if err == badger.ErrTxnTooBig {
-				_ = txn.Commit(func(e error) {})
+			if err == db.ErrTxnTooBig {
+				_ = txn.Commit()
 				txn = db.DB.NewTransaction(true)
 				err := db.Put(k, true, nil, txn)
 				if err != nil {
@@ -113,7 +107,7 @@ func trimData(timestamp int64) error {
 		hashKey := db.AsKey(k, db.KEY_HASH)
 		edgeTransactions <- &hashKey
 	}
-	_ = txn.Commit(func(e error) {})
+	_ = txn.Commit()
 
 	return nil
 }
@@ -126,18 +120,27 @@ func trimTX(hashKey []byte) error {
 		trits := convert.BytesToTrits(txBytes)[:8019]
 		tx = transaction.TritsToTX(&trits, txBytes)
 	}
+	timestamp, _ := db.GetInt64(db.AsKey(hashKey, db.KEY_TIMESTAMP), nil)
 	//logs.Log.Debug("TRIMMING", hashKey)
-	return db.DB.Update(func(txn *badger.Txn) error {
+	return db.DB.Update(func(txn db.Txn) error {
+		if tx != nil && isPinned(hashKey, tx, txn) {
+			return nil
+		}
 		db.Remove(hashKey, txn)
 		db.Remove(db.AsKey(hashKey, db.KEY_EVENT_TRIM_PENDING), txn)
 		db.Remove(db.AsKey(hashKey, db.KEY_EVENT_CONFIRMATION_PENDING), txn)
+		db.Remove(db.AsKey(hashKey, db.KEY_EVENT_BUNDLE_PENDING), txn)
 		db.Remove(db.AsKey(hashKey, db.KEY_TIMESTAMP), txn)
 		db.Remove(db.AsKey(hashKey, db.KEY_BYTES), txn)
 		db.Remove(db.AsKey(hashKey, db.KEY_VALUE), txn)
 		db.Remove(db.AsKey(hashKey, db.KEY_ADDRESS_HASH), txn)
+		db.Remove(db.AsKey(hashKey, db.KEY_ARRIVAL_TIME), txn)
 		db.Remove(db.AsKey(hashKey, db.KEY_RELATION), txn)
 		db.Remove(db.AsKey(hashKey, db.KEY_CONFIRMED), txn)
+		db.Remove(db.AsKey(hashKey, db.KEY_CONFIRMATION), txn)
+		db.Remove(db.AsKey(hashKey, db.KEY_VALID_BUNDLE), txn)
 		db.Remove(db.AsKey(hashKey, db.KEY_MILESTONE), txn)
+		db.Remove(db.AsKey(hashKey, db.KEY_SOLID_MILESTONE), txn)
 		db.Remove(db.AsKey(hashKey, db.KEY_RELATION), txn)
 		if tx != nil {
 			db.Remove(append(db.GetByteKey(tx.TrunkTransaction, db.KEY_APPROVEE), hashKey...), txn)
@@ -145,6 +148,10 @@ func trimTX(hashKey []byte) error {
 			db.Remove(append(db.GetByteKey(tx.Bundle, db.KEY_BUNDLE), hashKey...), txn)
 			db.Remove(append(db.GetByteKey(tx.Tag, db.KEY_TAG), hashKey...), txn)
 			db.Remove(append(db.GetByteKey(tx.Address, db.KEY_ADDRESS), hashKey...), txn)
+			db.Remove(db.GetTimeKey([]byte{db.KEY_TIME}, timestamp, hashKey), txn)
+			db.Remove(db.GetTimeKey(db.GetByteKey(tx.Address, db.KEY_ADDRESS_TIME), timestamp, hashKey), txn)
+			db.Remove(db.GetTimeKey(db.GetByteKey(tx.Bundle, db.KEY_BUNDLE_TIME), timestamp, hashKey), txn)
+			db.Remove(db.GetTimeKey(db.GetByteKey(tx.Tag, db.KEY_TAG_TIME), timestamp, hashKey), txn)
 
 		}
 		return nil
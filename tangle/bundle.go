@@ -0,0 +1,241 @@
+package tangle
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"../convert"
+	"../db"
+	"../logs"
+	"../transaction"
+)
+
+const (
+	bundleCheckInterval = time.Duration(1) * time.Second
+	bundleBatchSize     = 1000
+
+	maxBundleAnalyzedTransactions = 10000
+)
+
+var errIncompleteBundle = errors.New("bundle is incomplete")
+
+/*
+Returns the transaction with the given key, without its hash, or nil if it is not known.
+*/
+func loadTX(key []byte, txn db.Txn) *transaction.FastTX {
+	txBytes, err := db.GetBytes(db.AsKey(key, db.KEY_BYTES), txn)
+	if err != nil {
+		return nil
+	}
+	trits := convert.BytesToTrits(txBytes)[:8019]
+	return transaction.TritsToFastTX(&trits, txBytes)
+}
+
+/*
+Returns the transactions of the bundle of the given tail, ordered by index, following the trunk transactions.
+*/
+func loadBundle(tail *transaction.FastTX, txn db.Txn) ([]*transaction.FastTX, error) {
+	txs := []*transaction.FastTX{tail}
+	tx := tail
+	for index := 1; index <= tail.LastIndex; index++ {
+		tx = loadTX(db.GetByteKey(tx.TrunkTransaction, db.KEY_HASH), txn)
+		if tx == nil || tx.CurrentIndex != index || !bytes.Equal(tx.Bundle, tail.Bundle) {
+			return nil, errIncompleteBundle
+		}
+		txs = append(txs, tx)
+	}
+	return txs, nil
+}
+
+/*
+Returns the keys of the transactions of a bundle loaded from the given tail.
+*/
+func getBundleKeys(tail []byte, txs []*transaction.FastTX) [][]byte {
+	keys := [][]byte{tail}
+	for _, tx := range txs[:len(txs)-1] {
+		keys = append(keys, db.GetByteKey(tx.TrunkTransaction, db.KEY_HASH))
+	}
+	return keys
+}
+
+/*
+Returns the tails of all bundles containing the given transaction, following the approvers
+that reference a transaction as trunk with the previous index of the same bundle.
+*/
+func findBundleTails(key []byte, tx *transaction.FastTX) [][]byte {
+	var tails [][]byte
+	type bundleEntry struct {
+		key []byte
+		tx  *transaction.FastTX
+	}
+	visited := make(map[string]bool)
+	stack := []bundleEntry{{key, tx}}
+	for len(stack) > 0 && len(visited) < maxBundleAnalyzedTransactions {
+		entry := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[string(entry.key)] {
+			continue
+		}
+		visited[string(entry.key)] = true
+		if entry.tx.CurrentIndex == 0 {
+			tails = append(tails, entry.key)
+			continue
+		}
+		for _, approver := range findApprovees(entry.key) {
+			approverTX := loadTX(approver, nil)
+			if approverTX != nil && approverTX.CurrentIndex == entry.tx.CurrentIndex-1 &&
+				bytes.Equal(approverTX.Bundle, entry.tx.Bundle) &&
+				bytes.Equal(db.GetByteKey(approverTX.TrunkTransaction, db.KEY_HASH), entry.key) {
+				stack = append(stack, bundleEntry{approver, approverTX})
+			}
+		}
+	}
+	return tails
+}
+
+/*
+Returns the recorded validity of the bundle of the given transaction and whether it is known.
+*/
+func getBundleValidity(key []byte, txn db.Txn) (bool, bool) {
+	var valid bool
+	err := db.Get(db.AsKey(key, db.KEY_VALID_BUNDLE), &valid, txn)
+	if err != nil {
+		return false, false
+	}
+	return valid, true
+}
+
+/*
+Validates the bundle of the given tail, if it is complete, and records the result for all its
+transactions. A transaction that is part of several bundles stays valid if one of them is valid.
+The spends of a valid bundle are tracked; the conflicts they cause are returned.
+*/
+func validateBundle(tail []byte, txn db.Txn) (bool, []*Conflict, error) {
+	tailTX := loadTX(tail, txn)
+	if tailTX == nil {
+		return false, nil, errIncompleteBundle
+	}
+	txs, err := loadBundle(tailTX, txn)
+	if err != nil {
+		return false, nil, err
+	}
+	err = transaction.ValidateBundle(txs)
+	valid := err == nil
+	if !valid {
+		logs.Log.Debugf("Invalid bundle %v: %v", convert.BytesToTrytes(tailTX.Bundle)[:81], err)
+	}
+	for _, key := range getBundleKeys(tail, txs) {
+		if wasValid, known := getBundleValidity(key, txn); known && wasValid && !valid {
+			continue
+		}
+		err := db.Put(db.AsKey(key, db.KEY_VALID_BUNDLE), valid, nil, txn)
+		if err != nil {
+			return false, nil, err
+		}
+	}
+	if !valid {
+		return false, nil, nil
+	}
+	conflicts, err := trackSpends(txs, txn)
+	return true, conflicts, err
+}
+
+/*
+Validates all complete bundles containing the given transaction. Returns whether one of them
+is valid, whether any of them is complete and the conflicts caused by their spends.
+*/
+func validateBundlesOf(key []byte, tx *transaction.FastTX, txn db.Txn) (bool, bool, []*Conflict, error) {
+	valid := false
+	complete := false
+	var conflicts []*Conflict
+	for _, tail := range findBundleTails(key, tx) {
+		tailValid, tailConflicts, err := validateBundle(tail, txn)
+		if err == errIncompleteBundle {
+			continue
+		} else if err != nil {
+			return false, false, nil, err
+		}
+		complete = true
+		valid = valid || tailValid
+		conflicts = append(conflicts, tailConflicts...)
+	}
+	return valid, complete, conflicts, nil
+}
+
+/*
+Returns whether the transaction is only part of invalid bundles. Bundles of value transactions
+that were not validated yet are validated now; incomplete ones are not considered invalid.
+*/
+func isInvalidBundle(key []byte, tx *transaction.FastTX, txn db.Txn) (bool, error) {
+	valid, known := getBundleValidity(key, txn)
+	if known || tx.Value == 0 {
+		return known && !valid, nil
+	}
+	valid, complete, _, err := validateBundlesOf(key, tx, txn)
+	return complete && !valid, err
+}
+
+func bundleOnLoad() {
+	logs.Log.Info("Starting bundle validation thread")
+	go startBundleThread()
+}
+
+/*
+Assembles the bundles of newly saved transactions. Each new transaction leaves a pending event,
+so that the bundles it completes are validated once, also after a restart.
+*/
+func startBundleThread() {
+	ticker := time.NewTicker(bundleCheckInterval)
+	for range ticker.C {
+		if lowEndDevice && len(srv.Incoming) > maxIncoming {
+			continue
+		}
+		db.Locker.Lock()
+		db.Locker.Unlock()
+
+		var keys [][]byte
+		_ = db.DB.View(func(txn db.Txn) error {
+			return txn.ForPrefix([]byte{db.KEY_EVENT_BUNDLE_PENDING}, false, func(key []byte, _ []byte) (bool, error) {
+				keys = append(keys, db.AsKey(key, db.KEY_HASH))
+				return len(keys) < bundleBatchSize, nil
+			})
+		})
+		for _, key := range keys {
+			var conflicts []*Conflict
+			err := db.DB.Update(func(txn db.Txn) error {
+				err := db.Remove(db.AsKey(key, db.KEY_EVENT_BUNDLE_PENDING), txn)
+				if err != nil {
+					return err
+				}
+				tx := loadTX(key, txn)
+				if tx == nil {
+					return nil
+				}
+				_, _, conflicts, err = validateBundlesOf(key, tx, txn)
+				return err
+			})
+			if err != nil {
+				logs.Log.Errorf("Could not validate the bundle of a transaction: %v", err)
+				continue
+			}
+			for _, conflict := range conflicts {
+				publishConflict(conflict)
+			}
+		}
+	}
+}
+
+/*
+Returns the transactions of the bundle of the given tail transaction hash, ordered by index.
+*/
+func LoadBundle(hash []byte) ([]*transaction.FastTX, error) {
+	tail := loadTX(db.GetByteKey(hash, db.KEY_HASH), nil)
+	if tail == nil {
+		return nil, errors.New("transaction not found")
+	}
+	if tail.CurrentIndex != 0 {
+		return nil, errors.New("transaction is not a tail transaction")
+	}
+	return loadBundle(tail, nil)
+}
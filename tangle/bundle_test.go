@@ -0,0 +1,125 @@
+package tangle
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"../convert"
+	"../db"
+)
+
+/*
+A hand-built transaction. Names are trytes, padded with 9s to hashes and addresses.
+*/
+type testTX struct {
+	name    string
+	address string
+	value   int64
+	index   int
+	last    int
+	bundle  string
+	trunk   string
+	branch  string
+}
+
+func getTestTrytes(name string) string {
+	return name + strings.Repeat("9", 81-len(name))
+}
+
+func getTestHashKey(name string) []byte {
+	return db.GetByteKey(convert.TrytesToBytes(getTestTrytes(name))[:49], db.KEY_HASH)
+}
+
+/*
+Saves the transaction like SaveTX does, as far as bundles, confirmations and walks need it.
+*/
+func saveTestTX(t *testing.T, tx testTX) []byte {
+	trits := make([]int, 8019)
+	copy(trits[6561:6804], convert.TrytesToTrits(getTestTrytes(tx.address)))
+	copy(trits[6804:6837], convert.IntToTrits(big.NewInt(tx.value), 33))
+	copy(trits[6993:7020], convert.IntToTrits(big.NewInt(int64(tx.index)), 27))
+	copy(trits[7020:7047], convert.IntToTrits(big.NewInt(int64(tx.last)), 27))
+	copy(trits[7047:7290], convert.TrytesToTrits(getTestTrytes(tx.bundle)))
+	copy(trits[7290:7533], convert.TrytesToTrits(getTestTrytes(tx.trunk)))
+	copy(trits[7533:7776], convert.TrytesToTrits(getTestTrytes(tx.branch)))
+
+	key := getTestHashKey(tx.name)
+	trunkKey := getTestHashKey(tx.trunk)
+	branchKey := getTestHashKey(tx.branch)
+	err := db.DB.Update(func(txn db.Txn) error {
+		err := db.Put(db.AsKey(key, db.KEY_BYTES), convert.TritsToBytes(trits), nil, txn)
+		if err == nil {
+			err = db.Put(db.AsKey(key, db.KEY_TIMESTAMP), 0, nil, txn)
+		}
+		if err == nil {
+			bundle := convert.TrytesToBytes(getTestTrytes(tx.bundle))[:49]
+			err = db.Put(append(db.GetByteKey(bundle, db.KEY_BUNDLE), key...), tx.index, nil, txn)
+		}
+		if err == nil {
+			err = db.Put(db.AsKey(key, db.KEY_RELATION), append(trunkKey, branchKey...), nil, txn)
+		}
+		if err == nil {
+			err = db.Put(append(db.AsKey(trunkKey, db.KEY_APPROVEE), key...), true, nil, txn)
+		}
+		if err == nil {
+			err = db.Put(append(db.AsKey(branchKey, db.KEY_APPROVEE), key...), false, nil, txn)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+/*
+Saves a confirmed transaction for the test transactions to approve, ending the walks.
+*/
+func saveTestConfirmedTX(t *testing.T, name string) []byte {
+	key := saveTestTX(t, testTX{name: name, bundle: name, trunk: name, branch: name})
+	if err := db.Put(db.AsKey(key, db.KEY_CONFIRMED), 0, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestInvalidBundleIsRecorded(t *testing.T) {
+	db.DB = db.NewMemoryStore()
+	confirmQueue = make(ConfirmQueue, 10)
+	saveTestConfirmedTX(t, "Z")
+	// The bundle hash does not match the transactions
+	tail := saveTestTX(t, testTX{name: "A", address: "X", index: 0, last: 1, bundle: "INVALID", trunk: "B", branch: "Z"})
+	second := saveTestTX(t, testTX{name: "B", address: "Y", index: 1, last: 1, bundle: "INVALID", trunk: "Z", branch: "Z"})
+
+	if _, known := getBundleValidity(tail, nil); known {
+		t.Fatal("Bundle validity is known before validation")
+	}
+	if newLedgerState().approve(tail) {
+		t.Error("Invalid bundle was approved")
+	}
+	for _, key := range [][]byte{tail, second} {
+		if valid, known := getBundleValidity(key, nil); !known || valid {
+			t.Errorf("Bundle validity is %v (known: %v), expected invalid", valid, known)
+		}
+	}
+
+	for i, key := range [][]byte{tail, second} {
+		if i > 0 && !db.Has(db.AsKey(key, db.KEY_EVENT_CONFIRMATION_PENDING), nil) {
+			t.Error("Confirmation wave did not continue through the invalid bundle")
+		}
+		err := db.DB.Update(func(txn db.Txn) error {
+			err, tx := confirm(key, 5, txn)
+			if tx != nil {
+				t.Error("Transaction of an invalid bundle was confirmed")
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if db.Has(db.AsKey(key, db.KEY_CONFIRMED), nil) {
+			t.Error("Transaction of an invalid bundle is marked as confirmed")
+		}
+	}
+}
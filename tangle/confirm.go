@@ -2,6 +2,7 @@ package tangle
 
 import (
 	"bytes"
+	"sync/atomic"
 	"time"
 
 	"../convert"
@@ -9,7 +10,6 @@ import (
 	"../logs"
 	"../snapshot"
 	"../transaction"
-	"github.com/dgraph-io/badger"
 	"github.com/pkg/errors"
 	"sync"
 	"encoding/gob"
@@ -21,9 +21,15 @@ const UNKNOWN_CHECK_INTERVAL = time.Duration(60) * time.Second
 type PendingConfirmation struct {
 	key []byte
 	timestamp int
+	milestoneIndex int
 }
 type ConfirmQueue chan PendingConfirmation
 
+type Confirmation struct {
+	MilestoneIndex int
+	Time           int64
+}
+
 var confirmLocker = &sync.RWMutex{}
 var confirmsInProgress map[string]bool
 var confirmQueue ConfirmQueue
@@ -41,33 +47,23 @@ func confirmOnLoad() {
 }
 
 func loadPendingConfirmations() {
-	_ = db.DB.View(func(txn *badger.Txn) (e error) {
-		opts := badger.DefaultIteratorOptions
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := []byte{db.KEY_EVENT_CONFIRMATION_PENDING}
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			item := it.Item()
-			key := item.Key()
-			value, err := item.Value()
-			if err != nil {
-				logs.Log.Error("Couldn't load pending confirmation key", key)
-				continue
-			}
-			var timestamp int
+	_ = db.DB.View(func(txn db.Txn) (e error) {
+		return txn.ForPrefix([]byte{db.KEY_EVENT_CONFIRMATION_PENDING}, true, func(key []byte, value []byte) (bool, error) {
+			var pending []int
 			buf := bytes.NewBuffer(value)
 			dec := gob.NewDecoder(buf)
-			err = dec.Decode(&timestamp)
-			if err != nil {
+			err := dec.Decode(&pending)
+			if err != nil || len(pending) < 2 {
 				logs.Log.Error("Couldn't load pending confirmation key value", key, err)
-				continue
+				return true, nil
 			}
 			confirmQueue <- PendingConfirmation{
 				db.AsKey(key, db.KEY_EVENT_CONFIRMATION_PENDING),
-				timestamp,
+				pending[0],
+				pending[1],
 			}
-		}
-		return nil
+			return true, nil
+		})
 	})
 }
 
@@ -80,20 +76,25 @@ func startConfirmThread() {
 		pendingConfirmation := <- confirmQueue
 		db.Locker.Lock()
 		db.Locker.Unlock()
-		confirmed := false
-		err := db.DB.Update(func(txn *badger.Txn) error {
+		var confirmed *transaction.FastTX
+		err := db.DB.Update(func(txn db.Txn) error {
+			// Removed by a ledger rollback or already done
+			if !db.Has(pendingConfirmation.key, txn) {
+				return nil
+			}
 			if !addConfirmInProgress(pendingConfirmation.key) {
 				return nil
 			}
-			err, c := confirm(pendingConfirmation.key, txn)
-			confirmed = c
+			err, tx := confirm(pendingConfirmation.key, pendingConfirmation.milestoneIndex, txn)
+			confirmed = tx
 			removeConfirmInProgress(pendingConfirmation.key)
 			return err
 		})
 		if err != nil || db.Has(pendingConfirmation.key, nil) {
 			confirmQueue <- pendingConfirmation
-		} else if confirmed {
-			totalConfirmations++
+		} else if confirmed != nil {
+			atomic.AddInt64(&totalConfirmations, 1)
+			publishConfirmation(confirmed, pendingConfirmation.milestoneIndex)
 		}
 	}
 }
@@ -101,26 +102,24 @@ func startConfirmThread() {
 func startUnknownVerificationThread() {
 	flushTicker := time.NewTicker(UNKNOWN_CHECK_INTERVAL)
 	for range flushTicker.C {
-		_ = db.DB.View(func(txn *badger.Txn) error {
-			opts := badger.DefaultIteratorOptions
-			it := txn.NewIterator(opts)
-			defer it.Close()
-			prefix := []byte{db.KEY_PENDING_CONFIRMED}
+		_ = db.DB.View(func(txn db.Txn) error {
 			var toRemove [][]byte
-			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-				key := it.Item().Key()
+			var milestoneIndexes []int
+			txn.ForPrefix([]byte{db.KEY_PENDING_CONFIRMED}, true, func(key []byte, value []byte) (bool, error) {
 				if db.Has(db.AsKey(key, db.KEY_HASH), txn) {
-					k := make([]byte, len(key))
-					copy(k, key)
-					toRemove = append(toRemove, k)
+					var milestoneIndex = 0
+					gob.NewDecoder(bytes.NewBuffer(value)).Decode(&milestoneIndex)
+					toRemove = append(toRemove, key)
+					milestoneIndexes = append(milestoneIndexes, milestoneIndex)
 				}
-			}
-			for _, key := range toRemove {
+				return true, nil
+			})
+			for i, key := range toRemove {
 				logs.Log.Debug("Removing orphaned pending confirmed key", key)
-				err := db.DB.Update(func(txn *badger.Txn) error {
+				err := db.DB.Update(func(txn db.Txn) error {
 					err := db.Remove(key, txn)
 					if err != nil { return err }
-					return confirmChild(db.AsKey(key, db.KEY_HASH), txn)
+					return confirmChild(db.AsKey(key, db.KEY_HASH), milestoneIndexes[i], txn)
 				})
 				if err != nil { return err }
 			}
@@ -129,18 +128,22 @@ func startUnknownVerificationThread() {
 	}
 }
 
-func confirm(key []byte, txn *badger.Txn) (error, bool) {
+/*
+Confirms the transaction as part of the confirmation wave of the given milestone (0 if unknown)
+and queues its trunk and branch.
+*/
+func confirm(key []byte, milestoneIndex int, txn db.Txn) (error, *transaction.FastTX) {
 	db.Remove(db.AsKey(key, db.KEY_EVENT_CONFIRMATION_PENDING), txn)
 
 	if db.Has(db.AsKey(key, db.KEY_CONFIRMED), txn) {
-		return nil, false
+		return nil, nil
 	}
 
 	data, err := db.GetBytes(db.AsKey(key, db.KEY_BYTES), txn)
 	if err != nil {
 		// Imminent database inconsistency: Warn!
 		// logs.Log.Error("TX missing for confirmation. Probably snapshotted. DB inconsistency imminent!", key)
-		return errors.New("TX  missing for confirmation!"), false
+		return errors.New("TX  missing for confirmation!"), nil
 	}
 	trits := convert.BytesToTrits(data)[:8019]
 	var tx = transaction.TritsToFastTX(&trits, data)
@@ -150,43 +153,93 @@ func confirm(key []byte, txn *badger.Txn) (error, bool) {
 			tx.Timestamp,
 			snapshot.GetSnapshotTimestamp(txn),
 			convert.BytesToTrytes(tx.Hash))
-		return nil, false
+		return nil, nil
+	}
+
+	invalid, err := isInvalidBundle(key, tx, txn)
+	if err != nil {
+		logs.Log.Errorf("Could not validate the bundle: %v", err)
+		return errors.New("Could not validate the bundle!"), nil
+	}
+	if invalid {
+		// The transaction is not applied, but the confirmation wave continues through it
+		logs.Log.Warningf("Skipping confirmation of a TX of the invalid bundle %v", convert.BytesToTrytes(tx.Bundle)[:81])
+		err = confirmChild(db.GetByteKey(tx.TrunkTransaction, db.KEY_HASH), milestoneIndex, txn)
+		if err != nil {
+			return err, nil
+		}
+		return confirmChild(db.GetByteKey(tx.BranchTransaction, db.KEY_HASH), milestoneIndex, txn), nil
 	}
 
 	err = db.Put(db.AsKey(key, db.KEY_CONFIRMED), tx.Timestamp, nil, txn)
 
 	if err != nil {
 		logs.Log.Errorf("Could not save confirmation status!", err)
-		return errors.New("Could not save confirmation status!"), false
+		return errors.New("Could not save confirmation status!"), nil
+	}
+
+	// An unknown confirming milestone is recorded as 0
+	err = db.Put(db.AsKey(key, db.KEY_CONFIRMATION), []int64{int64(milestoneIndex), time.Now().Unix()}, nil, txn)
+	if err != nil {
+		logs.Log.Errorf("Could not save confirmation milestone: %v", err)
+		return errors.New("Could not save confirmation milestone!"), nil
+	}
+	// The balance change is still reverted by a rollback to any earlier milestone
+	ledgerIndex := milestoneIndex
+	if ledgerIndex <= 0 {
+		ledgerIndex = getLatestMilestone().Index
+	}
+	err = saveLedgerDiff(key, tx, ledgerIndex, txn)
+	if err != nil {
+		logs.Log.Errorf("Could not save ledger diff: %v", err)
+		return errors.New("Could not save ledger diff!"), nil
 	}
 
 	if tx.Value != 0 {
 		_, err := db.IncrBy(db.GetAddressKey(tx.Address, db.KEY_BALANCE), tx.Value, false, txn)
 		if err != nil {
 			logs.Log.Errorf("Could not update account balance: %v", err)
-			return errors.New("Could not update account balance!"), false
+			return errors.New("Could not update account balance!"), nil
 		}
 		if tx.Value < 0 {
 			err := db.Put(db.GetAddressKey(tx.Address, db.KEY_SPENT), true, nil, txn)
 			if err != nil {
 				logs.Log.Errorf("Could not update account spent status: %v", err)
-				return errors.New("Could not update account spent status!"), false
+				return errors.New("Could not update account spent status!"), nil
+			}
+			err = removePendingSpend(tx, txn)
+			if err != nil {
+				logs.Log.Errorf("Could not remove pending spend: %v", err)
+				return errors.New("Could not remove pending spend!"), nil
 			}
 		}
 	}
 
-	err = confirmChild(db.GetByteKey(tx.TrunkTransaction, db.KEY_HASH), txn)
+	err = confirmChild(db.GetByteKey(tx.TrunkTransaction, db.KEY_HASH), milestoneIndex, txn)
 	if err != nil {
-		return err, false
+		return err, nil
 	}
-	err = confirmChild(db.GetByteKey(tx.BranchTransaction, db.KEY_HASH), txn)
+	err = confirmChild(db.GetByteKey(tx.BranchTransaction, db.KEY_HASH), milestoneIndex, txn)
 	if err != nil {
-		return err, false
+		return err, nil
+	}
+	return nil, tx
+}
+
+/*
+Returns the index of the milestone that confirmed the transaction (0 if unknown) and the local unix time
+of the confirmation, or nil if the transaction is not confirmed or was confirmed before this was recorded.
+*/
+func GetConfirmation(key []byte, txn db.Txn) *Confirmation {
+	var confirmation []int64
+	err := db.Get(db.AsKey(key, db.KEY_CONFIRMATION), &confirmation, txn)
+	if err != nil || len(confirmation) < 2 {
+		return nil
 	}
-	return nil, true
+	return &Confirmation{int(confirmation[0]), confirmation[1]}
 }
 
-func confirmChild(key []byte, txn *badger.Txn) error {
+func confirmChild(key []byte, milestoneIndex int, txn db.Txn) error {
 	if bytes.Equal(key, tipHashKey) {
 		return nil
 	}
@@ -196,13 +249,13 @@ func confirmChild(key []byte, txn *badger.Txn) error {
 	timestamp, err := db.GetInt(db.AsKey(key, db.KEY_TIMESTAMP), txn)
 	k := db.AsKey(key, db.KEY_EVENT_CONFIRMATION_PENDING)
 	if err == nil && !hasConfirmInProgress(k) {
-		err = addPendingConfirmation(k, timestamp, txn)
+		err = addPendingConfirmation(k, timestamp, milestoneIndex, txn)
 		if err != nil {
 			logs.Log.Errorf("Could not save child confirm status: %v", err)
 			return errors.New("Could not save child confirm status!")
 		}
 	} else if !db.Has(db.AsKey(key, db.KEY_EDGE), txn) && db.Has(db.AsKey(key, db.KEY_PENDING_HASH), txn) {
-		err = db.Put(db.AsKey(key, db.KEY_PENDING_CONFIRMED), int(time.Now().Unix()), nil, txn)
+		err = db.Put(db.AsKey(key, db.KEY_PENDING_CONFIRMED), milestoneIndex, nil, txn)
 		if err != nil {
 			logs.Log.Errorf("Could not save child pending confirm status: %v", err)
 			return errors.New("Could not save child pending confirm status!")
@@ -211,10 +264,10 @@ func confirmChild(key []byte, txn *badger.Txn) error {
 	return nil
 }
 
-func addPendingConfirmation(key []byte, timestamp int, txn *badger.Txn) error {
-	err := db.Put(db.AsKey(key, db.KEY_EVENT_CONFIRMATION_PENDING), timestamp, nil, txn)
+func addPendingConfirmation(key []byte, timestamp int, milestoneIndex int, txn db.Txn) error {
+	err := db.Put(db.AsKey(key, db.KEY_EVENT_CONFIRMATION_PENDING), []int{timestamp, milestoneIndex}, nil, txn)
 	if err == nil {
-		confirmQueue <- PendingConfirmation{key, timestamp}
+		confirmQueue <- PendingConfirmation{key, timestamp, milestoneIndex}
 	}
 	return err
 }
@@ -251,20 +304,14 @@ func removeConfirmInProgress (key []byte) {
 
 func reapplyConfirmed() {
 	logs.Log.Debug("Reapplying confirmed TXs to balances")
-	db.DB.View(func(txn *badger.Txn) (e error) {
-		opts := badger.DefaultIteratorOptions
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := []byte{db.KEY_CONFIRMED}
+	db.DB.View(func(txn db.Txn) (e error) {
 		x := 0
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			item := it.Item()
-			key := item.Key()
+		return txn.ForPrefix([]byte{db.KEY_CONFIRMED}, false, func(key []byte, _ []byte) (bool, error) {
 			txBytes, _ := db.GetBytes(db.AsKey(key, db.KEY_BYTES), txn)
 			trits := convert.BytesToTrits(txBytes)[:8019]
 			tx := transaction.TritsToFastTX(&trits, txBytes)
 			if tx.Value != 0 {
-				err := db.DB.Update(func(txn *badger.Txn) (e error) {
+				err := db.DB.Update(func(txn db.Txn) (e error) {
 					_, err := db.IncrBy(db.GetAddressKey(tx.Address, db.KEY_BALANCE), tx.Value, false, txn)
 					if err != nil {
 						logs.Log.Errorf("Could not update account balance: %v", err)
@@ -281,14 +328,14 @@ func reapplyConfirmed() {
 				})
 				if err != nil {
 					logs.Log.Errorf("Could not apply tx Value: %v", err)
-					return errors.New("Could not apply Tx value!")
+					return false, errors.New("Could not apply Tx value!")
 				}
 			}
 			x = x + 1
 			if x % 10000 == 0 {
 				logs.Log.Debug("Progress", x)
 			}
-		}
-		return nil
+			return true, nil
+		})
 	})
 }
\ No newline at end of file
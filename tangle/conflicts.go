@@ -0,0 +1,277 @@
+package tangle
+
+import (
+	"bytes"
+	"encoding/gob"
+	"strings"
+	"time"
+
+	"../convert"
+	"../db"
+	"../events"
+	"../logs"
+	"../transaction"
+)
+
+/*
+A double spend: bundles spending from the same address more than its balance together.
+The bundles are kept once detected, also after one of them was confirmed.
+*/
+type Conflict struct {
+	Address []byte
+	Bundles []ConflictingBundle
+	Time    int64
+}
+
+/*
+A bundle of a conflict and the amount it spends from the address. Confirmed is only set when queried.
+*/
+type ConflictingBundle struct {
+	Bundle    []byte
+	Value     int64
+	Confirmed bool
+}
+
+type pendingSpend struct {
+	Bundle []byte
+	Value  int64
+}
+
+func getPendingSpendKey(address []byte, bundle []byte) []byte {
+	return append(db.GetAddressKey(address, db.KEY_PENDING_SPEND), db.GetByteKey(bundle, db.KEY_BUNDLE)...)
+}
+
+/*
+Records the spends of a valid bundle and returns the conflicts they cause or extend.
+*/
+func trackSpends(txs []*transaction.FastTX, txn db.Txn) ([]*Conflict, error) {
+	spends := make(map[string]int64)
+	for _, tx := range txs {
+		if tx.Value < 0 {
+			spends[string(tx.Address)] -= tx.Value
+		}
+	}
+	var conflicts []*Conflict
+	for address, value := range spends {
+		err := db.Put(getPendingSpendKey([]byte(address), txs[0].Bundle), pendingSpend{txs[0].Bundle, value}, nil, txn)
+		if err != nil {
+			return nil, err
+		}
+		conflict, err := updateConflict([]byte(address), txn)
+		if err != nil {
+			return nil, err
+		}
+		if conflict != nil {
+			conflicts = append(conflicts, conflict)
+		}
+	}
+	return conflicts, nil
+}
+
+/*
+Removes the recorded spend of a bundle from the address, when it gets confirmed.
+*/
+func removePendingSpend(tx *transaction.FastTX, txn db.Txn) error {
+	return db.Remove(getPendingSpendKey(tx.Address, tx.Bundle), txn)
+}
+
+/*
+Checks whether the unconfirmed spends from the address exceed its balance. Spends of confirmed or
+removed bundles are dropped. Returns the conflict if it is new or got new bundles, otherwise nil.
+*/
+func updateConflict(address []byte, txn db.Txn) (*Conflict, error) {
+	var recorded []db.KeyValue
+	err := txn.ForPrefix(db.GetAddressKey(address, db.KEY_PENDING_SPEND), true, func(key []byte, value []byte) (bool, error) {
+		recorded = append(recorded, db.KeyValue{Key: append([]byte{}, key...), Value: append([]byte{}, value...)})
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var spends []pendingSpend
+	for _, entry := range recorded {
+		var spend pendingSpend
+		err := gob.NewDecoder(bytes.NewBuffer(entry.Value)).Decode(&spend)
+		if err != nil {
+			return nil, err
+		}
+		if exists, confirmed := getBundleState(spend.Bundle, txn); exists && !confirmed {
+			spends = append(spends, spend)
+			continue
+		}
+		err = db.Remove(entry.Key, txn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(spends) < 2 {
+		return nil, nil
+	}
+
+	var total int64 = 0
+	for _, spend := range spends {
+		total += spend.Value
+	}
+	balance, err := db.GetInt64(db.GetAddressKey(address, db.KEY_BALANCE), txn)
+	if err != nil {
+		balance = 0
+	}
+	if total <= balance {
+		return nil, nil
+	}
+
+	conflict := getConflict(address, txn)
+	if conflict == nil {
+		conflict = &Conflict{address, nil, time.Now().Unix()}
+	}
+	extended := false
+	for _, spend := range spends {
+		if !conflict.has(spend.Bundle) {
+			conflict.Bundles = append(conflict.Bundles, ConflictingBundle{spend.Bundle, spend.Value, false})
+			extended = true
+		}
+	}
+	if !extended {
+		return nil, nil
+	}
+	err = db.Put(db.GetAddressKey(address, db.KEY_CONFLICT), conflict, nil, txn)
+	if err != nil {
+		return nil, err
+	}
+	return conflict, nil
+}
+
+/*
+Returns whether transactions of the bundle are known and whether one of them is confirmed.
+*/
+func getBundleState(bundle []byte, txn db.Txn) (bool, bool) {
+	exists := false
+	confirmed := false
+	_ = txn.ForPrefix(db.GetByteKey(bundle, db.KEY_BUNDLE), false, func(key []byte, _ []byte) (bool, error) {
+		exists = true
+		confirmed = db.Has(db.AsKey(key[16:], db.KEY_CONFIRMED), txn)
+		return !confirmed, nil
+	})
+	return exists, confirmed
+}
+
+func getConflict(address []byte, txn db.Txn) *Conflict {
+	var conflict Conflict
+	err := db.Get(db.GetAddressKey(address, db.KEY_CONFLICT), &conflict, txn)
+	if err != nil {
+		return nil
+	}
+	return &conflict
+}
+
+func (conflict *Conflict) has(bundle []byte) bool {
+	for _, b := range conflict.Bundles {
+		if bytes.Equal(b.Bundle, bundle) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Returns the conflicts of the given addresses, or all conflicts if none are given,
+with the confirmation status of their bundles.
+*/
+func GetConflicts(addresses [][]byte) ([]*Conflict, error) {
+	var conflicts []*Conflict
+	err := db.DB.View(func(txn db.Txn) error {
+		if len(addresses) > 0 {
+			for _, address := range addresses {
+				if conflict := getConflict(address, txn); conflict != nil {
+					conflicts = append(conflicts, conflict)
+				}
+			}
+		} else {
+			err := txn.ForPrefix([]byte{db.KEY_CONFLICT}, true, func(_ []byte, value []byte) (bool, error) {
+				var conflict Conflict
+				err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(&conflict)
+				if err != nil {
+					return false, err
+				}
+				conflicts = append(conflicts, &conflict)
+				return true, nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for _, conflict := range conflicts {
+			for i := range conflict.Bundles {
+				_, conflict.Bundles[i].Confirmed = getBundleState(conflict.Bundles[i].Bundle, txn)
+			}
+		}
+		return nil
+	})
+	return conflicts, err
+}
+
+/*
+Removes the conflicts of which no bundle is known anymore, e.g. after a snapshot.
+*/
+func pruneConflicts() {
+	var keys [][]byte
+	_ = db.DB.View(func(txn db.Txn) error {
+		return txn.ForPrefix([]byte{db.KEY_CONFLICT}, true, func(key []byte, value []byte) (bool, error) {
+			var conflict Conflict
+			if gob.NewDecoder(bytes.NewBuffer(value)).Decode(&conflict) != nil {
+				return true, nil
+			}
+			for _, b := range conflict.Bundles {
+				if exists, _ := getBundleState(b.Bundle, txn); exists {
+					return true, nil
+				}
+			}
+			keys = append(keys, append([]byte{}, key...))
+			return true, nil
+		})
+	})
+	for _, key := range keys {
+		err := db.Remove(key, nil)
+		if err != nil {
+			logs.Log.Errorf("Could not remove a conflict: %v", err)
+			return
+		}
+	}
+}
+
+/*
+Publishes a detected double spend: "conflict <address> <bundle>,<bundle>..." with all bundles known so far.
+*/
+func publishConflict(conflict *Conflict) {
+	logs.Log.Warningf("Double spend detected from address %v (%v bundles)",
+		convert.BytesToTrytes(conflict.Address)[:81], len(conflict.Bundles))
+	if !events.HasSubscribers() {
+		return
+	}
+	var bundles []string
+	for _, b := range conflict.Bundles {
+		bundles = append(bundles, convert.BytesToTrytes(b.Bundle)[:81])
+	}
+	events.Publish("conflict %v %v", convert.BytesToTrytes(conflict.Address)[:81], strings.Join(bundles, ","))
+}
+
+/*
+Returns whether the bundle spends from an address in conflict with one of the given bundles.
+*/
+func conflictsWith(txs []*transaction.FastTX, bundles map[string]bool) bool {
+	for _, tx := range txs {
+		if tx.Value >= 0 {
+			continue
+		}
+		conflict := getConflict(tx.Address, nil)
+		if conflict == nil || !conflict.has(tx.Bundle) {
+			continue
+		}
+		for _, b := range conflict.Bundles {
+			if !bytes.Equal(b.Bundle, tx.Bundle) && bundles[string(b.Bundle)] {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package tangle
+
+import (
+	"testing"
+
+	"../convert"
+	"../db"
+)
+
+/*
+Saves a bundle moving the value from one address to another, recorded as valid.
+*/
+func saveTestTransfer(t *testing.T, tail string, second string, bundle string, from string, to string, value int64) []byte {
+	tailKey := saveTestTX(t, testTX{name: tail, address: from, value: -value, index: 0, last: 1, bundle: bundle, trunk: second, branch: "Z"})
+	secondKey := saveTestTX(t, testTX{name: second, address: to, value: value, index: 1, last: 1, bundle: bundle, trunk: "Z", branch: "Z"})
+	for _, key := range [][]byte{tailKey, secondKey} {
+		if err := db.Put(db.AsKey(key, db.KEY_VALID_BUNDLE), true, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tailKey
+}
+
+func TestDoubleSpendConflict(t *testing.T) {
+	db.DB = db.NewMemoryStore()
+	saveTestConfirmedTX(t, "Z")
+	address := convert.TrytesToBytes(getTestTrytes("X"))[:49]
+	if err := db.Put(db.GetAddressKey(address, db.KEY_BALANCE), int64(10), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	first := saveTestTransfer(t, "A", "B", "ONE", "X", "Y", 10)
+	second := saveTestTransfer(t, "C", "D", "TWO", "X", "W", 10)
+
+	var conflicts []*Conflict
+	for _, tail := range [][]byte{first, second} {
+		err := db.DB.Update(func(txn db.Txn) error {
+			bundle, err := loadBundle(loadTX(tail, txn), txn)
+			if err != nil {
+				return err
+			}
+			tailConflicts, err := trackSpends(bundle, txn)
+			conflicts = append(conflicts, tailConflicts...)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(conflicts) != 1 || len(conflicts[0].Bundles) != 2 {
+		t.Fatalf("Got conflicts %v, expected one with both bundles", conflicts)
+	}
+	conflict := getConflict(address, nil)
+	if conflict == nil || !conflict.has(convert.TrytesToBytes(getTestTrytes("ONE"))[:49]) ||
+		!conflict.has(convert.TrytesToBytes(getTestTrytes("TWO"))[:49]) {
+		t.Errorf("Recorded conflict is %v", conflict)
+	}
+
+	if !newLedgerState().approve(second) {
+		t.Error("Second bundle was not approved on its own")
+	}
+	state := newLedgerState()
+	if !state.approve(first) {
+		t.Fatal("First bundle was not approved")
+	}
+	if state.approve(second) {
+		t.Error("Second bundle was approved together with the first one")
+	}
+}
@@ -0,0 +1,245 @@
+package tangle
+
+import (
+	"bytes"
+	"errors"
+
+	"../convert"
+	"../db"
+	"../transaction"
+)
+
+const (
+	infoNotSolid      = "tails are not solid (missing a referenced tx): "
+	infoNotConsistent = "tails are not consistent (would lead to inconsistent ledger state or below max depth)"
+)
+
+/*
+Checks whether the given tail transactions are solid, not below max depth and do not lead
+to an inconsistent ledger state together. Returns the state and, if it is false, the reason
+in the same wording as IRI. Unknown or non-tail transactions are returned as error.
+*/
+func CheckConsistency(hashes [][]byte) (bool, string, error) {
+	var keys [][]byte
+	for _, hash := range hashes {
+		key := db.GetByteKey(hash, db.KEY_HASH)
+		txBytes, err := db.GetBytes(db.AsKey(key, db.KEY_BYTES), nil)
+		if err != nil {
+			return false, "", errors.New("Invalid transaction, missing: " + convert.BytesToTrytes(hash)[:81])
+		}
+		trits := convert.BytesToTrits(txBytes)[:8019]
+		if transaction.TritsToFastTX(&trits, txBytes).CurrentIndex != 0 {
+			return false, "", errors.New("Invalid transaction, not a tail: " + convert.BytesToTrytes(hash)[:81])
+		}
+		keys = append(keys, key)
+	}
+
+	missing, found := findMissingInPastCone(keys)
+	if found {
+		hash := ""
+		if missing != nil {
+			hash = convert.BytesToTrytes(missing)[:81]
+		}
+		return false, infoNotSolid + hash, nil
+	}
+
+	check := newDepthCheck(MaxTipselDepth)
+	state := newLedgerState()
+	for _, key := range keys {
+		if check.isBelowMaxDepth(key) || !state.approve(key) {
+			return false, infoNotConsistent, nil
+		}
+	}
+	return true, "", nil
+}
+
+/*
+Returns whether a transaction is missing in the unconfirmed past cone of the given transactions and its hash.
+*/
+func findMissingInPastCone(keys [][]byte) ([]byte, bool) {
+	var missing []byte
+	found := false
+	walkUnconfirmedPastCone(keys, nil, func(key []byte, relation []byte, parent []byte) bool {
+		if relation == nil {
+			missing = getMissingHash(key, parent)
+			found = true
+			return false
+		}
+		return true
+	})
+	return missing, found
+}
+
+/*
+Balance changes of the unconfirmed transactions approved together, e.g. by a tip selection walk.
+The values are applied per bundle, when its tail transaction is approved. The bundle hashes of the
+spending bundles are kept to avoid approving both sides of a known double spend.
+*/
+type ledgerState struct {
+	approved map[string]bool
+	changes  map[string]int64
+	spending map[string]bool
+}
+
+func newLedgerState() *ledgerState {
+	return &ledgerState{make(map[string]bool), make(map[string]int64), make(map[string]bool)}
+}
+
+/*
+Adds the unconfirmed past cone of the given transaction to the state. Returns false, leaving the
+state unchanged, if the past cone is not solid, contains an incomplete or invalid bundle or would lead
+to a negative balance or a double spend together with the transactions approved before.
+*/
+func (state *ledgerState) approve(key []byte) bool {
+	var visited [][]byte
+	changes := make(map[string]int64)
+	spending := make(map[string]bool)
+	valid := true
+	walkUnconfirmedPastCone([][]byte{key}, state.approved, func(key []byte, relation []byte, _ []byte) bool {
+		tx := loadTX(key, nil)
+		if relation == nil || tx == nil {
+			valid = false
+			return false
+		}
+		visited = append(visited, key)
+		if tx.CurrentIndex != 0 {
+			return true
+		}
+		bundleValid, known := getBundleValidity(key, nil)
+		if !known {
+			bundleValid = validateTail(key)
+		}
+		if !bundleValid {
+			valid = false
+			return false
+		}
+		if tx.LastIndex == 0 && tx.Value == 0 {
+			return true
+		}
+		bundle, err := loadBundle(tx, nil)
+		if err != nil || conflictsWith(bundle, state.spending) || conflictsWith(bundle, spending) {
+			valid = false
+			return false
+		}
+		for _, btx := range bundle {
+			if btx.Value != 0 {
+				changes[string(btx.Address)] += btx.Value
+			}
+			if btx.Value < 0 {
+				spending[string(btx.Bundle)] = true
+			}
+		}
+		return true
+	})
+	if !valid {
+		return false
+	}
+
+	for address, change := range changes {
+		total := state.changes[address] + change
+		if change >= 0 || total >= 0 {
+			continue
+		}
+		balance, err := db.GetInt64(db.GetAddressKey([]byte(address), db.KEY_BALANCE), nil)
+		if err != nil {
+			balance = 0
+		}
+		if balance+total < 0 {
+			return false
+		}
+	}
+
+	for address, change := range changes {
+		state.changes[address] += change
+	}
+	for bundle := range spending {
+		state.spending[bundle] = true
+	}
+	for _, key := range visited {
+		state.approved[string(key)] = true
+	}
+	return true
+}
+
+/*
+Validates the bundle of a tail that was not validated yet and records the result,
+so that the following walks do not validate it again.
+*/
+func validateTail(tail []byte) bool {
+	valid := false
+	var conflicts []*Conflict
+	err := db.DB.Update(func(txn db.Txn) (err error) {
+		valid, conflicts, err = validateBundle(tail, txn)
+		return err
+	})
+	if err != nil {
+		return false
+	}
+	for _, conflict := range conflicts {
+		publishConflict(conflict)
+	}
+	return valid
+}
+
+/*
+Calls fn for every unconfirmed transaction referenced by the given ones, including themselves,
+with its relation (trunk and branch keys), or nil if the transaction is missing, and the key of the
+transaction referencing it. Stops if fn returns false. Confirmed, milestone and snapshotted
+transactions end the walk, as do the known ones, if given.
+*/
+func walkUnconfirmedPastCone(keys [][]byte, known map[string]bool, fn func(key []byte, relation []byte, parent []byte) bool) {
+	seen := make(map[string]bool)
+	parents := make(map[string][]byte)
+	stack := append([][]byte{}, keys...)
+	for len(stack) > 0 {
+		key := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[string(key)] || known[string(key)] || bytes.Equal(key, tipHashKey) {
+			continue
+		}
+		seen[string(key)] = true
+
+		if db.Has(db.AsKey(key, db.KEY_CONFIRMED), nil) ||
+			db.Has(db.AsKey(key, db.KEY_MILESTONE), nil) ||
+			db.Has(db.AsKey(key, db.KEY_SNAPSHOTTED), nil) ||
+			db.Has(db.AsKey(key, db.KEY_EVENT_TRIM_PENDING), nil) {
+			continue
+		}
+		relation, err := db.GetBytes(db.AsKey(key, db.KEY_RELATION), nil)
+		if err != nil {
+			relation = nil
+		}
+		if !fn(key, relation, parents[string(key)]) {
+			return
+		}
+		if relation != nil {
+			trunk := db.AsKey(relation[:16], db.KEY_HASH)
+			branch := db.AsKey(relation[16:], db.KEY_HASH)
+			for _, k := range [][]byte{trunk, branch} {
+				if _, ok := parents[string(k)]; !ok {
+					parents[string(k)] = key
+				}
+			}
+			stack = append(stack, trunk, branch)
+		}
+	}
+}
+
+/*
+Returns the hash of a missing transaction, read from the transaction referencing it.
+*/
+func getMissingHash(key []byte, parent []byte) []byte {
+	if parent == nil {
+		return nil
+	}
+	txBytes, err := db.GetBytes(db.AsKey(parent, db.KEY_BYTES), nil)
+	if err != nil {
+		return nil
+	}
+	trits := convert.BytesToTrits(txBytes)[:8019]
+	tx := transaction.TritsToFastTX(&trits, txBytes)
+	if bytes.Equal(db.GetByteKey(tx.TrunkTransaction, db.KEY_HASH), key) {
+		return tx.TrunkTransaction
+	}
+	return tx.BranchTransaction
+}
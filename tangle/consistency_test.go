@@ -0,0 +1,74 @@
+package tangle
+
+import (
+	"testing"
+
+	"../convert"
+	"../db"
+)
+
+/*
+Saves a zero-value tail transaction, recorded as a valid bundle.
+*/
+func saveTestValidTail(t *testing.T, name string, trunk string, branch string) []byte {
+	key := saveTestTX(t, testTX{name: name, bundle: name, trunk: trunk, branch: branch})
+	if err := db.Put(db.AsKey(key, db.KEY_VALID_BUNDLE), true, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func getTestHashes(names ...string) [][]byte {
+	var hashes [][]byte
+	for _, name := range names {
+		hashes = append(hashes, convert.TrytesToBytes(getTestTrytes(name))[:49])
+	}
+	return hashes
+}
+
+func TestCheckConsistency(t *testing.T) {
+	db.DB = db.NewMemoryStore()
+	latest := LatestSolidMilestone
+	LatestSolidMilestone = Milestone{tipFastTX, 20}
+	defer func() { LatestSolidMilestone = latest }()
+
+	// Z is confirmed within max depth, OLD is not
+	saveTestConfirmation(t, "Z", 20, 0)
+	saveTestConfirmation(t, "OLD", 20-MaxTipselDepth-1, 0)
+	address := convert.TrytesToBytes(getTestTrytes("X"))[:49]
+	if err := db.Put(db.GetAddressKey(address, db.KEY_BALANCE), int64(10), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	saveTestTransfer(t, "A", "B", "ONE", "X", "Y", 10)
+	saveTestTransfer(t, "C", "D", "TWO", "X", "W", 10)
+	saveTestValidTail(t, "E", "Z", "Z")
+	saveTestValidTail(t, "NOTSOLID", "MISSING", "Z")
+	saveTestValidTail(t, "BELOW", "E", "OLD")
+
+	cases := []struct {
+		tails      []string
+		consistent bool
+		info       string
+	}{
+		{[]string{"NOTSOLID", "E"}, false, infoNotSolid + getTestTrytes("MISSING")},
+		{[]string{"BELOW"}, false, infoNotConsistent},
+		{[]string{"A", "C"}, false, infoNotConsistent},
+		{[]string{"A", "E"}, true, ""},
+		{[]string{"C"}, true, ""},
+	}
+	for _, c := range cases {
+		consistent, info, err := CheckConsistency(getTestHashes(c.tails...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if consistent != c.consistent || info != c.info {
+			t.Errorf("%v: consistency is %v '%v', expected %v '%v'", c.tails, consistent, info, c.consistent, c.info)
+		}
+	}
+
+	for _, name := range []string{"B", "UNKNOWN"} {
+		if _, _, err := CheckConsistency(getTestHashes(name)); err == nil {
+			t.Errorf("%v was checked as a tail", name)
+		}
+	}
+}
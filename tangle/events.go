@@ -0,0 +1,72 @@
+package tangle
+
+import (
+	"time"
+
+	"../convert"
+	"../events"
+	"../transaction"
+)
+
+/*
+Publishes a new transaction: "tx" and "tx_trytes" messages, same layout as the IRI ZMQ feed.
+*/
+func publishTransaction(tx *transaction.FastTX) {
+	if !events.HasSubscribers() {
+		return
+	}
+	trytes := convert.BytesToTrytes(tx.Bytes)[:2673]
+	hash := convert.BytesToTrytes(tx.Hash)[:81]
+	lastIndex := convert.TritsToInt(convert.TrytesToTrits(trytes[2340:2349])).Int64()
+
+	events.Publish("tx %v %v %v %v %v %v %v %v %v %v %v %v",
+		hash,
+		trytes[2187:2268], // address
+		tx.Value,
+		trytes[2295:2322], // obsolete tag
+		tx.TXTimestamp,
+		tx.CurrentIndex,
+		lastIndex,
+		trytes[2349:2430], // bundle
+		trytes[2430:2511], // trunk
+		trytes[2511:2592], // branch
+		time.Now().Unix(),
+		trytes[2592:2619]) // tag
+	events.Publish("tx_trytes %v %v", trytes, hash)
+}
+
+/*
+Publishes a confirmed transaction: "sn" message and a message on the topic of its address,
+with the index of the confirming milestone. If it is unknown, the latest solid milestone is used.
+*/
+func publishConfirmation(tx *transaction.FastTX, milestoneIndex int) {
+	if !events.HasSubscribers() {
+		return
+	}
+	trits := convert.BytesToTrits(tx.Bytes)[:8019]
+	tx = transaction.TritsToTX(&trits, tx.Bytes)
+	index := milestoneIndex
+	if index <= 0 {
+		index = getLatestSolidMilestone().Index
+	}
+	hash := convert.BytesToTrytes(tx.Hash)[:81]
+	address := convert.BytesToTrytes(tx.Address)[:81]
+
+	events.Publish("%v %v %v sn", address, hash, index)
+	events.Publish("sn %v %v %v %v %v %v",
+		index,
+		hash,
+		address,
+		convert.BytesToTrytes(tx.TrunkTransaction)[:81],
+		convert.BytesToTrytes(tx.BranchTransaction)[:81],
+		convert.BytesToTrytes(tx.Bundle)[:81])
+}
+
+func publishLatestMilestone(previous int, milestone Milestone) {
+	events.Publish("lmi %v %v", previous, milestone.Index)
+}
+
+func publishLatestSolidMilestone(previous int, milestone Milestone) {
+	events.Publish("lmsi %v %v", previous, milestone.Index)
+	events.Publish("lmhs %v", convert.BytesToTrytes(milestone.TX.Hash)[:81])
+}
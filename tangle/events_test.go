@@ -0,0 +1,29 @@
+package tangle
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"../db"
+	"../events"
+)
+
+func TestConfirmationCarriesConfirmingMilestone(t *testing.T) {
+	db.DB = db.NewMemoryStore()
+	key := saveTestTX(t, testTX{name: "A", address: "X", bundle: "A", trunk: "Z", branch: "Z"})
+	tx := loadTX(key, nil)
+	latest := LatestSolidMilestone
+	LatestSolidMilestone = Milestone{tipFastTX, 20}
+	defer func() { LatestSolidMilestone = latest }()
+
+	subscriber := events.Subscribe("sn")
+	defer subscriber.Close()
+	for _, c := range []struct{ milestoneIndex, published int }{{15, 15}, {0, 20}} {
+		publishConfirmation(tx, c.milestoneIndex)
+		message := <-subscriber.Messages
+		if !strings.HasPrefix(message, "sn "+strconv.Itoa(c.published)+" ") {
+			t.Errorf("Published '%v' for milestone %v", message, c.milestoneIndex)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package tangle
+
+import (
+	"encoding/binary"
+
+	"../convert"
+	"../db"
+	"../logs"
+	"../transaction"
+)
+
+const historyIndexBatchSize = 1000
+
+var permanode = false
+var historyIndexReady = false
+var historyIndexCursorKey = db.GetByteKey([]byte("historyIndexCursor"), db.KEY_OTHER)
+var historyIndexDoneKey = db.GetByteKey([]byte("historyIndexDone"), db.KEY_OTHER)
+
+/*
+Transaction found in a time-ordered index.
+*/
+type HistoryEntry struct {
+	Hash      []byte
+	Timestamp int64
+}
+
+/*
+Returns whether the node keeps all transactions and maintains the time-ordered indexes.
+*/
+func IsPermanode() bool {
+	return permanode
+}
+
+/*
+Returns whether the time-ordered indexes cover all transactions in the database.
+They are built in the background when the permanode mode is enabled on an existing database.
+*/
+func IsHistoryIndexReady() bool {
+	return historyIndexReady
+}
+
+func historyOnLoad() {
+	permanode = config.GetBool("permanode.enabled")
+	if !permanode {
+		return
+	}
+	logs.Log.Notice("Permanode mode: all transactions are kept and indexed by time")
+	historyIndexReady = db.Has(historyIndexDoneKey, nil) || db.Count(db.KEY_HASH) == 0
+	if historyIndexReady {
+		db.Put(historyIndexDoneKey, true, nil, nil)
+	} else {
+		go buildHistoryIndexes()
+	}
+}
+
+func saveHistoryIndexes(key []byte, tx *transaction.FastTX, txn db.Txn) error {
+	timestamp := int64(tx.Timestamp)
+	for _, indexKey := range [][]byte{
+		db.GetTimeKey([]byte{db.KEY_TIME}, timestamp, key),
+		db.GetTimeKey(db.GetByteKey(tx.Address, db.KEY_ADDRESS_TIME), timestamp, key),
+		db.GetTimeKey(db.GetByteKey(tx.Bundle, db.KEY_BUNDLE_TIME), timestamp, key),
+		db.GetTimeKey(db.GetByteKey(tx.Tag, db.KEY_TAG_TIME), timestamp, key),
+	} {
+		err := db.PutBytes(indexKey, []byte{}, nil, txn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Indexes the transactions saved before the permanode mode was enabled. The last indexed
+transaction is saved with every batch, so the indexing continues after a restart.
+*/
+func buildHistoryIndexes() {
+	logs.Log.Info("Building the time-ordered transaction indexes in the background...")
+	start := []byte{db.KEY_TIMESTAMP}
+	cursor, err := db.GetBytes(historyIndexCursorKey, nil)
+	if err == nil {
+		start = cursor
+	}
+	total := 0
+
+	for {
+		var keys [][]byte
+		db.DB.View(func(txn db.Txn) error {
+			return txn.ForRange([]byte{db.KEY_TIMESTAMP}, start, false, func(key []byte, _ []byte) (bool, error) {
+				if cursor != nil && string(key) == string(cursor) {
+					return true, nil
+				}
+				keys = append(keys, key)
+				return len(keys) < historyIndexBatchSize, nil
+			})
+		})
+		if len(keys) == 0 {
+			break
+		}
+
+		err := db.DB.Update(func(txn db.Txn) error {
+			for _, key := range keys {
+				hashKey := db.AsKey(key, db.KEY_HASH)
+				txBytes, err := db.GetBytes(db.AsKey(key, db.KEY_BYTES), txn)
+				if err != nil {
+					continue
+				}
+				trits := convert.BytesToTrits(txBytes)[:8019]
+				err = saveHistoryIndexes(hashKey, transaction.TritsToFastTX(&trits, txBytes), txn)
+				if err != nil {
+					return err
+				}
+			}
+			return db.Put(historyIndexCursorKey, keys[len(keys)-1], nil, txn)
+		})
+		if err != nil {
+			logs.Log.Error("Could not build the time-ordered indexes. Restart to continue.", err)
+			return
+		}
+		cursor = keys[len(keys)-1]
+		start = cursor
+		total += len(keys)
+		logs.Log.Infof("Time-ordered indexes: %v transactions indexed", total)
+	}
+
+	err = db.DB.Update(func(txn db.Txn) error {
+		err := db.Put(historyIndexDoneKey, true, nil, txn)
+		if err != nil {
+			return err
+		}
+		return db.Remove(historyIndexCursorKey, txn)
+	})
+	if err != nil {
+		logs.Log.Error("Could not finish the time-ordered indexes", err)
+		return
+	}
+	historyIndexReady = true
+	logs.Log.Notice("Time-ordered indexes built")
+}
+
+/*
+Returns up to limit transactions of the index with the given 16-bytes prefix (e.g. an address
+hash with KEY_ADDRESS_TIME), from the given timestamp to the given timestamp (inclusive), oldest first.
+Pass the returned next key as start to get the next page. It is nil on the last page.
+*/
+func FindInTimeRange(prefix []byte, from int64, to int64, start []byte, limit int) ([]HistoryEntry, []byte, error) {
+	first := db.GetTimeKey(prefix, from, nil)
+	if start != nil && string(start) > string(first) {
+		first = start
+	}
+	var entries []HistoryEntry
+	var next []byte
+	err := db.DB.View(func(txn db.Txn) error {
+		return txn.ForRange(prefix, first, false, func(key []byte, _ []byte) (bool, error) {
+			timestamp := int64(binary.BigEndian.Uint64(key[len(prefix) : len(prefix)+8]))
+			if timestamp > to {
+				return false, nil
+			}
+			if len(entries) >= limit {
+				next = key
+				return false, nil
+			}
+			hash, err := db.GetBytes(db.AsKey(key[len(prefix)+8:], db.KEY_HASH), txn)
+			if err == nil {
+				entries = append(entries, HistoryEntry{hash, timestamp})
+			}
+			return true, nil
+		})
+	})
+	return entries, next, err
+}
+
+/*
+Returns the oldest transaction kept in the database, or nil if the history is not indexed.
+*/
+func GetOldestTransaction() *HistoryEntry {
+	if !permanode {
+		return nil
+	}
+	entries, _, err := FindInTimeRange([]byte{db.KEY_TIME}, 0, int64(^uint64(0)>>1), nil, 1)
+	if err != nil || len(entries) == 0 {
+		return nil
+	}
+	return &entries[0]
+}
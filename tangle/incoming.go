@@ -9,11 +9,11 @@ import (
 	"../crypt"
 	"../db"
 	"../logs"
+	"../network"
 	"../server"
 	"../snapshot"
 	"../transaction"
 	"../utils"
-	"github.com/dgraph-io/badger"
 )
 
 const P_TIP_REPLY = 25
@@ -30,7 +30,7 @@ func incomingRunner() {
 		req := make([]byte, 49)
 		copy(req, raw.Msg[1604:1650])
 
-		incoming++
+		atomic.AddInt64(&incoming, 1)
 
 		db.Locker.Lock()
 		db.Locker.Unlock()
@@ -44,16 +44,18 @@ func incomingRunner() {
 			hash = tx.Hash
 
 			if !bytes.Equal(data, tipBytes) {
-				if !crypt.IsValidPoW(tx.Hash, MWM) {
+				if !crypt.IsValidPoW(tx.Hash, network.Current.MWM) {
 					server.NeighborTrackingQueue <- &server.NeighborTrackingMessage{IPAddressWithPort: raw.IPAddressWithPort, Invalid: 1}
 				} else {
 					err := processIncomingTX(IncomingTX{TX: tx, IPAddressWithPort: raw.IPAddressWithPort, Bytes: &data})
 					if err == nil {
-						incomingProcessed++
+						atomic.AddInt64(&incomingProcessed, 1)
 						addFingerprint(fingerprint)
 					}
 				}
 			}
+		} else if !bytes.Equal(data, tipBytes) {
+			server.NeighborTrackingQueue <- &server.NeighborTrackingMessage{IPAddressWithPort: raw.IPAddressWithPort, Duplicate: 1}
 		}
 
 		// Pause for a while without responding to prevent flooding
@@ -85,10 +87,13 @@ func incomingRunner() {
 func processIncomingTX(incoming IncomingTX) error {
 	tx := incoming.TX
 	var pendingMilestone *PendingMilestone
-	err := db.DB.Update(func(txn *badger.Txn) (e error) {
+	isNew := false
+	err := db.DB.Update(func(txn db.Txn) (e error) {
 		// TODO: catch error defer here
 		var key = db.GetByteKey(tx.Hash, db.KEY_HASH)
-		removePendingRequest(tx.Hash)
+		if removePendingRequest(tx.Hash) {
+			server.NeighborTrackingQueue <- &server.NeighborTrackingMessage{IPAddressWithPort: incoming.IPAddressWithPort, Fulfilled: 1}
+		}
 
 		removeTx := func() {
 			//logs.Log.Debugf("Skipping this TX: %v", convert.BytesToTrytes(tx.Hash)[:81])
@@ -106,7 +111,7 @@ func processIncomingTX(incoming IncomingTX) error {
 
 		snapTime := snapshot.GetSnapshotTimestamp(txn)
 		futureTime := int(time.Now().Add(time.Duration(2) * time.Hour).Unix())
-		maybeMilestonePair := isMaybeMilestone(tx) || isMaybeMilestonePair(tx)
+		maybeMilestonePair := isMaybeMilestonePart(tx)
 		isOutsideOfTimeframe := !maybeMilestonePair && (tx.Timestamp > futureTime || snapTime >= tx.Timestamp)
 		if isOutsideOfTimeframe && !db.Has(db.GetByteKey(tx.Bundle, db.KEY_PENDING_BUNDLE), txn) {
 			// If the bundle is still not deleted, keep this TX. It might link to a pending TX...
@@ -123,9 +128,9 @@ func processIncomingTX(incoming IncomingTX) error {
 			_checkIncomingError(tx, err)
 			_, err = requestIfMissing(tx.BranchTransaction, incoming.IPAddressWithPort)
 			_checkIncomingError(tx, err)
-			err = addPendingConfirmation(db.GetByteKey(tx.TrunkTransaction, db.KEY_EVENT_CONFIRMATION_PENDING), tx.Timestamp, txn)
+			err = addPendingConfirmation(db.GetByteKey(tx.TrunkTransaction, db.KEY_EVENT_CONFIRMATION_PENDING), tx.Timestamp, 0, txn)
 			_checkIncomingError(tx, err)
-			err = addPendingConfirmation(db.GetByteKey(tx.BranchTransaction, db.KEY_EVENT_CONFIRMATION_PENDING), tx.Timestamp, txn)
+			err = addPendingConfirmation(db.GetByteKey(tx.BranchTransaction, db.KEY_EVENT_CONFIRMATION_PENDING), tx.Timestamp, 0, txn)
 			_checkIncomingError(tx, err)
 			/*logs.Log.Debugf("Got already snapshotted TX: %v, Value: %v",
 			convert.BytesToTrytes(tx.Hash)[:81], tx.Value) */
@@ -150,10 +155,11 @@ func processIncomingTX(incoming IncomingTX) error {
 			// EVENTS:
 
 			pendingConfirmationKey := db.AsKey(key, db.KEY_PENDING_CONFIRMED)
-			if db.Has(pendingConfirmationKey, txn) {
+			milestoneIndex, err := db.GetInt(pendingConfirmationKey, txn)
+			if err == nil {
 				err = db.Remove(pendingConfirmationKey, txn)
 				_checkIncomingError(tx, err)
-				err = addPendingConfirmation(db.AsKey(key, db.KEY_EVENT_CONFIRMATION_PENDING), tx.Timestamp, txn)
+				err = addPendingConfirmation(db.AsKey(key, db.KEY_EVENT_CONFIRMATION_PENDING), tx.Timestamp, milestoneIndex, txn)
 				_checkIncomingError(tx, err)
 			}
 
@@ -169,10 +175,12 @@ func processIncomingTX(incoming IncomingTX) error {
 			}
 
 			server.NeighborTrackingQueue <- &server.NeighborTrackingMessage{IPAddressWithPort: incoming.IPAddressWithPort, New: 1}
-			saved++
+			atomic.AddInt64(&saved, 1)
 			atomic.AddInt64(&totalTransactions, 1)
+			isNew = true
 		} else {
-			discarded++
+			atomic.AddInt64(&discarded, 1)
+			server.NeighborTrackingQueue <- &server.NeighborTrackingMessage{IPAddressWithPort: incoming.IPAddressWithPort, Duplicate: 1}
 		}
 		return nil
 	})
@@ -181,6 +189,9 @@ func processIncomingTX(incoming IncomingTX) error {
 		if pendingMilestone != nil {
 			addPendingMilestoneToQueue(pendingMilestone)
 		}
+		if isNew {
+			publishTransaction(tx)
+		}
 	} else {
 		addPendingRequest(tx.Hash, 0, incoming.IPAddressWithPort, true)
 
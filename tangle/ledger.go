@@ -0,0 +1,427 @@
+package tangle
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"../db"
+	"../logs"
+	"../snapshot"
+	"../transaction"
+)
+
+const (
+	ledgerRollbackWait    = time.Duration(3) * time.Second
+	ledgerPruneInterval   = time.Duration(1) * time.Hour
+	ledgerRevertBatchSize = 1000
+)
+
+var ledgerHistoryStartKey = db.GetByteKey([]byte("ledgerHistoryStart"), db.KEY_OTHER)
+var ledgerHistoryStart = 0
+var ledgerRollbackKey = db.GetByteKey([]byte("ledgerRollback"), db.KEY_OTHER)
+
+/*
+The ledger diffs are recorded from the milestone that was the latest one when they were introduced.
+Later, the diffs of milestones behind the snapshot are pruned.
+*/
+func ledgerOnLoad() {
+	start, err := db.GetInt(ledgerHistoryStartKey, nil)
+	if err != nil {
+		start = LatestMilestone.Index
+		err = db.Put(ledgerHistoryStartKey, start, nil, nil)
+		if err != nil {
+			logs.Log.Errorf("Could not save the ledger history start: %v", err)
+		}
+	}
+	ledgerHistoryStart = start
+	logs.Log.Infof("Ledger history available from milestone %v", ledgerHistoryStart)
+	go startLedgerPruner()
+}
+
+/*
+Returns the oldest milestone index for which balances can be queried.
+*/
+func GetLedgerHistoryStart() int {
+	return ledgerHistoryStart
+}
+
+/*
+Records the transaction and its balance change in the diff of the milestone confirming it.
+Must be called before the spent status of the address is updated.
+*/
+func saveLedgerDiff(key []byte, tx *transaction.FastTX, milestoneIndex int, txn db.Txn) error {
+	err := db.PutBytes(db.GetMilestoneKey(db.KEY_LEDGER_CONFIRMED, milestoneIndex, key), []byte{}, nil, txn)
+	if err != nil || tx.Value == 0 {
+		return err
+	}
+	_, err = db.IncrBy(db.GetMilestoneKey(db.KEY_LEDGER_DIFF, milestoneIndex, tx.Address), tx.Value, false, txn)
+	if err != nil {
+		return err
+	}
+	if tx.Value < 0 && !db.Has(db.GetAddressKey(tx.Address, db.KEY_SPENT), txn) {
+		return db.PutBytes(db.GetMilestoneKey(db.KEY_LEDGER_SPENT, milestoneIndex, tx.Address), []byte{}, nil, txn)
+	}
+	return nil
+}
+
+/*
+Returns the balances of the given addresses after the given milestone: the current balances
+minus the changes of all later milestones.
+*/
+func GetBalancesAt(addresses [][]byte, milestoneIndex int) ([]int64, error) {
+	latest := getLatestMilestone().Index
+	if milestoneIndex > latest {
+		return nil, fmt.Errorf("milestone %v is not known yet, the latest milestone is %v", milestoneIndex, latest)
+	}
+	if milestoneIndex < ledgerHistoryStart {
+		return nil, fmt.Errorf("the ledger history starts at milestone %v", ledgerHistoryStart)
+	}
+
+	balances := make([]int64, len(addresses))
+	err := db.DB.View(func(txn db.Txn) error {
+		for i, address := range addresses {
+			balance, err := db.GetInt64(db.GetAddressKey(address, db.KEY_BALANCE), txn)
+			if err != nil && err != db.ErrKeyNotFound {
+				return err
+			}
+			for index := milestoneIndex + 1; index <= latest; index++ {
+				change, err := db.GetInt64(db.GetMilestoneKey(db.KEY_LEDGER_DIFF, index, address), txn)
+				if err == nil {
+					balance -= change
+				} else if err != db.ErrKeyNotFound {
+					return err
+				}
+			}
+			balances[i] = balance
+		}
+		return nil
+	})
+	return balances, err
+}
+
+/*
+Reverts the balances, spent addresses and confirmations of all milestones after the given one.
+These milestones are verified again afterwards, so that valid ones confirm their transactions again,
+while a milestone that should not have been accepted is discarded.
+*/
+func RollbackLedger(milestoneIndex int) error {
+	latest := getLatestMilestone().Index
+	if milestoneIndex >= latest {
+		return fmt.Errorf("milestone %v is not older than the latest milestone %v", milestoneIndex, latest)
+	}
+	if milestoneIndex < ledgerHistoryStart {
+		return fmt.Errorf("the ledger history starts at milestone %v", ledgerHistoryStart)
+	}
+	if snapshot.InProgress {
+		return fmt.Errorf("a snapshot is in progress")
+	}
+	target, ok := getMilestoneKeysFrom(milestoneIndex)[milestoneIndex]
+	if !ok {
+		return fmt.Errorf("milestone %v is not known", milestoneIndex)
+	}
+	timestamp, err := db.GetInt(db.AsKey(target, db.KEY_TIMESTAMP), nil)
+	if err != nil || timestamp <= snapshot.GetSnapshotTimestamp(nil) {
+		return fmt.Errorf("milestone %v is behind the snapshot", milestoneIndex)
+	}
+
+	db.Locker.Lock()
+	defer db.Locker.Unlock()
+
+	// Give time for running confirmations to finalize
+	time.Sleep(ledgerRollbackWait)
+
+	// Marks the rollback as in progress, so that it is resumed if the node stops before it is done
+	err = db.Put(ledgerRollbackKey, []int{milestoneIndex, latest}, nil, nil)
+	if err != nil {
+		logs.Log.Errorf("Could not save the ledger rollback: %v", err)
+		return err
+	}
+	rolledBack, err := rollbackLedger(milestoneIndex, latest)
+	if err != nil {
+		return err
+	}
+
+	loadLatestMilestone()
+	loadLatestSolidMilestone()
+	for _, pendingMilestone := range rolledBack {
+		addPendingMilestoneToQueue(pendingMilestone)
+	}
+	return nil
+}
+
+/*
+Finishes a ledger rollback that was interrupted. Must be called before the milestones are loaded:
+the rolled back milestones are verified again by the milestone checker.
+*/
+func resumeLedgerRollback() {
+	var rollback []int
+	err := db.Get(ledgerRollbackKey, &rollback, nil)
+	if err != nil || len(rollback) < 2 {
+		return
+	}
+	logs.Log.Warningf("Resuming the interrupted ledger rollback to milestone %v", rollback[0])
+
+	db.Locker.Lock()
+	defer db.Locker.Unlock()
+
+	_, err = rollbackLedger(rollback[0], rollback[1])
+	if err != nil {
+		logs.Log.Panicf("Could not resume the ledger rollback. The database is in an unstable state! %v", err)
+	}
+}
+
+/*
+Reverts the milestones after the given one down from the latest one and returns them as pending
+milestones. Each step can be repeated, so that an interrupted rollback can be resumed.
+*/
+func rollbackLedger(milestoneIndex int, latest int) ([]*PendingMilestone, error) {
+	logs.Log.Noticef("Rolling back the ledger from milestone %v to %v", latest, milestoneIndex)
+	err := purgePendingConfirmations(milestoneIndex)
+	if err != nil {
+		logs.Log.Errorf("Could not remove the pending confirmations: %v", err)
+		return nil, err
+	}
+
+	milestoneKeys := getMilestoneKeysFrom(milestoneIndex + 1)
+	var rolledBack []*PendingMilestone
+	for index := latest; index > milestoneIndex; index-- {
+		for _, prefix := range []byte{db.KEY_LEDGER_DIFF, db.KEY_LEDGER_SPENT, db.KEY_LEDGER_CONFIRMED} {
+			err := revertLedgerEntries(db.GetMilestoneKey(prefix, index, nil))
+			if err != nil {
+				logs.Log.Errorf("Could not roll back milestone %v: %v", index, err)
+				return nil, err
+			}
+		}
+		key, ok := milestoneKeys[index]
+		if !ok {
+			continue
+		}
+		var pendingMilestone *PendingMilestone
+		err := db.DB.Update(func(txn db.Txn) (err error) {
+			pendingMilestone, err = rollbackMilestone(key, txn)
+			return err
+		})
+		if err != nil {
+			logs.Log.Errorf("Could not roll back milestone %v: %v", index, err)
+			return nil, err
+		}
+		rolledBack = append(rolledBack, pendingMilestone)
+	}
+
+	err = db.Remove(ledgerRollbackKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	logs.Log.Noticef("Ledger rolled back to milestone %v", milestoneIndex)
+	return rolledBack, nil
+}
+
+/*
+Removes the milestone and saves it as pending milestone in the same transaction,
+so that it is verified again even if the rollback is interrupted.
+*/
+func rollbackMilestone(key []byte, txn db.Txn) (*PendingMilestone, error) {
+	tx := getMilestoneTX(key, txn)
+	if tx == nil {
+		return nil, errors.New("milestone transaction not found")
+	}
+	err := db.Remove(db.AsKey(key, db.KEY_MILESTONE), txn)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Remove(db.AsKey(key, db.KEY_SOLID_MILESTONE), txn)
+	if err != nil {
+		return nil, err
+	}
+	trunkBytesKey := db.GetByteKey(tx.TrunkTransaction, db.KEY_BYTES)
+	err = db.PutBytes(db.AsKey(key, db.KEY_EVENT_MILESTONE_PENDING), trunkBytesKey, nil, txn)
+	if err != nil {
+		return nil, err
+	}
+	return &PendingMilestone{key, trunkBytesKey}, nil
+}
+
+/*
+Removes the pending confirmations of the milestones after the given one. The confirmation
+threads skip the queued ones that are not in the database anymore.
+*/
+func purgePendingConfirmations(milestoneIndex int) error {
+	var keys [][]byte
+	err := db.DB.View(func(txn db.Txn) error {
+		err := txn.ForPrefix([]byte{db.KEY_EVENT_CONFIRMATION_PENDING}, true, func(key []byte, value []byte) (bool, error) {
+			var pending []int
+			err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(&pending)
+			if err == nil && len(pending) > 1 && pending[1] > milestoneIndex {
+				keys = append(keys, key)
+			}
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+		return txn.ForPrefix([]byte{db.KEY_PENDING_CONFIRMED}, true, func(key []byte, value []byte) (bool, error) {
+			var index = 0
+			err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(&index)
+			if err == nil && index > milestoneIndex {
+				keys = append(keys, key)
+			}
+			return true, nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > ledgerRevertBatchSize {
+			batch = batch[:ledgerRevertBatchSize]
+		}
+		err := db.DB.Update(func(txn db.Txn) error {
+			for _, key := range batch {
+				err := db.Remove(key, txn)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		keys = keys[len(batch):]
+	}
+	return nil
+}
+
+/*
+Reverts and removes the ledger entries with the given prefix (type and milestone index) in batches.
+*/
+func revertLedgerEntries(prefix []byte) error {
+	for {
+		var entries []db.KeyValue
+		err := db.DB.View(func(txn db.Txn) error {
+			return txn.ForPrefix(prefix, true, func(key []byte, value []byte) (bool, error) {
+				entries = append(entries, db.KeyValue{Key: key, Value: append([]byte{}, value...)})
+				return len(entries) < ledgerRevertBatchSize, nil
+			})
+		})
+		if err != nil || len(entries) == 0 {
+			return err
+		}
+
+		var unconfirmed int64 = 0
+		err = db.DB.Update(func(txn db.Txn) error {
+			for _, entry := range entries {
+				suffix := entry.Key[len(prefix):]
+				var err error
+				switch prefix[0] {
+				case db.KEY_LEDGER_DIFF:
+					var change int64
+					err = gob.NewDecoder(bytes.NewBuffer(entry.Value)).Decode(&change)
+					if err == nil {
+						_, err = db.IncrBy(db.GetAddressKey(suffix, db.KEY_BALANCE), -change, false, txn)
+					}
+				case db.KEY_LEDGER_SPENT:
+					err = db.Remove(db.GetAddressKey(suffix, db.KEY_SPENT), txn)
+				case db.KEY_LEDGER_CONFIRMED:
+					err = db.Remove(db.AsKey(suffix, db.KEY_CONFIRMED), txn)
+					if err == nil {
+						err = db.Remove(db.AsKey(suffix, db.KEY_CONFIRMATION), txn)
+					}
+					unconfirmed++
+				}
+				if err != nil {
+					return err
+				}
+				err = db.Remove(entry.Key, txn)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		atomic.AddInt64(&totalConfirmations, -unconfirmed)
+	}
+}
+
+func startLedgerPruner() {
+	ticker := time.NewTicker(ledgerPruneInterval)
+	for range ticker.C {
+		db.Locker.Lock()
+		db.Locker.Unlock()
+		if snapshot.InProgress {
+			continue
+		}
+		pruneLedgerHistory()
+		pruneConflicts()
+	}
+}
+
+/*
+Removes the ledger entries of the milestones behind the snapshot, which cannot be rolled back anymore.
+*/
+func pruneLedgerHistory() {
+	snapshotTimestamp := snapshot.GetSnapshotTimestamp(nil)
+	start := ledgerHistoryStart
+	for index, key := range getMilestoneKeysFrom(ledgerHistoryStart) {
+		timestamp, err := db.GetInt(db.AsKey(key, db.KEY_TIMESTAMP), nil)
+		if err == nil && timestamp <= snapshotTimestamp && index > start {
+			start = index
+		}
+	}
+	if start == ledgerHistoryStart {
+		return
+	}
+
+	for index := ledgerHistoryStart; index <= start; index++ {
+		for _, prefix := range []byte{db.KEY_LEDGER_DIFF, db.KEY_LEDGER_SPENT, db.KEY_LEDGER_CONFIRMED} {
+			err := removeLedgerEntries(db.GetMilestoneKey(prefix, index, nil))
+			if err != nil {
+				logs.Log.Errorf("Could not prune the ledger history of milestone %v: %v", index, err)
+				return
+			}
+		}
+	}
+	err := db.Put(ledgerHistoryStartKey, start, nil, nil)
+	if err != nil {
+		logs.Log.Errorf("Could not save the ledger history start: %v", err)
+		return
+	}
+	ledgerHistoryStart = start
+	logs.Log.Infof("Ledger history pruned, available from milestone %v", ledgerHistoryStart)
+}
+
+func removeLedgerEntries(prefix []byte) error {
+	for {
+		var keys [][]byte
+		err := db.DB.View(func(txn db.Txn) error {
+			return txn.ForPrefix(prefix, false, func(key []byte, _ []byte) (bool, error) {
+				keys = append(keys, key)
+				return len(keys) < ledgerRevertBatchSize, nil
+			})
+		})
+		if err != nil || len(keys) == 0 {
+			return err
+		}
+		err = db.DB.Update(func(txn db.Txn) error {
+			for _, key := range keys {
+				err := db.Remove(key, txn)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
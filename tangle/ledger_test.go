@@ -0,0 +1,146 @@
+package tangle
+
+import (
+	"testing"
+
+	"../db"
+	"../transaction"
+)
+
+func getTestAddress(b byte) []byte {
+	address := make([]byte, 49)
+	address[0] = b
+	return address
+}
+
+func TestSaveLedgerDiff(t *testing.T) {
+	db.DB = db.NewMemoryStore()
+	address := getTestAddress(1)
+
+	err := saveLedgerDiff(getTestKey("A"), &transaction.FastTX{Address: address, Value: -5}, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = saveLedgerDiff(getTestKey("B"), &transaction.FastTX{Address: address, Value: 3}, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = saveLedgerDiff(getTestKey("C"), &transaction.FastTX{Address: getTestAddress(2)}, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"A", "B", "C"} {
+		if !db.Has(db.GetMilestoneKey(db.KEY_LEDGER_CONFIRMED, 10, getTestKey(name)), nil) {
+			t.Errorf("Confirmation of %v is not recorded", name)
+		}
+	}
+	change, err := db.GetInt64(db.GetMilestoneKey(db.KEY_LEDGER_DIFF, 10, address), nil)
+	if err != nil || change != -2 {
+		t.Errorf("Balance change is %v (%v), expected -2", change, err)
+	}
+	if db.Has(db.GetMilestoneKey(db.KEY_LEDGER_DIFF, 10, getTestAddress(2)), nil) {
+		t.Error("Zero-value transaction recorded a balance change")
+	}
+	if !db.Has(db.GetMilestoneKey(db.KEY_LEDGER_SPENT, 10, address), nil) {
+		t.Error("Newly spent address is not recorded")
+	}
+}
+
+func TestGetBalancesAt(t *testing.T) {
+	db.DB = db.NewMemoryStore()
+	address := getTestAddress(1)
+	LatestMilestone = Milestone{nil, 12}
+	ledgerHistoryStart = 9
+
+	if err := db.Put(db.GetAddressKey(address, db.KEY_BALANCE), int64(103), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.IncrBy(db.GetMilestoneKey(db.KEY_LEDGER_DIFF, 11, address), 5, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.IncrBy(db.GetMilestoneKey(db.KEY_LEDGER_DIFF, 12, address), -2, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for index, expected := range map[int]int64{9: 100, 10: 100, 11: 105, 12: 103} {
+		balances, err := GetBalancesAt([][]byte{address, getTestAddress(2)}, index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if balances[0] != expected || balances[1] != 0 {
+			t.Errorf("Balances at milestone %v are %v, expected [%v 0]", index, balances, expected)
+		}
+	}
+	if _, err := GetBalancesAt([][]byte{address}, 13); err == nil {
+		t.Error("Balances of an unknown milestone were returned")
+	}
+	if _, err := GetBalancesAt([][]byte{address}, 8); err == nil {
+		t.Error("Balances before the ledger history were returned")
+	}
+}
+
+func TestResumeLedgerRollback(t *testing.T) {
+	db.DB = db.NewMemoryStore()
+	address := getTestAddress(1)
+	confirmed := getTestKey("A")
+	kept := getTestKey("B")
+
+	if err := db.Put(db.GetAddressKey(address, db.KEY_BALANCE), int64(95), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range [][]byte{confirmed, kept} {
+		if err := db.Put(db.AsKey(key, db.KEY_CONFIRMED), 0, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Put(db.AsKey(confirmed, db.KEY_CONFIRMATION), []int64{11, 0}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveLedgerDiff(confirmed, &transaction.FastTX{Address: address, Value: -5}, 11, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(db.GetAddressKey(address, db.KEY_SPENT), true, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveLedgerDiff(kept, &transaction.FastTX{Address: getTestAddress(2), Value: 5}, 10, nil); err != nil {
+		t.Fatal(err)
+	}
+	pendingAbove := db.AsKey(getTestKey("C"), db.KEY_EVENT_CONFIRMATION_PENDING)
+	pendingBelow := db.AsKey(getTestKey("D"), db.KEY_EVENT_CONFIRMATION_PENDING)
+	if err := db.Put(pendingAbove, []int{0, 11}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(pendingBelow, []int{0, 10}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	// An interrupted rollback from milestone 11 to 10
+	if err := db.Put(ledgerRollbackKey, []int{10, 11}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	resumeLedgerRollback()
+
+	balance, err := db.GetInt64(db.GetAddressKey(address, db.KEY_BALANCE), nil)
+	if err != nil || balance != 100 {
+		t.Errorf("Balance is %v (%v), expected 100", balance, err)
+	}
+	if db.Has(db.GetAddressKey(address, db.KEY_SPENT), nil) {
+		t.Error("Spent status was not reverted")
+	}
+	if db.Has(db.AsKey(confirmed, db.KEY_CONFIRMED), nil) || db.Has(db.AsKey(confirmed, db.KEY_CONFIRMATION), nil) {
+		t.Error("Confirmation was not reverted")
+	}
+	if !db.Has(db.AsKey(kept, db.KEY_CONFIRMED), nil) || !db.Has(db.GetMilestoneKey(db.KEY_LEDGER_DIFF, 10, getTestAddress(2)), nil) {
+		t.Error("Confirmation of the target milestone was reverted")
+	}
+	if db.Has(db.GetMilestoneKey(db.KEY_LEDGER_DIFF, 11, address), nil) {
+		t.Error("Ledger diff of the reverted milestone was not removed")
+	}
+	if db.Has(pendingAbove, nil) || !db.Has(pendingBelow, nil) {
+		t.Error("Wrong pending confirmations were removed")
+	}
+	if db.Has(ledgerRollbackKey, nil) {
+		t.Error("Rollback is still marked as in progress")
+	}
+}
@@ -0,0 +1,61 @@
+package tangle
+
+import (
+	"sync/atomic"
+
+	"../metrics"
+)
+
+func metricsOnLoad() {
+	counters := []struct {
+		name    string
+		help    string
+		counter *int64
+	}{
+		{"hercules_tangle_incoming_total", "Messages taken from the incoming queue", &incoming},
+		{"hercules_tangle_processed_total", "Incoming transactions processed", &incomingProcessed},
+		{"hercules_tangle_saved_total", "New transactions saved", &saved},
+		{"hercules_tangle_discarded_total", "Incoming transactions discarded as already known", &discarded},
+		{"hercules_tangle_outgoing_total", "Replies sent to neighbors", &outgoing},
+	}
+	for _, c := range counters {
+		counter := c.counter
+		metrics.AddCounter(c.name, c.help, func() float64 {
+			return float64(atomic.LoadInt64(counter))
+		})
+	}
+
+	metrics.AddGauge("hercules_transactions", "Transactions in the database", func() float64 {
+		return float64(atomic.LoadInt64(&totalTransactions))
+	})
+	metrics.AddGauge("hercules_confirmations", "Confirmed transactions in the database", func() float64 {
+		return float64(atomic.LoadInt64(&totalConfirmations))
+	})
+	metrics.AddGaugeVec("hercules_milestone_index", "Latest and latest solid milestone index",
+		func() []metrics.Sample {
+			MilestoneLocker.Lock()
+			defer MilestoneLocker.Unlock()
+			return []metrics.Sample{
+				{Labels: map[string]string{"type": "latest"}, Value: float64(LatestMilestone.Index)},
+				{Labels: map[string]string{"type": "solid"}, Value: float64(LatestSolidMilestone.Index)},
+			}
+		})
+	metrics.AddGauge("hercules_tips", "Number of tips", func() float64 {
+		TipsLocker.Lock()
+		defer TipsLocker.Unlock()
+		return float64(len(Tips))
+	})
+	metrics.AddGauge("hercules_pending_requests", "Missing transactions currently requested from neighbors",
+		func() float64 {
+			pendingRequestLocker.RLock()
+			defer pendingRequestLocker.RUnlock()
+			return float64(len(pendingRequests))
+		})
+	metrics.AddGaugeVec("hercules_tangle_queue_length", "Items waiting in the tangle queues",
+		func() []metrics.Sample {
+			return []metrics.Sample{
+				{Labels: map[string]string{"queue": "confirmations"}, Value: float64(len(confirmQueue))},
+				{Labels: map[string]string{"queue": "milestones"}, Value: float64(len(pendingMilestoneQueue))},
+			}
+		})
+}
@@ -10,14 +10,13 @@ import (
 	"encoding/gob"
 	"errors"
 	"strings"
-	"github.com/dgraph-io/badger"
 	"../convert"
 	"../db"
 	"../logs"
+	"../network"
 	"../transaction"
 )
 
-const COO_ADDRESS = "KPWCHICGJZXKE9GSUDXZYUAPLHAKAHYHDXNPHENTERYMMBQOPSQIDENXKLKCEYCPVTZQLEEJVYJZV9BWU"
 const COO_ADDRESS2 = "999999999999999999999999999999999999999999999999999999999999999999999999999999999"
 // TODO: for full-nodes this interval could be decreased for a little faster confirmations..?
 const milestoneCheckInterval = time.Duration(10) * time.Second
@@ -36,7 +35,7 @@ type PendingMilestone struct {
 }
 type PendingMilestoneQueue chan *PendingMilestone
 
-var COO_ADDRESS_BYTES = convert.TrytesToBytes(COO_ADDRESS)[:49]
+var COO_ADDRESS_BYTES []byte
 var COO_ADDRESS2_BYTES = convert.TrytesToBytes(COO_ADDRESS2)[:49]
 
 var pendingMilestoneQueue PendingMilestoneQueue
@@ -102,6 +101,7 @@ func LoadMissingMilestonesFromFile(path string) error {
 func milestoneOnLoad() {
 	logs.Log.Info("Loading milestones")
 	pendingMilestoneQueue = make(PendingMilestoneQueue, maxQueueSize)
+	COO_ADDRESS_BYTES = convert.TrytesToBytes(network.Current.CoordinatorAddress)[:49]
 
 	loadLatestMilestone()
 
@@ -110,17 +110,10 @@ func milestoneOnLoad() {
 
 func loadLatestMilestone() {
 	logs.Log.Infof("Loading latest milestone...")
-	_ = db.DB.View(func(txn *badger.Txn) error {
+	_ = db.DB.View(func(txn db.Txn) error {
 		latest := 0
 		LatestMilestone = Milestone{tipFastTX, latest}
-		opts := badger.DefaultIteratorOptions
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := []byte{db.KEY_MILESTONE}
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			item := it.Item()
-			key := item.Key()
-			value, _ := item.Value()
+		return txn.ForPrefix([]byte{db.KEY_MILESTONE}, true, func(key []byte, value []byte) (bool, error) {
 			var ms = 0
 			buf := bytes.NewBuffer(value)
 			dec := gob.NewDecoder(buf)
@@ -137,8 +130,8 @@ func loadLatestMilestone() {
 					latest = ms
 				}
 			}
-		}
-		return nil
+			return true, nil
+		})
 	})
 	logs.Log.Infof("Loaded latest milestone: %v", LatestMilestone.Index)
 }
@@ -157,8 +150,10 @@ func checkIsLatestMilestone(index int, tx *transaction.FastTX) bool {
 		// Add milestone hash:
 		trits := convert.BytesToTrits(tx.Bytes)[:8019]
 		tx = transaction.TritsToTX(&trits, tx.Bytes)
+		previous := LatestMilestone.Index
 		LatestMilestone = Milestone{tx, index}
 		logs.Log.Infof("Latest milestone changed to: %v", index)
+		publishLatestMilestone(previous, LatestMilestone)
 		return true
 	}
 	return false
@@ -171,6 +166,31 @@ func addPendingMilestoneToQueue(pendingMilestone *PendingMilestone) {
 	}()
 }
 
+/*
+Registers a saved transaction as pending milestone (0-index transaction of the bundle).
+Used for milestones issued locally, which do not go through the incoming queue.
+*/
+func AddPendingMilestone(hash []byte) error {
+	return addPendingMilestone(db.GetByteKey(hash, db.KEY_HASH))
+}
+
+func addPendingMilestone(key []byte) error {
+	var trunkBytesKey []byte
+	err := db.DB.Update(func(txn db.Txn) error {
+		tx := getMilestoneTX(key, txn)
+		if tx == nil {
+			return errors.New("milestone transaction not found")
+		}
+		trunkBytesKey = db.GetByteKey(tx.TrunkTransaction, db.KEY_BYTES)
+		return db.PutBytes(db.AsKey(key, db.KEY_EVENT_MILESTONE_PENDING), trunkBytesKey, nil, txn)
+	})
+	if err != nil {
+		return err
+	}
+	addPendingMilestoneToQueue(&PendingMilestone{key, trunkBytesKey})
+	return nil
+}
+
 /*
 Runs checking of pending milestones.
 */
@@ -179,31 +199,22 @@ func startMilestoneChecker() {
 	db.Locker.Lock()
 	db.Locker.Unlock()
 	var pairs []PendingMilestone
-	_ = db.DB.View(func(txn *badger.Txn) (e error) {
-		opts := badger.DefaultIteratorOptions
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := []byte{db.KEY_EVENT_MILESTONE_PENDING}
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			item := it.Item()
-			key := item.Key()
-			value, _ := item.Value()
-			k := make([]byte, len(key))
+	_ = db.DB.View(func(txn db.Txn) (e error) {
+		return txn.ForPrefix([]byte{db.KEY_EVENT_MILESTONE_PENDING}, true, func(key []byte, value []byte) (bool, error) {
 			v := make([]byte, len(value))
-			copy(k, key)
 			copy(v, value)
-			pairs = append(pairs, PendingMilestone{k, v})
-		}
-		return nil
+			pairs = append(pairs, PendingMilestone{key, v})
+			return true, nil
+		})
 	})
 	for _, pair := range pairs {
-		_ = db.DB.Update(func(txn *badger.Txn) (e error) {
+		_ = db.DB.Update(func(txn db.Txn) (e error) {
 			defer func() {
 				if err := recover(); err != nil {
 					e = errors.New("Failed milestone check!")
 				}
 			}()
-			total += preCheckMilestone(pair.Key, pair.TX2BytesKey, txn)
+			total += preCheckMilestone(pair.Key, txn)
 			return nil
 		})
 	}
@@ -228,69 +239,79 @@ func checkMilestones() {
 }
 
 func incomingMilestone(pendingMilestone *PendingMilestone) {
-	_ = db.DB.Update(func(txn *badger.Txn) (e error) {
+	_ = db.DB.Update(func(txn db.Txn) (e error) {
 		defer func() {
 			if err := recover(); err != nil {
 				e = errors.New("Failed queue milestone check!")
 			}
 		}()
 		key := db.AsKey(pendingMilestone.Key, db.KEY_EVENT_MILESTONE_PENDING)
-		TX2BytesKey := pendingMilestone.TX2BytesKey
-		if TX2BytesKey == nil {
-			relation, err := db.GetBytes(db.AsKey(key, db.KEY_RELATION), txn)
-			if err == nil {
-				TX2BytesKey = db.AsKey(relation[:16], db.KEY_HASH)
-			} else {
-				// The 0-index milestone TX relations doesn't exist.
-				// Clearly an error here!
-				db.Remove(key, txn)
-				logs.Log.Panicf("A milestone has disappeared!")
-				panic("A milestone has disappeared!")
-			}
+		if !db.Has(db.AsKey(key, db.KEY_RELATION), txn) {
+			// The 0-index milestone TX relations doesn't exist.
+			// Clearly an error here!
+			db.Remove(key, txn)
+			logs.Log.Panicf("A milestone has disappeared!")
+			panic("A milestone has disappeared!")
 		}
-		preCheckMilestone(key, TX2BytesKey, txn)
+		preCheckMilestone(key, txn)
 		return nil
 	})
 }
 
-func preCheckMilestone(key []byte, TX2BytesKey []byte, txn *badger.Txn) int {
+/*
+Collects the milestone bundle, starting at its 0-index transaction: one transaction per
+signature fragment (coordinator security level), followed by the Merkle siblings transaction.
+If any of them is still missing, a pending pair event is saved for it.
+*/
+func preCheckMilestone(key []byte, txn db.Txn) int {
 	var txBytesKey = db.AsKey(key, db.KEY_BYTES)
-	// 2. Check if 1-index TX already exists
-	tx2Bytes, err := db.GetBytes(TX2BytesKey, txn)
-	if err == nil {
-		// 3. Check that the 0-index TX also exists.
-		txBytes, err := db.GetBytes(txBytesKey, txn)
-		if err == nil {
-
-			//Get TX objects and validate
-			trits := convert.BytesToTrits(txBytes)[:8019]
-			tx := transaction.TritsToFastTX(&trits, txBytes)
-
-			trits2 := convert.BytesToTrits(tx2Bytes)[:8019]
-			tx2 := transaction.TritsToFastTX(&trits2, tx2Bytes)
-
-			if checkMilestone(txBytesKey, tx, tx2, trits2, txn) {
-				return 1
+	// 1. Check that the 0-index TX exists.
+	txBytes, err := db.GetBytes(txBytesKey, txn)
+	if err != nil {
+		// The 0-index milestone TX doesn't exist.
+		// Clearly an error here!
+		// db.Remove(key, txn)
+		addPendingMilestoneToQueue(&PendingMilestone{key, nil})
+		logs.Log.Panicf("A milestone has disappeared: %v", txBytesKey)
+		panic("A milestone has disappeared!")
+	}
+	trits := convert.BytesToTrits(txBytes)[:8019]
+	tx := transaction.TritsToFastTX(&trits, txBytes)
+
+	// 2. Check if the rest of the bundle already exists
+	txs := []*transaction.FastTX{tx}
+	current := tx
+	for i := 0; i < network.Current.MilestoneSecurity; i++ {
+		nextBytesKey := db.GetByteKey(current.TrunkTransaction, db.KEY_BYTES)
+		nextBytes, err := db.GetBytes(nextBytesKey, txn)
+		if err != nil {
+			err := db.Put(db.AsKey(nextBytesKey, db.KEY_EVENT_MILESTONE_PAIR_PENDING), key, nil, txn)
+			if err != nil {
+				logs.Log.Errorf("Could not add pending milestone pair: %v", err)
+				panic(err)
 			}
-		} else {
-			// The 0-index milestone TX doesn't exist.
-			// Clearly an error here!
-			// db.Remove(key, txn)
-			addPendingMilestoneToQueue(&PendingMilestone{key, TX2BytesKey})
-			logs.Log.Panicf("A milestone has disappeared: %v", txBytesKey)
-			panic("A milestone has disappeared!")
+			return 0
 		}
-	} else {
-		err := db.Put(db.AsKey(TX2BytesKey, db.KEY_EVENT_MILESTONE_PAIR_PENDING), key, nil, txn)
-		if err != nil {
-			logs.Log.Errorf("Could not add pending milestone pair: %v", err)
-			panic(err)
+		nextTrits := convert.BytesToTrits(nextBytes)[:8019]
+		current = transaction.TritsToFastTX(&nextTrits, nextBytes)
+		if i == network.Current.MilestoneSecurity-1 {
+			// Last one: the Merkle siblings transaction
+			if checkMilestone(txBytesKey, txs, current, nextTrits, txn) {
+				return 1
+			}
+			return 0
 		}
+		txs = append(txs, current)
 	}
 	return 0
 }
 
-func checkMilestone(key []byte, tx *transaction.FastTX, tx2 *transaction.FastTX, trits []int, txn *badger.Txn) bool {
+/*
+Verifies the milestone bundle structure and signature. Params: the signature transactions
+of the bundle, the siblings transaction and its trits.
+*/
+func checkMilestone(key []byte, txs []*transaction.FastTX, siblingsTX *transaction.FastTX, siblingsTrits []int, txn db.Txn) bool {
+	tx := txs[0]
 	key = db.AsKey(key, db.KEY_EVENT_MILESTONE_PENDING)
 	discardMilestone := func() {
 		logs.Log.Error("Discarding", convert.BytesToTrytes(tx.Bundle)[:81])
@@ -303,16 +324,22 @@ func checkMilestone(key []byte, tx *transaction.FastTX, tx2 *transaction.FastTX,
 	}
 
 	// Verify correct bundle structure:
-	if !bytes.Equal(tx2.Address, COO_ADDRESS2_BYTES) ||
-		!bytes.Equal(tx2.TrunkTransaction, tx.BranchTransaction) ||
-		!bytes.Equal(tx2.Bundle, tx.Bundle) {
+	validStructure := bytes.Equal(siblingsTX.Address, COO_ADDRESS2_BYTES) &&
+		bytes.Equal(siblingsTX.TrunkTransaction, tx.BranchTransaction) &&
+		bytes.Equal(siblingsTX.Bundle, tx.Bundle)
+	for _, signatureTX := range txs[1:] {
+		validStructure = validStructure &&
+			bytes.Equal(signatureTX.Address, COO_ADDRESS_BYTES) &&
+			bytes.Equal(signatureTX.Bundle, tx.Bundle)
+	}
+	if !validStructure {
 		logs.Log.Warning("Milestone bundle verification failed for:\n ", convert.BytesToTrytes(tx.Bundle)[:81])
 		discardMilestone()
 		return false
 	}
 
 	// Verify milestone signature and get the index:
-	milestoneIndex := getMilestoneIndex(tx, trits)
+	milestoneIndex := getMilestoneIndex(txs, siblingsTrits)
 	if milestoneIndex < 0 {
 		logs.Log.Warning("Milestone signature verification failed for: ", convert.BytesToTrytes(tx.Bundle)[:81])
 		discardMilestone()
@@ -333,7 +360,7 @@ func checkMilestone(key []byte, tx *transaction.FastTX, tx2 *transaction.FastTX,
 	checkIsLatestMilestone(milestoneIndex, tx)
 
 	// Trigger confirmations
-	err = addPendingConfirmation(db.AsKey(key, db.KEY_EVENT_CONFIRMATION_PENDING), tx.Timestamp, txn)
+	err = addPendingConfirmation(db.AsKey(key, db.KEY_EVENT_CONFIRMATION_PENDING), tx.Timestamp, milestoneIndex, txn)
 	if err != nil {
 		logs.Log.Errorf("Could not save pending confirmation: %v", err)
 		panic(err)
@@ -343,31 +370,42 @@ func checkMilestone(key []byte, tx *transaction.FastTX, tx2 *transaction.FastTX,
 
 /*
 Returns Milestone index if the milestone verification has been correct. Otherwise -1.
-Params: tx + trits of the seconds transaction in the milestone bundle.
+Params: the signature transactions of the milestone bundle + trits of the siblings transaction.
 */
-func getMilestoneIndex(tx *transaction.FastTX, trits []int) int {
-	milestoneIndex := int(convert.TritsToInt(convert.BytesToTrits(tx.ObsoleteTag[:5])).Uint64())
-	trunkTransactionTrits := convert.BytesToTrits(tx.TrunkTransaction)[:243]
-	normalized := transaction.NormalizedBundle(trunkTransactionTrits)[:transaction.NUMBER_OF_FRAGMENT_CHUNKS]
-	digests := transaction.Digest(normalized, tx.SignatureMessageFragment, 0, 0, false)
+func getMilestoneIndex(txs []*transaction.FastTX, trits []int) int {
+	milestoneIndex := int(convert.TritsToInt(convert.BytesToTrits(txs[0].ObsoleteTag[:5])).Uint64())
+	siblingsHashTrits := convert.BytesToTrits(txs[len(txs)-1].TrunkTransaction)[:243]
+	normalized := transaction.NormalizedBundle(siblingsHashTrits)
+	var digests []int
+	for i, tx := range txs {
+		offset := i * transaction.NUMBER_OF_FRAGMENT_CHUNKS
+		digests = append(digests, transaction.Digest(normalized, tx.SignatureMessageFragment, offset, 0, false)...)
+	}
 	address := transaction.Address(digests)
 	merkleRoot := transaction.GetMerkleRoot(
 		address,
 		trits,
 		0,
 		milestoneIndex,
-		transaction.NUMBER_OF_KEYS_IN_MILESTONE,
+		network.Current.MilestoneDepth,
 	)
 	merkleAddress := convert.TritsToTrytes(merkleRoot)
-	if merkleAddress == COO_ADDRESS {
+	if merkleAddress == network.Current.CoordinatorAddress {
 		return milestoneIndex
 	} else {
 		return -1
 	}
 }
 
+/*
+Same as getMilestoneIndex. Used by the local coordinator to verify its milestones before storing them.
+*/
+func GetMilestoneIndex(txs []*transaction.FastTX, trits []int) int {
+	return getMilestoneIndex(txs, trits)
+}
+
 func isMaybeMilestone(tx *transaction.FastTX) bool {
-	return bytes.Equal(tx.Address, COO_ADDRESS_BYTES) && tx.Value == 0
+	return bytes.Equal(tx.Address, COO_ADDRESS_BYTES) && tx.Value == 0 && tx.CurrentIndex == 0
 }
 
 func isMaybeMilestonePair(tx *transaction.FastTX) bool {
@@ -387,15 +425,8 @@ func GetMilestoneKeyByIndex(index int, acceptNearest bool) []byte {
 	var milestoneKey []byte
 	currentIndex := LatestMilestone.Index + 1
 
-	_ = db.DB.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := []byte{db.KEY_MILESTONE}
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			item := it.Item()
-			key := item.Key()
-			value, _ := item.Value()
+	_ = db.DB.View(func(txn db.Txn) error {
+		return txn.ForPrefix([]byte{db.KEY_MILESTONE}, true, func(key []byte, value []byte) (bool, error) {
 			var ms = 0
 			buf := bytes.NewBuffer(value)
 			dec := gob.NewDecoder(buf)
@@ -403,13 +434,13 @@ func GetMilestoneKeyByIndex(index int, acceptNearest bool) []byte {
 			if err == nil {
 				if ms == index {
 					milestoneKey = db.AsKey(key, db.KEY_HASH)
-					break
+					return false, nil
 				} else if acceptNearest && ms < currentIndex {
 					milestoneKey = db.AsKey(key, db.KEY_HASH)
 				}
 			}
-		}
-		return nil
+			return true, nil
+		})
 	})
 	return milestoneKey
 }
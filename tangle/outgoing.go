@@ -3,13 +3,13 @@ package tangle
 import (
 	"bytes"
 	"encoding/gob"
+	"sync/atomic"
 	"time"
 
 	"../db"
 	"../logs"
 	"../server"
 	"../utils"
-	"github.com/dgraph-io/badger"
 	"github.com/lukechampine/randmap"
 )
 
@@ -69,20 +69,14 @@ func loadPendingRequests() {
 	total := 0
 	added := 0
 
-	_ = db.DB.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := []byte{db.KEY_PENDING_HASH}
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			item := it.Item()
-			v, _ := item.Value()
+	_ = db.DB.View(func(txn db.Txn) error {
+		return txn.ForPrefix([]byte{db.KEY_PENDING_HASH}, true, func(key []byte, v []byte) (bool, error) {
 			var hash []byte
 			buf := bytes.NewBuffer(v)
 			dec := gob.NewDecoder(buf)
 			err := dec.Decode(&hash)
 			if err == nil {
-				timestamp, err := db.GetInt64(db.AsKey(item.Key(), db.KEY_PENDING_TIMESTAMP), txn)
+				timestamp, err := db.GetInt64(db.AsKey(key, db.KEY_PENDING_TIMESTAMP), txn)
 				if err == nil {
 					for _, neighbor := range server.Neighbors {
 						queue, ok := requestQueues[neighbor.Addr]
@@ -102,8 +96,8 @@ func loadPendingRequests() {
 				logs.Log.Warning("Could not load pending Tx Hash")
 			}
 			total++
-		}
-		return nil
+			return true, nil
+		})
 	})
 
 	logs.Log.Info("Pending requests loaded", added, total)
@@ -206,10 +200,10 @@ func sendReply(msg *Message) {
 	}
 	data := append((*msg.Bytes)[:1604], (*msg.Requested)[:46]...)
 	srv.Outgoing <- &server.Message{IPAddressWithPort: msg.IPAddressWithPort, Msg: data}
-	outgoing++
+	atomic.AddInt64(&outgoing, 1)
 }
 
-func getMessage(resp []byte, req []byte, tip bool, IPAddressWithPort string, txn *badger.Txn) *Message {
+func getMessage(resp []byte, req []byte, tip bool, IPAddressWithPort string, txn db.Txn) *Message {
 	var hash []byte
 	if resp == nil {
 		hash, resp = getRandomTip()
@@ -245,6 +239,9 @@ func getMessage(resp []byte, req []byte, tip bool, IPAddressWithPort string, txn
 		}
 	}
 
+	// Whether a missing transaction is requested (as opposed to a tip)
+	isRequest := req != nil
+
 	// If no request provided
 	if req == nil {
 		// Select tip, if so requested, or one of the random pending requests.
@@ -266,6 +263,7 @@ func getMessage(resp []byte, req []byte, tip bool, IPAddressWithPort string, txn
 					req = pendingRequest.Hash
 				}
 			}
+			isRequest = req != nil
 		}
 	}
 	if req == nil {
@@ -274,6 +272,9 @@ func getMessage(resp []byte, req []byte, tip bool, IPAddressWithPort string, txn
 	if req == nil {
 		req = make([]byte, 46)
 	}
+	if isRequest {
+		server.NeighborTrackingQueue <- &server.NeighborTrackingMessage{IPAddressWithPort: IPAddressWithPort, Requested: 1}
+	}
 	return &Message{Bytes: &resp, Requested: &req, IPAddressWithPort: IPAddressWithPort}
 }
 
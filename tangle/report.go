@@ -1,6 +1,7 @@
 package tangle
 
 import (
+	"sync/atomic"
 	"time"
 
 	"../db"
@@ -8,22 +9,23 @@ import (
 )
 
 func Report() {
-	logs.Log.Debugf("TX IN/OUT:     %v, %v", incoming, outgoing)
+	logs.Log.Debugf("TX IN/OUT:     %v, %v", atomic.LoadInt64(&incoming), atomic.LoadInt64(&outgoing))
 	logs.Log.Debugf("SERVER I/O Q:  %v, %v \n",
 		len(srv.Incoming),
 		len(srv.Outgoing))
 	logs.Log.Infof("TRANSACTIONS:  %v, Requests: %v (%v)",
-		totalTransactions,
+		atomic.LoadInt64(&totalTransactions),
 		db.Count(db.KEY_PENDING_HASH),
 		len(pendingRequests))
 	logs.Log.Infof("CONFIRMATIONS: %v, Pending: %v (%v), Unknown: %v",
-		totalConfirmations,
+		atomic.LoadInt64(&totalConfirmations),
 		db.Count(db.KEY_EVENT_CONFIRMATION_PENDING),
 		len(confirmQueue),
 		db.Count(db.KEY_PENDING_CONFIRMED))
 	logs.Log.Debugf("PENDING TRIMS: %v", db.Count(db.KEY_EVENT_TRIM_PENDING))
-	logs.Log.Infof("MILESTONES:    Current: %v, Confirmed: %v, Pending: %v (%v) \n",
+	logs.Log.Infof("MILESTONES:    Current: %v, Solid: %v, Confirmed: %v, Pending: %v (%v) \n",
 		LatestMilestone.Index,
+		LatestSolidMilestone.Index,
 		db.Count(db.KEY_MILESTONE),
 		db.Count(db.KEY_EVENT_MILESTONE_PENDING),
 		len(pendingMilestoneQueue))
@@ -0,0 +1,207 @@
+package tangle
+
+import (
+	"bytes"
+	"encoding/gob"
+	"time"
+
+	"../convert"
+	"../db"
+	"../logs"
+	"../snapshot"
+	"../transaction"
+)
+
+const solidifierInterval = time.Duration(5) * time.Second
+
+var LatestSolidMilestone Milestone
+
+func solidOnLoad() {
+	logs.Log.Info("Loading solid milestones")
+	loadLatestSolidMilestone()
+
+	go startSolidifier()
+}
+
+func loadLatestSolidMilestone() {
+	_ = db.DB.View(func(txn db.Txn) error {
+		latest := 0
+		LatestSolidMilestone = Milestone{tipFastTX, latest}
+		return txn.ForPrefix([]byte{db.KEY_SOLID_MILESTONE}, true, func(key []byte, value []byte) (bool, error) {
+			var ms = 0
+			buf := bytes.NewBuffer(value)
+			dec := gob.NewDecoder(buf)
+			err := dec.Decode(&ms)
+			if err == nil && ms > latest {
+				tx := getMilestoneTX(key, txn)
+				if tx != nil {
+					MilestoneLocker.Lock()
+					LatestSolidMilestone = Milestone{tx, ms}
+					MilestoneLocker.Unlock()
+					latest = ms
+				}
+			}
+			return true, nil
+		})
+	})
+	logs.Log.Infof("Loaded latest solid milestone: %v", LatestSolidMilestone.Index)
+}
+
+func getLatestSolidMilestone() *Milestone {
+	MilestoneLocker.Lock()
+	defer MilestoneLocker.Unlock()
+	milestone := LatestSolidMilestone
+	return &milestone
+}
+
+func getMilestoneTX(key []byte, txn db.Txn) *transaction.FastTX {
+	txBytes, err := db.GetBytes(db.AsKey(key, db.KEY_BYTES), txn)
+	if err != nil {
+		return nil
+	}
+	trits := convert.BytesToTrits(txBytes)[:8019]
+	return transaction.TritsToTX(&trits, txBytes)
+}
+
+/*
+Periodically tries to advance the latest solid milestone.
+*/
+func startSolidifier() {
+	ticker := time.NewTicker(solidifierInterval)
+	for range ticker.C {
+		db.Locker.Lock()
+		db.Locker.Unlock()
+		if snapshot.InProgress {
+			continue
+		}
+		solidifyMilestones()
+	}
+}
+
+/*
+Walks the milestones following the latest solid one in order of their index
+and marks them solid, as long as their past cone is complete.
+*/
+func solidifyMilestones() {
+	milestone := getLatestSolidMilestone()
+	latest := getLatestMilestone()
+	if milestone.Index >= latest.Index {
+		return
+	}
+
+	milestoneKeys := getMilestoneKeysFrom(milestone.Index + 1)
+	index := milestone.Index + 1
+	if milestone.Index == 0 {
+		// No solid milestone yet (e.g. fresh snapshot). Start from the oldest known one.
+		index = getLowestIndex(milestoneKeys)
+	}
+
+	for ; index > 0 && index <= latest.Index; index++ {
+		key, ok := milestoneKeys[index]
+		if !ok {
+			return
+		}
+		missing, err := checkSolidity(key)
+		if err != nil {
+			logs.Log.Errorf("Could not check solidity of milestone %v: %v", index, err)
+			return
+		}
+		if missing > 0 {
+			logs.Log.Debugf("Milestone %v is not solid yet. Missing transactions: %v", index, missing)
+			return
+		}
+		err = db.DB.Update(func(txn db.Txn) error {
+			return db.Put(db.AsKey(key, db.KEY_SOLID_MILESTONE), index, nil, txn)
+		})
+		if err != nil {
+			logs.Log.Errorf("Could not save solid milestone %v: %v", index, err)
+			return
+		}
+		tx := getMilestoneTX(key, nil)
+		if tx != nil {
+			MilestoneLocker.Lock()
+			previous := LatestSolidMilestone.Index
+			LatestSolidMilestone = Milestone{tx, index}
+			MilestoneLocker.Unlock()
+			logs.Log.Infof("Latest solid milestone changed to: %v", index)
+			publishLatestSolidMilestone(previous, Milestone{tx, index})
+			if index == LatestMilestone.Index {
+				go updateCumulativeWeights()
+			}
+		}
+	}
+}
+
+/*
+Returns the hash keys of all known milestones with an index equal or greater than the given one.
+*/
+func getMilestoneKeysFrom(index int) map[int][]byte {
+	milestoneKeys := make(map[int][]byte)
+	_ = db.DB.View(func(txn db.Txn) error {
+		return txn.ForPrefix([]byte{db.KEY_MILESTONE}, true, func(key []byte, value []byte) (bool, error) {
+			var ms = 0
+			buf := bytes.NewBuffer(value)
+			dec := gob.NewDecoder(buf)
+			err := dec.Decode(&ms)
+			if err == nil && ms >= index {
+				milestoneKeys[ms] = db.AsKey(key, db.KEY_HASH)
+			}
+			return true, nil
+		})
+	})
+	return milestoneKeys
+}
+
+func getLowestIndex(milestoneKeys map[int][]byte) int {
+	lowest := 0
+	for index := range milestoneKeys {
+		if lowest == 0 || index < lowest {
+			lowest = index
+		}
+	}
+	return lowest
+}
+
+/*
+Walks the past cone of a milestone through the trunk/branch relations.
+The walk stops at previous solid milestones and at transactions behind the snapshot horizon.
+Returns the number of referenced transactions missing in the database.
+*/
+func checkSolidity(milestoneKey []byte) (missing int, err error) {
+	err = db.DB.View(func(txn db.Txn) error {
+		snapTime := snapshot.GetSnapshotTimestamp(txn)
+		seen := make(map[string]bool)
+		stack := [][]byte{milestoneKey}
+		for len(stack) > 0 {
+			key := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			if seen[string(key)] || bytes.Equal(key, tipHashKey) {
+				continue
+			}
+			seen[string(key)] = true
+
+			if !bytes.Equal(key, milestoneKey) && db.Has(db.AsKey(key, db.KEY_SOLID_MILESTONE), txn) {
+				continue
+			}
+			if db.Has(db.AsKey(key, db.KEY_SNAPSHOTTED), txn) || db.Has(db.AsKey(key, db.KEY_EVENT_TRIM_PENDING), txn) {
+				continue
+			}
+
+			relation, err := db.GetBytes(db.AsKey(key, db.KEY_RELATION), txn)
+			if err != nil {
+				missing++
+				continue
+			}
+
+			timestamp, err := db.GetInt(db.AsKey(key, db.KEY_TIMESTAMP), txn)
+			if err == nil && timestamp > 0 && timestamp <= snapTime {
+				continue
+			}
+
+			stack = append(stack, db.AsKey(relation[:16], db.KEY_HASH), db.AsKey(relation[16:], db.KEY_HASH))
+		}
+		return nil
+	})
+	return missing, err
+}
@@ -12,11 +12,9 @@ import (
 	"../server"
 	"../transaction"
 	"github.com/spf13/viper"
-	"github.com/dgraph-io/badger"
 )
 
 const (
-	MWM                = 14
 	maxQueueSize       = 1000000
 	reportInterval     = time.Duration(60) * time.Second
 	tipRemoverInterval = time.Duration(1) * time.Minute
@@ -59,11 +57,11 @@ var pendingRequestLocker = &sync.RWMutex{}
 var lowEndDevice = false
 var totalTransactions int64 = 0
 var totalConfirmations int64 = 0
-var incoming = 0
-var incomingProcessed = 0
-var saved = 0
-var discarded = 0
-var outgoing = 0
+var incoming int64 = 0
+var incomingProcessed int64 = 0
+var saved int64 = 0
+var discarded int64 = 0
+var outgoing int64 = 0
 
 func Start(s *server.Server, cfg *viper.Viper) {
 	config = cfg
@@ -72,6 +70,7 @@ func Start(s *server.Server, cfg *viper.Viper) {
 	requestQueues = make(map[string]*RequestQueue, maxQueueSize)
 
 	lowEndDevice = config.GetBool("light")
+	historyOnLoad()
 
 	totalTransactions = int64(db.Count(db.KEY_HASH))
 	totalConfirmations = int64(db.Count(db.KEY_CONFIRMED))
@@ -79,9 +78,15 @@ func Start(s *server.Server, cfg *viper.Viper) {
 	// reapplyConfirmed()
 	fingerprintsOnLoad()
 	tipOnLoad()
+	tipselOnLoad()
 	pendingOnLoad()
+	resumeLedgerRollback()
 	milestoneOnLoad()
+	solidOnLoad()
+	ledgerOnLoad()
+	bundleOnLoad()
 	confirmOnLoad()
+	metricsOnLoad()
 	// checkConsistency(false, false)
 
 
@@ -123,15 +128,9 @@ func checkConsistency (skipRequests bool, skipConfirmations bool) {
 		db.RemoveAll(db.KEY_PENDING_HASH)
 		db.RemoveAll(db.KEY_PENDING_TIMESTAMP)
 	}
-	db.DB.View(func(txn *badger.Txn) (e error) {
-		opts := badger.DefaultIteratorOptions
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := []byte{db.KEY_HASH}
+	db.DB.View(func(txn db.Txn) (e error) {
 		x := 0
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			item := it.Item()
-			key := item.Key()
+		return txn.ForPrefix([]byte{db.KEY_HASH}, false, func(key []byte, _ []byte) (bool, error) {
 			relKey := db.AsKey(key, db.KEY_RELATION)
 			relation, _ := db.GetBytes(relKey, txn)
 
@@ -144,7 +143,7 @@ func checkConsistency (skipRequests bool, skipConfirmations bool) {
 				txBytes, _ := db.GetBytes(db.AsKey(key, db.KEY_BYTES), txn)
 				trits := convert.BytesToTrits(txBytes)[:8019]
 				tx := transaction.TritsToFastTX(&trits, txBytes)
-				db.DB.Update(func(txn *badger.Txn) error {
+				db.DB.Update(func(txn db.Txn) error {
 					requestIfMissing(tx.TrunkTransaction, "")
 					requestIfMissing(tx.BranchTransaction, "")
 					return nil
@@ -154,9 +153,15 @@ func checkConsistency (skipRequests bool, skipConfirmations bool) {
 			// Re-confirm children
 			if !skipConfirmations {
 				if db.Has(db.AsKey(relKey, db.KEY_CONFIRMED), txn) {
-					db.DB.Update(func(txn *badger.Txn) error {
-						confirmChild(relation[:16], txn)
-						confirmChild(relation[16:], txn)
+					// The children belong to the confirmation wave of their parent
+					milestoneIndex := 0
+					confirmation := GetConfirmation(relKey, txn)
+					if confirmation != nil {
+						milestoneIndex = confirmation.MilestoneIndex
+					}
+					db.DB.Update(func(txn db.Txn) error {
+						confirmChild(relation[:16], milestoneIndex, txn)
+						confirmChild(relation[16:], milestoneIndex, txn)
 						return nil
 					})
 				}
@@ -166,7 +171,7 @@ func checkConsistency (skipRequests bool, skipConfirmations bool) {
 			if x % 10000 == 0 {
 				logs.Log.Debug("Processed", x)
 			}
-		}
-		return nil
+			return true, nil
+		})
 	})
 }
\ No newline at end of file
@@ -10,7 +10,6 @@ import (
 	"../logs"
 	"../transaction"
 	"../utils"
-	"github.com/dgraph-io/badger"
 )
 
 type Tip struct {
@@ -40,16 +39,8 @@ func tipOnLoad() {
 
 func loadTips() {
 	logs.Log.Info("Loading tips...")
-	_ = db.DB.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := []byte{db.KEY_TIP}
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			item := it.Item()
-			key := item.Key()
-			v, _ := item.Value()
-
+	_ = db.DB.View(func(txn db.Txn) error {
+		return txn.ForPrefix([]byte{db.KEY_TIP}, true, func(key []byte, v []byte) (bool, error) {
 			var timestamp int
 			buf := bytes.NewBuffer(v)
 			dec := gob.NewDecoder(buf)
@@ -62,8 +53,8 @@ func loadTips() {
 					TipsLocker.Unlock()
 				}
 			}
-		}
-		return nil
+			return true, nil
+		})
 	})
 	logs.Log.Infof("Loaded tips: %v\n", len(Tips))
 }
@@ -126,7 +117,7 @@ func findTip(hash []byte) int {
 	return -1
 }
 
-func updateTipsOnNewTransaction(tx *transaction.FastTX, txn *badger.Txn) error {
+func updateTipsOnNewTransaction(tx *transaction.FastTX, txn db.Txn) error {
 	key := db.GetByteKey(tx.Hash, db.KEY_APPROVEE)
 	tipAge := time.Duration(time.Now().Sub(time.Unix(int64(tx.Timestamp), 0)).Nanoseconds())
 
@@ -1,42 +1,43 @@
 package tangle
 
 import (
+	"bytes"
 	"math"
 	"math/rand"
-	"bytes"
-
+	"sync"
 	"time"
 
 	"../db"
 	"../logs"
-	"../convert"
-	"../transaction"
-	"github.com/dgraph-io/badger"
 )
 
 const (
 	MinTipselDepth      = 2
 	MaxTipselDepth      = 15
-	MaxCheckDepth       = 150
 	MaxTipAge           = MaxTipselDepth * time.Duration(40) * time.Second
-	tipAlpha            = 0.001
 	maxTipSearchRetries = 100
+
+	maxDepthAnalyzedTransactions = 20000
 )
 
-// 1. Get reference: either one provided or latest milestone - 15 milestones back
+var tipselAlpha = 0.001
+var tipselWalkers = 2
 
-type GraphNode struct {
-	Key      []byte
-	Children []*GraphNode
-	Count    int64
-	Valid    bool
-	Index    int
+func tipselOnLoad() {
+	tipselAlpha = config.GetFloat64("tipselection.alpha")
+	tipselWalkers = config.GetInt("tipselection.walkers")
+	if tipselAlpha < 0 {
+		logs.Log.Warning("Tip selection alpha cannot be negative, using 0")
+		tipselAlpha = 0
+	}
+	if tipselWalkers < 1 {
+		logs.Log.Warning("Tip selection needs at least one walker, using 1")
+		tipselWalkers = 1
+	}
+	logs.Log.Infof("Tip selection: alpha %v, %v walkers", tipselAlpha, tipselWalkers)
 }
 
-type GraphRating struct {
-	Rating int
-	Graph  *GraphNode
-}
+// 1. Get reference: either one provided or latest milestone - depth milestones back
 
 func getReference(reference []byte, depth int) []byte {
 	if reference != nil && len(reference) > 0 {
@@ -48,254 +49,299 @@ func getReference(reference []byte, depth int) []byte {
 	return GetMilestoneKeyByIndex(LatestMilestone.Index-depth, true)
 }
 
-// 2. Build sub-graph
+// 2. Walk from the reference to the tips, weighted by the cumulative weights
 
 /*
-Creates a sub-graph structure, directly dropping contradictory transactions.
+Random walk over the tails of the future cone of the reference. The next tail is chosen with a
+probability proportional to exp(alpha * (weight - highest weight)). Approvers that are below max depth
+or would lead to an inconsistent ledger state together with the transactions walked before are skipped.
+Returns the last valid tail reached.
 */
-func buildGraph(reference []byte, graphRatings *map[string]*GraphRating, ledgerState map[string]int64, seen map[string]bool, valid bool) *GraphNode {
-	approveeKeys := findApprovees(reference)
-	graph := &GraphNode{reference, nil, 1, valid, 999999}
-
-	txBytes, err := db.GetBytes(db.AsKey(reference, db.KEY_BYTES), nil)
-	var tx *transaction.FastTX
-	if err == nil {
-		trits := convert.BytesToTrits(txBytes)[:8019]
-		tx = transaction.TritsToFastTX(&trits, txBytes)
-		graph.Index = tx.CurrentIndex
+func randomWalk(reference []byte, weights map[string]int, check *depthCheck, random *rand.Rand) []byte {
+	state := newLedgerState()
+	if !state.approve(reference) {
+		return nil
 	}
-
-	addr, err := db.GetBytes(db.AsKey(reference, db.KEY_ADDRESS_HASH), nil)
-	if err != nil {
-		graph.Valid = false
-	} else {
-		addrString := string(addr)
-
-		value, err := db.GetInt64(db.AsKey(reference, db.KEY_VALUE), nil)
-		if err != nil {
-			graph.Valid = false
-		} else {
-			if graph.Valid && !hasMilestoneParent(reference, MaxCheckDepth, 0, seen) {
-				graph.Valid = false
-			}
-			/**/
-			ledgerStateCopy := make(map[string]int64)
-			for k2, v2 := range ledgerState {
-				ledgerStateCopy[k2] = v2
-			}
-			ledgerState = ledgerStateCopy
-			/**/
-
-			balance, ok := ledgerState[addrString]
-			if !ok {
-				balance, err := db.GetInt64(db.GetAddressKey(addr, db.KEY_BALANCE), nil)
-				if err != nil {
-					balance = 0
-				}
-				ledgerState[addrString] = balance
+	current := reference
+	for {
+		candidates := findApproverTails(current)
+		var next []byte
+		for len(candidates) > 0 {
+			i := chooseWeighted(candidates, weights, random)
+			candidate := candidates[i]
+			if !check.isBelowMaxDepth(candidate) && state.approve(candidate) {
+				next = candidate
+				break
 			}
+			candidates = append(candidates[:i], candidates[i+1:]...)
+		}
+		if next == nil {
+			return current
+		}
+		current = next
+	}
+}
 
-			result := balance + value
-			if result < 0 {
-				graph.Valid = false
-			}
-			ledgerState[addrString] = result
+func chooseWeighted(candidates [][]byte, weights map[string]int, random *rand.Rand) int {
+	highest := 0
+	for _, candidate := range candidates {
+		if weight := getWeight(candidate, weights); weight > highest {
+			highest = weight
+		}
+	}
+	var probabilities []float64
+	var sum float64 = 0
+	for _, candidate := range candidates {
+		probability := math.Exp(float64(getWeight(candidate, weights)-highest) * tipselAlpha)
+		probabilities = append(probabilities, probability)
+		sum += probability
+	}
+	randomNumber := random.Float64() * sum
+	for i, probability := range probabilities {
+		randomNumber -= probability
+		if randomNumber <= 0 {
+			return i
 		}
 	}
+	return len(candidates) - 1
+}
+
+func getWeight(key []byte, weights map[string]int) int {
+	weight, ok := weights[string(key)]
+	if !ok {
+		return 1
+	}
+	return weight
+}
 
-	for _, key := range approveeKeys {
-		stringKey := string(key)
-		var subGraph *GraphNode
-		graphRating, ok := (*graphRatings)[stringKey]
-		if ok {
-			subGraph = graphRating.Graph
-		} else {
-			subGraph = buildGraph(key, graphRatings, ledgerState, seen, graph.Valid)
-			(*graphRatings)[stringKey] = &GraphRating{0, subGraph}
+/*
+Returns the tails of the bundles of the approvers of the given transaction.
+*/
+func findApproverTails(key []byte) [][]byte {
+	var tails [][]byte
+	seen := make(map[string]bool)
+	for _, approver := range findApprovees(key) {
+		tail := findTail(approver)
+		if tail != nil && !seen[string(tail)] {
+			seen[string(tail)] = true
+			tails = append(tails, tail)
 		}
-		if !graph.Valid && subGraph.Valid {
-			subGraph.Valid = false
+	}
+	return tails
+}
+
+/*
+Returns the tail of the bundle of the given transaction, following the approvers
+that reference it as trunk with the previous index, or nil if the tail is not known.
+*/
+func findTail(key []byte) []byte {
+	tx := loadTX(key, nil)
+	for tx != nil && tx.CurrentIndex > 0 {
+		var previous []byte
+		var previousTX = tx
+		for _, approver := range findApprovees(key) {
+			approverTX := loadTX(approver, nil)
+			if approverTX != nil && approverTX.CurrentIndex == tx.CurrentIndex-1 &&
+				bytes.Equal(approverTX.Bundle, tx.Bundle) &&
+				bytes.Equal(db.GetByteKey(approverTX.TrunkTransaction, db.KEY_HASH), key) {
+				previous = approver
+				previousTX = approverTX
+				break
+			}
 		}
-		graph.Count += subGraph.Count
-		if graph.Count < 0 {
-			graph.Count = math.MaxInt64
+		if previous == nil {
+			return nil
 		}
-		graph.Children = append(graph.Children, subGraph)
+		key = previous
+		tx = previousTX
 	}
-	return graph
+	if tx == nil {
+		return nil
+	}
+	return key
 }
 
 func findApprovees(key []byte) [][]byte {
 	var response [][]byte
-	_ = db.DB.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = false
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		prefix := db.AsKey(key, db.KEY_APPROVEE)
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			response = append(response, db.AsKey(it.Item().Key()[16:], db.KEY_HASH))
-		}
-		return nil
+	_ = db.DB.View(func(txn db.Txn) error {
+		return txn.ForPrefix(db.AsKey(key, db.KEY_APPROVEE), false, func(k []byte, _ []byte) (bool, error) {
+			response = append(response, db.AsKey(k[16:], db.KEY_HASH))
+			return true, nil
+		})
 	})
 	return response
 }
 
-func hasMilestoneParent(reference []byte, maxDepth int, currentDepth int, seen map[string]bool) bool {
-	key := string(reference)
-	answer, has := seen[key]
-	if has {
-		return answer
-	}
-	if currentDepth >= maxDepth {
-		seen[key] = false
-		return false
-	}
-	if db.Has(db.AsKey(reference, db.KEY_CONFIRMED), nil) {
-		seen[key] = true
-		return true
-	}
-	/*/
-	timestamp, err := db.GetInt64(db.AsKey(reference, db.KEY_TIMESTAMP), nil)
-	if err != nil || (timestamp > 0 && time.Now().Sub(time.Unix(timestamp, 0)) > MaxTipAge)     {
-		seen[key] = false
-		return false
-	}
-	/**/
-	rel, err := db.GetBytes(db.AsKey(reference, db.KEY_RELATION), nil)
-	if err != nil {
-		seen[key] = false
-		return false
-	}
-	if bytes.Equal(rel[:16], rel[16:]) {
-		return false
-	}
-	trunk := db.AsKey(rel[:16], db.KEY_MILESTONE)
-	branch := db.AsKey(rel[16:], db.KEY_MILESTONE)
-	trunkOk := db.Has(trunk, nil) || hasMilestoneParent(trunk, maxDepth, currentDepth + 1, seen)
-	branchOk := db.Has(branch, nil) || hasMilestoneParent(branch, maxDepth, currentDepth + 1, seen)
-	ok := trunkOk && branchOk
-	seen[key] = ok
-	return ok
+/*
+Below-max-depth check against the milestone maxDepth milestones behind the latest solid milestone.
+Caches the results of all transactions analyzed, so it should only be used for a single tip selection or check.
+*/
+type depthCheck struct {
+	minIndex     int
+	minTimestamp int64
+	// true = not below max depth
+	cache map[string]bool
 }
 
-// 3. Calculate ratings
-
-func calculateRating(graph *GraphNode, seenKeys map[string][]byte) int {
-	rating := 1
-	if graph.Children != nil {
-		for _, child := range graph.Children {
-			stringKey := string(child.Key)
-			key, seen := seenKeys[stringKey]
-			if !seen {
-				seenKeys[stringKey] = key
-				rating += calculateRating(child, seenKeys)
-			}
-		}
-	}
-	return rating
+func newDepthCheck(maxDepth int) *depthCheck {
+	minIndex := getLatestSolidMilestone().Index - maxDepth
+	return &depthCheck{minIndex, getMilestoneTimestamp(minIndex), make(map[string]bool)}
 }
 
-// 3. Walk the graph
+/*
+Returns a check against the same milestone with its own cache, for use in another goroutine.
+*/
+func (check *depthCheck) fork() *depthCheck {
+	return &depthCheck{check.minIndex, check.minTimestamp, make(map[string]bool)}
+}
 
-func walkGraph(rating *GraphRating, ratings map[string]*GraphRating, exclude map[string]bool) *GraphRating {
-	if rating.Graph.Children == nil {
-		if rating.Graph.Index == 0 {
-			return rating
-		} else {
-			return nil
-		}
+/*
+Returns whether the transaction references, through its unconfirmed past cone, older milestones
+or transactions confirmed by older milestones. For transactions confirmed before the confirming
+milestone was recorded, or by an unknown one, their timestamp is compared with the one of the oldest
+allowed milestone.
+Also true if the past cone is not solid or too big to be analyzed.
+*/
+func (check *depthCheck) isBelowMaxDepth(key []byte) bool {
+	if ok, has := check.cache[string(key)]; has {
+		return !ok
 	}
 
-	// 1. Get weighted ratings
-	var highestRating = 0
-	var weightsSum float64 = 0
-	var weights []float64
-	for _, child := range rating.Graph.Children {
-		r := ratings[string(child.Key)].Rating
-		weights = append(weights, float64(r))
-		if r > highestRating {
-			highestRating = r
+	visited := make(map[string]bool)
+	stack := [][]byte{key}
+	below := false
+	for len(stack) > 0 && !below {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		k := string(current)
+		if visited[k] || bytes.Equal(current, tipHashKey) {
+			continue
 		}
-	}
-	for i := range weights {
-		weights[i] = math.Exp((weights[i] - float64(highestRating)) * tipAlpha)
-		weightsSum += weights[i]
-	}
-
-	// 2. Make weighted choice
-	rand.Seed(time.Now().UnixNano())
-	randomNumber := rand.Float64() * weightsSum
-
-	//randomNumber := float64(utils.Random(0, int(math.Floor(weightsSum))))
-	for i, child := range rating.Graph.Children {
-		randomNumber -= weights[i]
-		if !child.Valid {
+		if ok, has := check.cache[k]; has {
+			below = !ok
 			continue
 		}
-		_, ignore := exclude[string(child.Key)]
-		if ignore {
+		visited[k] = true
+		if len(visited) > maxDepthAnalyzedTransactions {
+			below = true
+			break
+		}
+
+		index, err := db.GetInt(db.AsKey(current, db.KEY_MILESTONE), nil)
+		if err == nil {
+			below = index < check.minIndex
 			continue
 		}
-		if randomNumber <= 0 {
-			// 3. Select random child
-			graph := walkGraph(ratings[string(child.Key)], ratings, exclude)
-			if graph != nil {
-				return graph
+		if db.Has(db.AsKey(current, db.KEY_CONFIRMED), nil) {
+			confirmation := GetConfirmation(current, nil)
+			if confirmation != nil && confirmation.MilestoneIndex > 0 {
+				below = confirmation.MilestoneIndex < check.minIndex
+				continue
 			}
+			timestamp, err := db.GetInt64(db.AsKey(current, db.KEY_TIMESTAMP), nil)
+			below = err != nil || timestamp < check.minTimestamp
+			continue
 		}
+		rel, err := db.GetBytes(db.AsKey(current, db.KEY_RELATION), nil)
+		if err != nil {
+			below = true
+			continue
+		}
+		stack = append(stack, db.AsKey(rel[:16], db.KEY_HASH), db.AsKey(rel[16:], db.KEY_HASH))
 	}
-	if rating.Graph.Index == 0 {
-		return rating
+
+	if below {
+		check.cache[string(key)] = false
 	} else {
-		return nil
+		for k := range visited {
+			check.cache[k] = true
+		}
+	}
+	return below
+}
+
+/*
+Returns the timestamp of the milestone with the given index, or zero if it is not known.
+*/
+func getMilestoneTimestamp(index int) int64 {
+	key := GetMilestoneKeyByIndex(index, false)
+	if key == nil {
+		return 0
 	}
+	timestamp, err := db.GetInt64(db.AsKey(key, db.KEY_TIMESTAMP), nil)
+	if err != nil {
+		return 0
+	}
+	return timestamp
 }
 
+// 3. Select the tips
+
+/*
+Returns a trunk and a branch transaction to approve. The tips are reached by random walks from the
+milestone depth milestones behind the latest one (or the given reference), run by the configured
+number of parallel walkers, until two of them are consistent with each other.
+*/
 func GetTXToApprove(reference []byte, depth int) [][]byte {
-	// Reference:
 	reference = getReference(reference, depth)
 	if reference == nil {
 		return nil
 	}
 
-	// Graph:
-	var seen = make(map[string]bool)
-	var ledgerState = make(map[string]int64)
-	var graphRatings = make(map[string]*GraphRating)
+	weights := getCumulativeWeights(reference)
+	check := newDepthCheck(MaxTipselDepth)
 
-	graph := buildGraph(reference, &graphRatings, ledgerState, seen, true)
-	graphRatings[string(reference)] = &GraphRating{0, graph}
-
-	for _, rating := range graphRatings {
-		seenRatings := make(map[string][]byte)
-		rating.Rating = calculateRating(rating.Graph, seenRatings)
-	}
-
-	var results = make(map[string][]byte)
-	var exclude = make(map[string]bool)
-	for x := 0; x < maxTipSearchRetries; x += 1 {
-		r := walkGraph(graphRatings[string(reference)], graphRatings, exclude)
-		if r != nil {
-			exclude[string(r.Graph.Key)] = true
-			hash, err := db.GetBytes(db.AsKey(r.Graph.Key, db.KEY_HASH), nil)
-			if err != nil {
-				continue
-			}
-			results[string(hash)] = hash
-			if len(results) >= 2 {
-				var answer [][]byte
-				for _, hash := range results {
-					answer = append(answer, hash)
-					if len(answer) == 2 {
-						return answer
-					}
+	var tips [][]byte
+	for x := 0; x < maxTipSearchRetries; x++ {
+		tips = append(tips, walk(reference, weights, check)...)
+		if len(tips) < 2 {
+			continue
+		}
+		for i := 1; i < len(tips); i++ {
+			if areConsistent(tips[0], tips[i]) {
+				trunk, err := db.GetBytes(db.AsKey(tips[0], db.KEY_HASH), nil)
+				if err != nil {
+					break
 				}
+				branch, err := db.GetBytes(db.AsKey(tips[i], db.KEY_HASH), nil)
+				if err != nil {
+					break
+				}
+				return [][]byte{trunk, branch}
 			}
-		} else {
 		}
+		tips = tips[1:]
 	}
 
 	logs.Log.Debug("Could not get TXs to approve")
 	return nil
 }
+
+/*
+Runs the configured number of random walks in parallel and returns the tails they reached.
+*/
+func walk(reference []byte, weights map[string]int, check *depthCheck) [][]byte {
+	var tips [][]byte
+	var tipsLocker = &sync.Mutex{}
+	var wg sync.WaitGroup
+	seed := time.Now().UnixNano()
+	for i := 0; i < tipselWalkers; i++ {
+		wg.Add(1)
+		go func(random *rand.Rand, check *depthCheck) {
+			defer wg.Done()
+			tip := randomWalk(reference, weights, check, random)
+			if tip != nil {
+				tipsLocker.Lock()
+				tips = append(tips, tip)
+				tipsLocker.Unlock()
+			}
+		}(rand.New(rand.NewSource(seed+int64(i))), check.fork())
+	}
+	wg.Wait()
+	return tips
+}
+
+func areConsistent(trunk []byte, branch []byte) bool {
+	state := newLedgerState()
+	return state.approve(trunk) && state.approve(branch)
+}
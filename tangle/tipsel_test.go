@@ -0,0 +1,45 @@
+package tangle
+
+import (
+	"testing"
+
+	"../db"
+)
+
+/*
+Saves a transaction confirmed by the given milestone (0 if unknown), with the given timestamp.
+*/
+func saveTestConfirmation(t *testing.T, name string, milestoneIndex int, timestamp int64) []byte {
+	key := saveTestConfirmedTX(t, name)
+	if err := db.Put(db.AsKey(key, db.KEY_TIMESTAMP), timestamp, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(db.AsKey(key, db.KEY_CONFIRMATION), []int64{int64(milestoneIndex), 0}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestUnknownConfirmingMilestoneUsesTimestamp(t *testing.T) {
+	db.DB = db.NewMemoryStore()
+	check := &depthCheck{10, 1000, make(map[string]bool)}
+
+	cases := []struct {
+		name           string
+		milestoneIndex int
+		timestamp      int64
+		below          bool
+	}{
+		{"KNOWNRECENT", 12, 0, false},
+		{"KNOWNOLD", 8, 2000, true},
+		{"UNKNOWNRECENT", 0, 2000, false},
+		{"UNKNOWNOLD", 0, 500, true},
+	}
+	for _, c := range cases {
+		confirmed := saveTestConfirmation(t, c.name, c.milestoneIndex, c.timestamp)
+		tip := saveTestTX(t, testTX{name: "TIP" + c.name, bundle: "TIP" + c.name, trunk: c.name, branch: c.name})
+		if check.isBelowMaxDepth(confirmed) != c.below || check.fork().isBelowMaxDepth(tip) != c.below {
+			t.Errorf("%v: below max depth is not %v", c.name, c.below)
+		}
+	}
+}
@@ -1,15 +1,16 @@
 package tangle
 
 import (
+	"time"
+
 	"../convert"
 	"../db"
 	"../logs"
 	"../transaction"
-	"github.com/dgraph-io/badger"
 	"github.com/pkg/errors"
 )
 
-func SaveTX(tx *transaction.FastTX, raw *[]byte, txn *badger.Txn) (e error) {
+func SaveTX(tx *transaction.FastTX, raw *[]byte, txn db.Txn) (e error) {
 	defer func() {
 		if err := recover(); err != nil {
 			e = errors.New("Failed saving TX!")
@@ -30,6 +31,8 @@ func SaveTX(tx *transaction.FastTX, raw *[]byte, txn *badger.Txn) (e error) {
 	_checkSaveError(tx, err)
 	err = db.Put(db.AsKey(key, db.KEY_ADDRESS_HASH), tx.Address, nil, txn)
 	_checkSaveError(tx, err)
+	err = db.Put(db.AsKey(key, db.KEY_ARRIVAL_TIME), time.Now().Unix(), nil, txn)
+	_checkSaveError(tx, err)
 	err = db.Put(
 		append(
 			db.GetByteKey(tx.Bundle, db.KEY_BUNDLE),
@@ -66,6 +69,14 @@ func SaveTX(tx *transaction.FastTX, raw *[]byte, txn *badger.Txn) (e error) {
 		false, nil, txn)
 	_checkSaveError(tx, err)
 
+	if permanode {
+		err = saveHistoryIndexes(key, tx, txn)
+		_checkSaveError(tx, err)
+	}
+
+	err = db.PutBytes(db.AsKey(key, db.KEY_EVENT_BUNDLE_PENDING), []byte{}, nil, txn)
+	_checkSaveError(tx, err)
+
 	err = updateTipsOnNewTransaction(tx, txn)
 	_checkSaveError(tx, err)
 	return nil
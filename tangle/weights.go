@@ -0,0 +1,134 @@
+package tangle
+
+import (
+	"math/bits"
+	"sync"
+
+	"../logs"
+)
+
+/*
+Cumulative weights (the transaction itself plus all transactions approving it, directly or indirectly)
+of the future cones of the tip selection entry points. The weight of a transaction does not depend
+on the entry point, so the cones of all entry points share one map. It is dropped with every new
+milestone. Transactions that arrived after the calculation are missing and count as weight 1.
+A map is never modified once returned, so the walkers can read it without locking: the weights
+of a new cone are merged into a copy that replaces it.
+*/
+type weightCache struct {
+	milestoneIndex int
+	weights        map[string]int
+}
+
+var weights = &weightCache{-1, nil}
+var weightsLocker = &sync.Mutex{}
+
+/*
+Returns the cumulative weights of the future cone of the given entry point, calculating them if needed.
+*/
+func getCumulativeWeights(entry []byte) map[string]int {
+	MilestoneLocker.Lock()
+	milestoneIndex := LatestMilestone.Index
+	MilestoneLocker.Unlock()
+
+	weightsLocker.Lock()
+	defer weightsLocker.Unlock()
+
+	if weights.milestoneIndex != milestoneIndex {
+		weights = &weightCache{milestoneIndex, make(map[string]int)}
+	}
+	if _, ok := weights.weights[string(entry)]; !ok {
+		cone := make(map[string]int)
+		calculateCumulativeWeights(entry, cone)
+		merged := make(map[string]int, len(weights.weights)+len(cone))
+		for key, weight := range weights.weights {
+			merged[key] = weight
+		}
+		for key, weight := range cone {
+			merged[key] = weight
+		}
+		weights = &weightCache{milestoneIndex, merged}
+		logs.Log.Debugf("Calculated cumulative weights for milestone %v: %v transactions",
+			weights.milestoneIndex, len(weights.weights))
+	}
+	return weights.weights
+}
+
+/*
+Pre-calculates the weights for the deepest tip selection entry point, which covers all shallower ones.
+*/
+func updateCumulativeWeights() {
+	entry := getReference(nil, MaxTipselDepth)
+	if entry != nil {
+		getCumulativeWeights(entry)
+	}
+}
+
+/*
+Calculates the cumulative weights of the future cone of the entry point in one pass.
+The transactions are sorted topologically, so that the approvers of each transaction are
+handled before it. Every transaction gets the set of its direct and indirect approvers as bitset,
+merged from the sets of its direct approvers, which are freed as soon as all their approvees are done.
+*/
+func calculateCumulativeWeights(entry []byte, result map[string]int) {
+	// 1. Collect and sort the future cone (depth-first, a transaction is added after its approvers)
+	approvers := make(map[string][][]byte)
+	positions := make(map[string]int)
+	var order [][]byte
+	stack := [][]byte{entry}
+	visiting := make(map[string]bool)
+	for len(stack) > 0 {
+		key := stack[len(stack)-1]
+		k := string(key)
+		if _, done := positions[k]; done {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		if !visiting[k] {
+			visiting[k] = true
+			approvers[k] = findApprovees(key)
+			for _, approver := range approvers[k] {
+				if !visiting[string(approver)] {
+					stack = append(stack, approver)
+				}
+			}
+			continue
+		}
+		stack = stack[:len(stack)-1]
+		positions[k] = len(order)
+		order = append(order, key)
+	}
+
+	// 2. Count the approvees inside the cone, to know when a set can be freed
+	references := make([]int, len(order))
+	for _, key := range order {
+		for _, approver := range approvers[string(key)] {
+			references[positions[string(approver)]]++
+		}
+	}
+
+	// 3. Merge the approver sets, approvers first
+	words := (len(order) + 63) / 64
+	sets := make([][]uint64, len(order))
+	for position, key := range order {
+		set := make([]uint64, words)
+		for _, approver := range approvers[string(key)] {
+			approverPosition := positions[string(approver)]
+			set[approverPosition/64] |= 1 << uint(approverPosition%64)
+			for i, word := range sets[approverPosition] {
+				set[i] |= word
+			}
+			references[approverPosition]--
+			if references[approverPosition] == 0 {
+				sets[approverPosition] = nil
+			}
+		}
+		sets[position] = set
+
+		weight := 1
+		for _, word := range set {
+			weight += bits.OnesCount64(word)
+		}
+		result[string(key)] = weight
+	}
+}
@@ -0,0 +1,70 @@
+package tangle
+
+import (
+	"testing"
+
+	"../db"
+)
+
+func getTestKey(name string) []byte {
+	return db.GetByteKey([]byte(name), db.KEY_HASH)
+}
+
+/*
+Saves the approvee relations of a hand-built DAG: each transaction with the ones it approves.
+*/
+func saveTestDAG(t *testing.T, approvees map[string][]string) {
+	for approver, approved := range approvees {
+		for _, a := range approved {
+			key := append(db.AsKey(getTestKey(a), db.KEY_APPROVEE), getTestKey(approver)...)
+			if err := db.Put(key, true, nil, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestCalculateCumulativeWeights(t *testing.T) {
+	db.DB = db.NewMemoryStore()
+	//   A <- B <- D <- E
+	//   A <- C <- D
+	saveTestDAG(t, map[string][]string{
+		"B": {"A"},
+		"C": {"A"},
+		"D": {"B", "C"},
+		"E": {"D"},
+	})
+
+	result := make(map[string]int)
+	calculateCumulativeWeights(getTestKey("A"), result)
+	expected := map[string]int{"A": 5, "B": 3, "C": 3, "D": 2, "E": 1}
+	if len(result) != len(expected) {
+		t.Errorf("Got weights for %v transactions, expected %v", len(result), len(expected))
+	}
+	for name, weight := range expected {
+		if result[string(getTestKey(name))] != weight {
+			t.Errorf("Transaction %v has weight %v, expected %v", name, result[string(getTestKey(name))], weight)
+		}
+	}
+}
+
+func TestCumulativeWeightsAreNotModified(t *testing.T) {
+	db.DB = db.NewMemoryStore()
+	saveTestDAG(t, map[string][]string{
+		"B": {"A"},
+		"Y": {"X"},
+	})
+	weights = &weightCache{-1, nil}
+
+	first := getCumulativeWeights(getTestKey("A"))
+	if len(first) != 2 {
+		t.Fatalf("Got weights for %v transactions, expected 2", len(first))
+	}
+	second := getCumulativeWeights(getTestKey("X"))
+	if len(first) != 2 {
+		t.Error("Returned weights were modified by another calculation")
+	}
+	if len(second) != 4 || second[string(getTestKey("B"))] != 1 || second[string(getTestKey("X"))] != 2 {
+		t.Errorf("Merged weights are wrong: %v", second)
+	}
+}
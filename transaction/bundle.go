@@ -2,12 +2,21 @@ package transaction
 
 import (
 	"bytes"
+	"errors"
 
 	"../convert"
 	"../crypt"
+	"../network"
 )
 
-var TOTAL_IOTAS int64 = 2779530283277761
+var (
+	ErrBundleIndexes   = errors.New("wrong transaction indexes in bundle")
+	ErrBundleValue     = errors.New("bundle value is not zero")
+	ErrBundleAddress   = errors.New("value transaction with an invalid address")
+	ErrBundleSupply    = errors.New("bundle value exceeds the total supply")
+	ErrBundleHash      = errors.New("bundle hash does not match the transactions")
+	ErrBundleSignature = errors.New("invalid bundle signature")
+)
 
 func IsValidBundleTrytes(trytes []string) bool {
 	// Get transaction objects
@@ -25,6 +34,14 @@ func IsValidBundleTrytes(trytes []string) bool {
 }
 
 func IsValidBundle(txs []*FastTX) bool {
+	return ValidateBundle(txs) == nil
+}
+
+/*
+Checks the indexes, value, bundle hash and signatures of a bundle.
+Returns nil for valid bundles, otherwise one of the ErrBundle errors.
+*/
+func ValidateBundle(txs []*FastTX) error {
 	// TODO: catch error, return false
 
 	// Get transaction objects
@@ -32,7 +49,7 @@ func IsValidBundle(txs []*FastTX) bool {
 	for _, tx := range txs {
 		t := convert.BytesToTrits(tx.Bytes)
 		if t == nil {
-			return false
+			return ErrBundleIndexes
 		}
 		trits[tx.CurrentIndex] = t
 	}
@@ -51,10 +68,10 @@ func IsValidBundle(txs []*FastTX) bool {
 
 				if value != 0 {
 					if convert.BytesToTrits(tx.Address)[:243][242] != 0 {
-						return false
+						return ErrBundleAddress
 					}
-					if value < -TOTAL_IOTAS || value > TOTAL_IOTAS {
-						return false
+					if value < -network.Current.TotalSupply || value > network.Current.TotalSupply {
+						return ErrBundleSupply
 					}
 				}
 
@@ -62,8 +79,11 @@ func IsValidBundle(txs []*FastTX) bool {
 		}
 	}
 	// Fail if order of indexes not correct or bundle value not zero
-	if len(otxs) != len(txs) || value != 0 {
-		return false
+	if len(otxs) != len(txs) {
+		return ErrBundleIndexes
+	}
+	if value != 0 {
+		return ErrBundleValue
 	}
 
 	// Create bundle hash from all transaction essences
@@ -79,7 +99,7 @@ func IsValidBundle(txs []*FastTX) bool {
 	kerl.Squeeze(bundleHash, 0, crypt.HASH_LENGTH)
 	for _, tx := range otxs {
 		if !bytes.Equal(tx.Bundle, convert.TritsToBytes(bundleHash)) {
-			return false
+			return ErrBundleHash
 		}
 	}
 
@@ -113,10 +133,10 @@ func IsValidBundle(txs []*FastTX) bool {
 			var addressTrits = make([]int, crypt.HASH_LENGTH)
 			kerl.Squeeze(addressTrits, 0, crypt.HASH_LENGTH)
 			if !bytes.Equal(address, convert.TritsToBytes(addressTrits)[:49]) {
-				return false
+				return ErrBundleSignature
 			}
 		}
 	}
 
-	return true
+	return nil
 }
@@ -1,6 +1,10 @@
 package transaction
 
-import "testing"
+import (
+	"testing"
+
+	"../convert"
+)
 
 var testTrytes = []string{
 	"9999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999RYPIATUXNWJMUUG9KWWQZSDGBRCZUYOPSCAVXOOPAUWXNRSGBIZWWFUTIJNEGCMIV9YIKKKIEMMI9YA9SD9999999999999999999999999ZRAVELING9IOTA9999999999999QRUBMYD99999999999B99999999FFVCSBYVH9YOSJCUTJRRAJJGJGKJRWRJSSJJRSFOGJQ9GVRGHGBL9D9OSWDWYSHA9JCJHDSJUVWVHNDLB9TFCGHTZ9HSDGEPFDMPSL9OZPCWTGZJNYSEPTAQAHFHCFJ9MVZZCLVIGUKJECHSECGMTYMXMBJTPA9999VTOZPDYGI9LNDWFIDCJMNJZSTCTEWRYJMEXRVWNWQCHEINGAKCNHTTHBKRQNKOPFNOQPQKYQ9HLCA9999TRAVELING9IOTA9999999999999NUEKXWBKE999999999L999999999LWEODPRGSRNJLEDBOYKUIXQAGF",
@@ -14,3 +18,25 @@ func TestBundleValidation(t *testing.T) {
 		t.Error("Invalid bundle!")
 	}
 }
+
+func TestValidateBundle(t *testing.T) {
+	var txs []*FastTX
+	for _, trytes := range testTrytes {
+		trits := convert.TrytesToTrits(trytes)
+		txs = append(txs, TritsToFastTX(&trits, convert.TrytesToBytes(trytes)))
+	}
+	if err := ValidateBundle(txs); err != nil {
+		t.Errorf("Valid bundle failed validation: %v", err)
+	}
+	if err := ValidateBundle(txs[1:]); err != ErrBundleIndexes {
+		t.Errorf("Expected %v for an incomplete bundle, got %v", ErrBundleIndexes, err)
+	}
+
+	tampered := testTrytes[len(testTrytes)-1]
+	tampered = tampered[:2295] + "TAMPERED9" + tampered[2304:]
+	trits := convert.TrytesToTrits(tampered)
+	txs[len(txs)-1] = TritsToFastTX(&trits, convert.TrytesToBytes(tampered))
+	if err := ValidateBundle(txs); err != ErrBundleHash {
+		t.Errorf("Expected %v for a tampered bundle, got %v", ErrBundleHash, err)
+	}
+}
@@ -1,6 +1,7 @@
 package transaction
 
 import (
+	"math/big"
 	"strings"
 
 	"../convert"
@@ -8,7 +9,6 @@ import (
 )
 
 const HASH_LENGTH = crypt.HASH_LENGTH
-const NUMBER_OF_KEYS_IN_MILESTONE = 20
 const NUMBER_OF_FRAGMENT_CHUNKS = 27
 const FRAGMENT_LENGTH = HASH_LENGTH * NUMBER_OF_FRAGMENT_CHUNKS
 const NUMBER_OF_SECURITY_LEVELS = 3
@@ -42,14 +42,7 @@ func Digest(normalizedBundleFragment []int, signatureFragment []int, nbOffset in
 	digest := make([]int, HASH_LENGTH)
 	buffer := make([]int, FRAGMENT_LENGTH)
 	copy(buffer, signatureFragment[sfOffset:sfOffset+FRAGMENT_LENGTH])
-	var hsh crypt.Hash
-	if asKerl {
-		hsh = new(crypt.Kerl)
-		hsh.Initialize()
-	} else {
-		hsh = new(crypt.Curl)
-		hsh.InitializeCurl(nil, 0, crypt.NUMBER_OF_ROUNDSP27)
-	}
+	hsh := newHash(asKerl)
 
 	for j := 0; j < NUMBER_OF_FRAGMENT_CHUNKS; j++ {
 		for k := normalizedBundleFragment[nbOffset+j] - MIN_TRYTE_VALUE; k > 0; k-- {
@@ -124,3 +117,149 @@ func GetMerkleRoot(hash []int, trits []int, offset int, indexIn int, size int) [
 	}
 	return hash
 }
+
+/*
+Derives the subseed for the given key index from the seed.
+*/
+func Subseed(seed []int, index int, asKerl bool) []int {
+	if len(seed) != HASH_LENGTH {
+		panic("Invalid seed length")
+	}
+	subseed := make([]int, HASH_LENGTH)
+	copy(subseed, seed)
+	// Add the index to the seed (balanced ternary)
+	indexTrits := convert.IntToTrits(big.NewInt(int64(index)), 0)
+	carry := 0
+	for j := 0; j < HASH_LENGTH && (carry != 0 || j < len(indexTrits)); j++ {
+		sum := subseed[j] + carry
+		if j < len(indexTrits) {
+			sum += indexTrits[j]
+		}
+		carry = 0
+		if sum > 1 {
+			sum -= 3
+			carry = 1
+		} else if sum < -1 {
+			sum += 3
+			carry = -1
+		}
+		subseed[j] = sum
+	}
+	hsh := newHash(asKerl)
+	hsh.Absorb(subseed, 0, HASH_LENGTH)
+	hsh.Squeeze(subseed, 0, HASH_LENGTH)
+	return subseed
+}
+
+/*
+Returns the private key for a subseed. The key has one fragment per security level.
+*/
+func Key(subseed []int, numberOfFragments int, asKerl bool) []int {
+	if len(subseed) != HASH_LENGTH {
+		panic("Invalid subseed length")
+	}
+	key := make([]int, FRAGMENT_LENGTH*numberOfFragments)
+	hsh := newHash(asKerl)
+	hsh.Absorb(subseed, 0, HASH_LENGTH)
+	for offset := 0; offset < len(key); offset += HASH_LENGTH {
+		hsh.Squeeze(key, offset, HASH_LENGTH)
+	}
+	return key
+}
+
+/*
+Returns the digests of all key fragments. Address(Digests(key)) is the address of the key.
+*/
+func Digests(key []int, asKerl bool) []int {
+	if len(key) == 0 || len(key)%FRAGMENT_LENGTH != 0 {
+		panic("Invalid key length")
+	}
+	digests := make([]int, len(key)/FRAGMENT_LENGTH*HASH_LENGTH)
+	buffer := make([]int, FRAGMENT_LENGTH)
+	hsh := newHash(asKerl)
+	for i := 0; i < len(key)/FRAGMENT_LENGTH; i++ {
+		copy(buffer, key[i*FRAGMENT_LENGTH:(i+1)*FRAGMENT_LENGTH])
+		for j := 0; j < NUMBER_OF_FRAGMENT_CHUNKS; j++ {
+			for k := 0; k < MAX_TRYTE_VALUE-MIN_TRYTE_VALUE; k++ {
+				hsh.Reset()
+				hsh.Absorb(buffer, j*HASH_LENGTH, HASH_LENGTH)
+				hsh.Squeeze(buffer, j*HASH_LENGTH, HASH_LENGTH)
+			}
+		}
+		hsh.Reset()
+		hsh.Absorb(buffer, 0, FRAGMENT_LENGTH)
+		hsh.Squeeze(digests, i*HASH_LENGTH, HASH_LENGTH)
+	}
+	return digests
+}
+
+/*
+Signs a normalized bundle fragment with a key fragment. The result can be verified with Digest.
+*/
+func SignatureFragment(normalizedBundleFragment []int, keyFragment []int, asKerl bool) []int {
+	if len(keyFragment) != FRAGMENT_LENGTH {
+		panic("Invalid key fragment length")
+	}
+	signature := make([]int, FRAGMENT_LENGTH)
+	copy(signature, keyFragment)
+	hsh := newHash(asKerl)
+	for j := 0; j < NUMBER_OF_FRAGMENT_CHUNKS; j++ {
+		for k := 0; k < MAX_TRYTE_VALUE-normalizedBundleFragment[j]; k++ {
+			hsh.Reset()
+			hsh.Absorb(signature, j*HASH_LENGTH, HASH_LENGTH)
+			hsh.Squeeze(signature, j*HASH_LENGTH, HASH_LENGTH)
+		}
+	}
+	return signature
+}
+
+/*
+Builds a Merkle tree over the given leaves. The number of leaves has to be a power of 2.
+Returns all layers, starting with the leaves and ending with the root layer.
+Leaves and nodes are hashes packed into bytes (49 bytes each), a tree of trits would take 40 times the memory.
+*/
+func GetMerkleTree(leaves [][]byte) [][][]byte {
+	if len(leaves) == 0 || len(leaves)&(len(leaves)-1) != 0 {
+		panic("Invalid number of Merkle tree leaves")
+	}
+	layers := [][][]byte{leaves}
+	curl := new(crypt.Curl)
+	curl.InitializeCurl(nil, 0, crypt.NUMBER_OF_ROUNDSP27)
+	node := make([]int, HASH_LENGTH)
+	for layer := leaves; len(layer) > 1; {
+		next := make([][]byte, len(layer)/2)
+		for i := 0; i < len(layer); i += 2 {
+			curl.Reset()
+			curl.Absorb(convert.BytesToTrits(layer[i])[:HASH_LENGTH], 0, HASH_LENGTH)
+			curl.Absorb(convert.BytesToTrits(layer[i+1])[:HASH_LENGTH], 0, HASH_LENGTH)
+			curl.Squeeze(node, 0, HASH_LENGTH)
+			next[i/2] = convert.TritsToBytes(node)[:49]
+		}
+		layers = append(layers, next)
+		layer = next
+	}
+	return layers
+}
+
+/*
+Returns the concatenated siblings of a leaf as trits, as needed by GetMerkleRoot.
+*/
+func GetMerkleSiblings(tree [][][]byte, index int) []int {
+	var siblings []int
+	for _, layer := range tree[:len(tree)-1] {
+		siblings = append(siblings, convert.BytesToTrits(layer[index^1])[:HASH_LENGTH]...)
+		index >>= 1
+	}
+	return siblings
+}
+
+func newHash(asKerl bool) crypt.Hash {
+	if asKerl {
+		hsh := new(crypt.Kerl)
+		hsh.Initialize()
+		return hsh
+	}
+	hsh := new(crypt.Curl)
+	hsh.InitializeCurl(nil, 0, crypt.NUMBER_OF_ROUNDSP27)
+	return hsh
+}
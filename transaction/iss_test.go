@@ -5,6 +5,7 @@ import (
 	"reflect"
 
 	"../convert"
+	"../network"
 )
 
 const cooAddress = "KPWCHICGJZXKE9GSUDXZYUAPLHAKAHYHDXNPHENTERYMMBQOPSQIDENXKLKCEYCPVTZQLEEJVYJZV9BWU"
@@ -27,9 +28,44 @@ func TestGetMerkleRoot(t *testing.T) {
 		t1Trits,
 		0,
 		milestoneIndex,
-		NUMBER_OF_KEYS_IN_MILESTONE,
+		network.Mainnet.MilestoneDepth,
 	)
 	if !reflect.DeepEqual(convert.TritsToTrytes(merkleRoot), cooAddress) {
 		t.Error("NO milestone detected!")
 	}
 }
+
+func TestSignatureFragment(t *testing.T) {
+	seed := convert.TrytesToTrits(cooAddress)
+	key := Key(Subseed(seed, 3, false), 2, false)
+	address := Address(Digests(key, false))
+
+	hash := convert.TrytesToTrits(TrytesToObject(trytes0).TrunkTransaction)
+	normalized := NormalizedBundle(hash)
+	var digests []int
+	for i := 0; i < 2; i++ {
+		offset := i * NUMBER_OF_FRAGMENT_CHUNKS
+		signature := SignatureFragment(normalized[offset:offset+NUMBER_OF_FRAGMENT_CHUNKS], key[i*FRAGMENT_LENGTH:(i+1)*FRAGMENT_LENGTH], false)
+		digests = append(digests, Digest(normalized, signature, offset, 0, false)...)
+	}
+	if !reflect.DeepEqual(Address(digests), address) {
+		t.Error("Signature does not match the key address!")
+	}
+}
+
+func TestGetMerkleTree(t *testing.T) {
+	seed := convert.TrytesToTrits(cooAddress)
+	var leaves [][]byte
+	for i := 0; i < 4; i++ {
+		leaves = append(leaves, convert.TritsToBytes(Subseed(seed, i, false))[:49])
+	}
+	tree := GetMerkleTree(leaves)
+	root := convert.BytesToTrits(tree[len(tree)-1][0])[:HASH_LENGTH]
+	for i, leaf := range leaves {
+		hash := convert.BytesToTrits(leaf)[:HASH_LENGTH]
+		merkleRoot := GetMerkleRoot(hash, GetMerkleSiblings(tree, i), 0, i, len(tree)-1)
+		if !reflect.DeepEqual(merkleRoot, root) {
+			t.Errorf("Wrong Merkle root for leaf %v", i)
+		}
+	}
+}
@@ -41,6 +41,7 @@ type FastTX struct {
 	Timestamp                int
 	TXTimestamp              int
 	CurrentIndex             int
+	LastIndex                int
 	TrunkTransaction         []byte
 	BranchTransaction        []byte
 	Bundle                   []byte
@@ -58,6 +59,7 @@ func TritsToTX(trits *[]int, raw []byte) *FastTX {
 		Timestamp:                int(value64((*trits)[7857:7884]) / 1000),
 		TXTimestamp:              value((*trits)[6966:6993]),
 		CurrentIndex:             value((*trits)[6993:7020]),
+		LastIndex:                value((*trits)[7020:7047]),
 		TrunkTransaction:         convert.TritsToBytes((*trits)[7290:7533])[:49],
 		BranchTransaction:        convert.TritsToBytes((*trits)[7533:7776])[:49],
 		Bundle:                   convert.TritsToBytes((*trits)[7047:7290])[:49],
@@ -76,6 +78,7 @@ func TritsToFastTX(trits *[]int, raw []byte) *FastTX {
 		Timestamp:                int(value64((*trits)[7857:7884]) / 1000),
 		TXTimestamp:              value((*trits)[6966:6993]),
 		CurrentIndex:             value((*trits)[6993:7020]),
+		LastIndex:                value((*trits)[7020:7047]),
 		TrunkTransaction:         convert.TritsToBytes((*trits)[7290:7533])[:49],
 		BranchTransaction:        convert.TritsToBytes((*trits)[7533:7776])[:49],
 		Bundle:                   convert.TritsToBytes((*trits)[7047:7290])[:49],